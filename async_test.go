@@ -9,10 +9,15 @@
 package predator
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-predator/predator/context"
 	. "github.com/smartystreets/goconvey/convey"
@@ -114,3 +119,211 @@ func TestAsync(t *testing.T) {
 		crawler.ClearCache()
 	})
 }
+
+// waitTimeout reports whether `Wait` returns within `d`
+func waitTimeout(c *Crawler, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		c.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func TestWaitGroupAccounting(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	Convey("测试所有任务结束后 Wait 都能返回", t, func() {
+		Convey("中断请求", func() {
+			c := NewCrawler(WithConcurrency(5, false))
+			c.BeforeRequest(func(r *Request) {
+				r.Abort()
+			})
+
+			for i := 0; i < 20; i++ {
+				So(c.Get(ts.URL), ShouldBeNil)
+			}
+			So(waitTimeout(c, 5*time.Second), ShouldBeTrue)
+		})
+
+		Convey("协程池已关闭", func() {
+			c := NewCrawler(WithConcurrency(5, false))
+			So(c.Get(ts.URL), ShouldBeNil)
+
+			c.goPool.Close()
+			for i := 0; i < 5; i++ {
+				So(c.Get(ts.URL), ShouldEqual, ErrPoolAlreadyClosed)
+			}
+			So(waitTimeout(c, 5*time.Second), ShouldBeTrue)
+		})
+
+		Convey("处理函数 panic", func() {
+			c := NewCrawler(WithConcurrency(5, true))
+			c.AfterResponse(func(r *Response) {
+				panic(errors.New("handler panic"))
+			})
+
+			for i := 0; i < 10; i++ {
+				So(c.Get(ts.URL), ShouldBeNil)
+			}
+			So(waitTimeout(c, 5*time.Second), ShouldBeTrue)
+		})
+	})
+}
+
+func TestRequestPriority(t *testing.T) {
+	var (
+		lock  sync.Mutex
+		paths []string
+	)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			<-release
+		}
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	Convey("测试请求优先级", t, func() {
+		for _, priority := range []int{0, -1} {
+			paths = nil
+			c := NewCrawler(WithConcurrency(1, false))
+			c.BeforeRequest(func(r *Request) {
+				if strings.HasSuffix(r.URL(), "/list") {
+					r.SetPriority(priority)
+				}
+			})
+
+			// 唯一的工作协程被阻塞时，列表页和详情页依次入队
+			So(c.Get(ts.URL+"/block"), ShouldBeNil)
+			time.Sleep(50 * time.Millisecond)
+			So(c.Get(ts.URL+"/list"), ShouldBeNil)
+			go c.Get(ts.URL + "/detail")
+			time.Sleep(50 * time.Millisecond)
+
+			release <- struct{}{}
+			c.Wait()
+
+			if priority < 0 {
+				So(paths, ShouldResemble, []string{"/block", "/detail", "/list"})
+			} else {
+				So(paths, ShouldResemble, []string{"/block", "/list", "/detail"})
+			}
+		}
+	})
+}
+
+func TestGetAll(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/slow") {
+			time.Sleep(100 * time.Millisecond)
+		}
+		fmt.Fprintf(w, "%s %s", r.Method, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	Convey("测试批量请求", t, func() {
+		for _, opts := range [][]CrawlerOption{nil, {WithConcurrency(5, false)}} {
+			c := NewCrawler(opts...)
+
+			c.BeforeRequest(func(r *Request) {
+				if strings.Contains(r.URL(), "/slow") {
+					r.SetTimeout(10 * time.Millisecond)
+				}
+			})
+
+			var (
+				lock   sync.Mutex
+				bodies []string
+			)
+			c.AfterResponse(func(r *Response) {
+				lock.Lock()
+				bodies = append(bodies, r.String())
+				lock.Unlock()
+			})
+
+			urls := []string{ts.URL + "/slow", "http://[::1/"}
+			for i := 0; i < 20; i++ {
+				urls = append(urls, fmt.Sprintf("%s/%d", ts.URL, i))
+			}
+
+			err := c.GetAll(urls)
+			So(bodies, ShouldHaveLength, 20)
+
+			var batchErr *BatchError
+			So(errors.As(err, &batchErr), ShouldBeTrue)
+			So(batchErr.Errors, ShouldHaveLength, 2)
+			So(batchErr.Errors[ts.URL+"/slow"], ShouldEqual, ErrTimeout)
+			So(batchErr.Errors["http://[::1/"], ShouldNotBeNil)
+
+			// 批量请求结束后爬虫仍然可用
+			bodies = nil
+			err = c.PostAll([]string{ts.URL + "/a", ts.URL + "/b"}, map[string]string{"k": "v"})
+			So(err, ShouldBeNil)
+			So(bodies, ShouldHaveLength, 2)
+			So(bodies, ShouldContain, "POST /a")
+		}
+	})
+
+	Convey("测试停止爬虫时的批量请求", t, func() {
+		c := NewCrawler(WithConcurrency(1, false))
+
+		c.AfterResponse(func(r *Response) {
+			c.Stop()
+		})
+
+		urls := make([]string, 10)
+		for i := range urls {
+			urls[i] = fmt.Sprintf("%s/slow/%d", ts.URL, i)
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- c.GetAll(urls)
+		}()
+
+		select {
+		case err := <-done:
+			var batchErr *BatchError
+			So(errors.As(err, &batchErr), ShouldBeTrue)
+			So(len(batchErr.Errors), ShouldBeGreaterThan, 0)
+			for _, err := range batchErr.Errors {
+				// 停止后才加入的请求不能放入协程池
+				So(err, ShouldBeIn, ErrCrawlerStopped, ErrPoolAlreadyClosed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("GetAll doesn't return after the crawler is stopped")
+		}
+	})
+}
+
+func BenchmarkTaskQueue(b *testing.B) {
+	p, _ := NewPool(64)
+	tasks := make([]*Task, 64)
+	for i := range tasks {
+		tasks[i] = &Task{req: &Request{priority: i % 3}}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, task := range tasks {
+			p.push(task)
+		}
+		for range tasks {
+			p.pop()
+		}
+	}
+}
@@ -11,6 +11,8 @@ package predator
 import (
 	"fmt"
 	"net/url"
+	"sync"
+	"time"
 )
 
 type Cache interface {
@@ -25,10 +27,72 @@ type Cache interface {
 	IsCached(key string) ([]byte, bool)
 	// 将没有缓存过的请求保存到缓存中
 	Cache(key string, val []byte) error
+	// 删除一个缓存，缓存不存在时不返回错误
+	Delete(key string) error
 	// 清除全部缓存
 	Clear() error
 }
 
+// Pinger is an optional interface of the caches, which checks whether the
+// cache backend is reachable, such as a redis server or a database. It isn't
+// a method of `Cache`, so the existing caches still work.
+//
+// The caches implementing it are pinged by `WithCache` and `SetCache` after
+// `Init`, so the misconfigured backends are found before crawling.
+type Pinger interface {
+	Ping() error
+}
+
+// pingCache pings the cache if it implements `Pinger`
+func pingCache(cc Cache) error {
+	p, ok := cc.(Pinger)
+	if !ok {
+		return nil
+	}
+
+	if err := p.Ping(); err != nil {
+		return fmt.Errorf("the cache is unreachable: %w", err)
+	}
+	return nil
+}
+
+// CacheEntry is a cache to be written by `BatchCache`
+type CacheEntry struct {
+	Key   string
+	Value []byte
+	// The ttl set by `Response.SetCacheTTL`, 0 means using the default one
+	// of the cache
+	TTL time.Duration
+}
+
+// BatchCache is an optional interface of the caches, which writes multiple
+// caches at once, such as with a multi-row insert of a database. The ttl of
+// the entries should be honored if the cache supports it.
+//
+// It is used by the async cache, see `WithAsyncCache`, to write the caches
+// queued at the same time together, which is much faster than writing them
+// one by one for the remote backends.
+type BatchCache interface {
+	CacheMany(entries []CacheEntry) error
+}
+
+// Iterable is an optional interface of the caches, which iterates all the
+// caches with `f` and stops on the first error returned by `f`, such as with
+// SCAN of redis or walking the files. The values should be the same as the
+// ones returned by `Cache.IsCached`.
+//
+// It is required by `Crawler.ExportCache`.
+type Iterable interface {
+	Iterate(f func(key string, val []byte) error) error
+}
+
+// TTLCache is an optional interface of the caches supporting the expiration
+// of each cache, such as redis. It is used instead of `Cache.Cache` to write
+// the responses with a ttl set by `Response.SetCacheTTL`.
+type TTLCache interface {
+	CacheWithTTL(key string, val []byte, ttl time.Duration) error
+}
+
 type CacheModel struct {
 	Key   string `gorm:"primaryKey"`
 	Value []byte
@@ -72,3 +136,102 @@ func NewQueryParamField(field string) CacheField {
 func NewRequestBodyParamField(field string) CacheField {
 	return CacheField{requestBodyParam, field}
 }
+
+// asyncCacheWriter writes the caches in a background goroutine, so the
+// workers don't wait for the slow cache backends, see `WithAsyncCache`
+type asyncCacheWriter struct {
+	lock    sync.RWMutex
+	closed  bool
+	entries chan CacheEntry
+
+	// 还没有写入的缓存数量。刷新时仍可能有新的缓存排队，
+	// 不能使用 sync.WaitGroup
+	pendingLock sync.Mutex
+	pending     int
+	drained     *sync.Cond
+}
+
+// newAsyncCacheWriter creates a writer with a buffer of `size` entries.
+// The entries queued at the same time are written together by `write`, and
+// there are at most `size` entries in a batch.
+func newAsyncCacheWriter(size int, write func(entries []CacheEntry)) *asyncCacheWriter {
+	w := &asyncCacheWriter{entries: make(chan CacheEntry, size)}
+	w.drained = sync.NewCond(&w.pendingLock)
+
+	go func() {
+		batch := make([]CacheEntry, 0, size)
+		for e := range w.entries {
+			batch = append(batch[:0], e)
+
+			// 不等待新的缓存，只取出已经排队的缓存
+		collect:
+			for len(batch) < size {
+				select {
+				case e, ok := <-w.entries:
+					if !ok {
+						break collect
+					}
+					batch = append(batch, e)
+				default:
+					break collect
+				}
+			}
+
+			write(batch)
+			w.done(len(batch))
+		}
+	}()
+
+	return w
+}
+
+// put queues a cache write, which blocks when the buffer is full. It returns
+// false if the writer has been closed, then the cache should be written
+// synchronously.
+func (w *asyncCacheWriter) put(entry CacheEntry) bool {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	if w.closed {
+		return false
+	}
+
+	w.pendingLock.Lock()
+	w.pending++
+	w.pendingLock.Unlock()
+
+	w.entries <- entry
+	return true
+}
+
+// done marks `n` queued cache writes as written
+func (w *asyncCacheWriter) done(n int) {
+	w.pendingLock.Lock()
+	w.pending -= n
+	if w.pending == 0 {
+		w.drained.Broadcast()
+	}
+	w.pendingLock.Unlock()
+}
+
+// flush waits for the queued cache writes, the writer can still be used
+// after that
+func (w *asyncCacheWriter) flush() {
+	w.pendingLock.Lock()
+	for w.pending > 0 {
+		w.drained.Wait()
+	}
+	w.pendingLock.Unlock()
+}
+
+// close stops queuing the cache writes and waits for the queued ones
+func (w *asyncCacheWriter) close() {
+	w.lock.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.entries)
+	}
+	w.lock.Unlock()
+
+	w.flush()
+}
@@ -0,0 +1,767 @@
+package predator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-predator/log"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var errCacheFailed = errors.New("cache failed")
+
+// memoryCache 是仅用于测试的内存缓存，可以模拟缓存出错的情况
+type memoryCache struct {
+	m         sync.Map
+	failWrite bool
+	// 模拟较慢的缓存后端
+	writeDelay time.Duration
+}
+
+func (mc *memoryCache) Compressed(yes bool) {}
+
+func (mc *memoryCache) Init() error {
+	return nil
+}
+
+func (mc *memoryCache) IsCached(key string) ([]byte, bool) {
+	val, ok := mc.m.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return val.([]byte), true
+}
+
+func (mc *memoryCache) Cache(key string, val []byte) error {
+	if mc.failWrite {
+		return errCacheFailed
+	}
+	time.Sleep(mc.writeDelay)
+	mc.m.Store(key, val)
+	return nil
+}
+
+func (mc *memoryCache) Delete(key string) error {
+	mc.m.Delete(key)
+	return nil
+}
+
+func (mc *memoryCache) Iterate(f func(key string, val []byte) error) error {
+	var err error
+	mc.m.Range(func(key, val any) bool {
+		err = f(key.(string), val.([]byte))
+		return err == nil
+	})
+	return err
+}
+
+func (mc *memoryCache) Clear() error {
+	mc.m.Range(func(key, _ any) bool {
+		mc.m.Delete(key)
+		return true
+	})
+	return nil
+}
+
+// pingerCache 是可以检查连接的缓存
+type pingerCache struct {
+	memoryCache
+	pings int
+	err   error
+}
+
+func (pc *pingerCache) Ping() error {
+	pc.pings++
+	return pc.err
+}
+
+func TestPingCache(t *testing.T) {
+	Convey("测试检查缓存的连接", t, func() {
+		Convey("可以连接的缓存", func() {
+			pc := new(pingerCache)
+			c := NewCrawler(WithCache(pc, false, nil))
+			So(pc.pings, ShouldEqual, 1)
+
+			So(c.PingCache(), ShouldBeNil)
+			So(pc.pings, ShouldEqual, 2)
+
+			c.SetCache(pc, false, nil)
+			So(pc.pings, ShouldEqual, 3)
+		})
+
+		Convey("不能连接的缓存", func() {
+			pc := &pingerCache{err: errCacheFailed}
+			So(func() { NewCrawler(WithCache(pc, false, nil)) }, ShouldPanic)
+			So(func() { NewCrawler().SetCache(pc, false, nil) }, ShouldPanic)
+
+			c := NewCrawler(WithCache(new(memoryCache), false, nil))
+			c.cache = pc
+			So(errors.Is(c.PingCache(), errCacheFailed), ShouldBeTrue)
+		})
+
+		Convey("没有实现 Pinger 的缓存", func() {
+			So(NewCrawler(WithCache(new(memoryCache), false, nil)).PingCache(), ShouldBeNil)
+			So(NewCrawler().PingCache(), ShouldEqual, ErrNoCache)
+		})
+	})
+}
+
+// brokenCache 是读取时出错的缓存
+type brokenCache struct {
+	memoryCache
+	panicOnRead bool
+}
+
+func (bc *brokenCache) IsCached(key string) ([]byte, bool) {
+	if bc.panicOnRead {
+		panic("connection refused")
+	}
+	// 损坏的缓存
+	return []byte("{"), true
+}
+
+func TestCacheReadError(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试读取缓存出错", t, func() {
+		for _, panicOnRead := range []bool{false, true} {
+			Convey(fmt.Sprintf("默认当作未缓存 panic=%v", panicOnRead), func() {
+				c := NewCrawler(WithCache(&brokenCache{panicOnRead: panicOnRead}, false, nil))
+
+				var fromCache []bool
+				c.AfterResponse(func(r *Response) {
+					fromCache = append(fromCache, r.FromCache)
+				})
+
+				So(c.Get(ts.URL), ShouldBeNil)
+				So(fromCache, ShouldResemble, []bool{false})
+			})
+
+			Convey(fmt.Sprintf("请求失败 panic=%v", panicOnRead), func() {
+				c := NewCrawler(
+					WithCache(&brokenCache{panicOnRead: panicOnRead}, false, nil),
+					WithCacheFailOpen(false),
+				)
+
+				var handled bool
+				c.AfterResponse(func(r *Response) {
+					handled = true
+				})
+
+				So(c.Get(ts.URL), ShouldWrap, ErrCacheRead)
+				So(handled, ShouldBeFalse)
+			})
+		}
+	})
+}
+
+func TestCacheWriteError(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试并发时缓存写入失败不会导致死锁", t, func() {
+		c := NewCrawler(
+			WithConcurrency(5, false),
+			WithCache(&memoryCache{failWrite: true}, false, nil),
+		)
+
+		// 死锁时 Put 也会被阻塞，所以发出请求和等待都要放在协程中
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 20; i++ {
+				c.Get(fmt.Sprintf("%s/?page=%d", ts.URL, i))
+			}
+			c.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the crawler is deadlocked after a cache write error")
+		}
+
+		// 锁已经被释放，可以再次获取
+		c.Lock()
+		c.Unlock()
+	})
+}
+
+func TestAsyncCache(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	count := func(mc *memoryCache) int {
+		n := 0
+		mc.m.Range(func(_, _ any) bool {
+			n++
+			return true
+		})
+		return n
+	}
+
+	Convey("测试异步写入缓存", t, func() {
+		mc := &memoryCache{writeDelay: 10 * time.Millisecond}
+		// 缓冲区小于请求数量，写满时工作协程会等待
+		c := NewCrawler(
+			WithConcurrency(5, false),
+			WithCache(mc, false, nil),
+			WithAsyncCache(4),
+		)
+
+		for i := 0; i < 20; i++ {
+			So(c.Get(fmt.Sprintf("%s/?page=%d", ts.URL, i)), ShouldBeNil)
+		}
+		c.Wait()
+
+		So(count(mc), ShouldEqual, 20)
+	})
+
+	Convey("测试同步的爬虫异步写入缓存", t, func() {
+		mc := &memoryCache{writeDelay: 50 * time.Millisecond}
+		c := NewCrawler(WithCache(mc, false, nil), WithAsyncCache(10))
+
+		for i := 0; i < 5; i++ {
+			So(c.Get(fmt.Sprintf("%s/?page=%d", ts.URL, i)), ShouldBeNil)
+		}
+		c.FlushCache()
+		So(count(mc), ShouldEqual, 5)
+
+		// 刷新后仍然异步写入缓存
+		So(c.Get(ts.URL+"/?page=5"), ShouldBeNil)
+		So(count(mc), ShouldEqual, 5)
+		c.FlushCache()
+		So(count(mc), ShouldEqual, 6)
+
+		// 停止后同步写入缓存
+		c.Stop()
+		So(c.storeCache(CacheEntry{Key: "stopped", Value: []byte("ok")}), ShouldBeNil)
+		So(count(mc), ShouldEqual, 7)
+	})
+
+	Convey("测试克隆的爬虫使用自己的异步写入器", t, func() {
+		mc := &memoryCache{writeDelay: 50 * time.Millisecond}
+		c := NewCrawler(WithCache(mc, false, nil), WithAsyncCache(10))
+
+		nc := c.Clone()
+		So(nc.asyncCache, ShouldNotBeNil)
+		So(nc.asyncCache, ShouldNotPointTo, c.asyncCache)
+
+		// 停止克隆的爬虫不影响原爬虫的异步写入
+		nc.Stop()
+		So(c.Get(ts.URL), ShouldBeNil)
+		So(count(mc), ShouldEqual, 0)
+		c.FlushCache()
+		So(count(mc), ShouldEqual, 1)
+	})
+
+	Convey("测试异步写入缓存失败", t, func() {
+		c := NewCrawler(WithCache(&memoryCache{failWrite: true}, false, nil), WithAsyncCache(10))
+
+		// 异步写入的错误只会记录到日志中
+		So(c.Get(ts.URL), ShouldBeNil)
+		c.FlushCache()
+		So(c.Get(ts.URL+"/?page=1"), ShouldBeNil)
+		c.FlushCache()
+
+		c.Stop()
+		So(c.storeCache(CacheEntry{Key: "stopped"}), ShouldEqual, errCacheFailed)
+	})
+}
+
+// batchCache 是可以批量写入的缓存，每次批量写入只等待一次
+type batchCache struct {
+	memoryCache
+	lock    sync.Mutex
+	batches []int
+}
+
+func (bc *batchCache) CacheMany(entries []CacheEntry) error {
+	time.Sleep(bc.writeDelay)
+	for _, e := range entries {
+		bc.m.Store(e.Key, e.Value)
+	}
+
+	bc.lock.Lock()
+	bc.batches = append(bc.batches, len(entries))
+	bc.lock.Unlock()
+	return nil
+}
+
+func TestBatchCache(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试批量写入缓存", t, func() {
+		bc := &batchCache{memoryCache: memoryCache{writeDelay: 20 * time.Millisecond}}
+		c := NewCrawler(
+			WithConcurrency(10, false),
+			WithCache(bc, false, nil),
+			WithAsyncCache(8),
+		)
+
+		for i := 0; i < 40; i++ {
+			So(c.Get(fmt.Sprintf("%s/?page=%d", ts.URL, i)), ShouldBeNil)
+		}
+		// 关闭时写入所有排队的缓存
+		c.Wait()
+
+		n := 0
+		bc.m.Range(func(_, _ any) bool {
+			n++
+			return true
+		})
+		So(n, ShouldEqual, 40)
+
+		total, maxBatch := 0, 0
+		for _, size := range bc.batches {
+			total += size
+			if size > maxBatch {
+				maxBatch = size
+			}
+		}
+		So(total, ShouldBeLessThanOrEqualTo, 40)
+		So(maxBatch, ShouldBeBetweenOrEqual, 2, 8)
+	})
+}
+
+func TestResponseCacheControl(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("status") != "" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	Convey("测试在响应处理器中控制缓存", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+
+			switch string(r.Request.uri.Path()) {
+			case "/no-cache":
+				r.DoNotCache()
+			case "/force":
+				r.ForceCache()
+			case "/both":
+				r.ForceCache()
+				r.DoNotCache()
+			}
+		})
+
+		for i := 0; i < 2; i++ {
+			// 满足缓存条件但不缓存
+			So(c.Get(ts.URL+"/no-cache"), ShouldBeNil)
+			// 不满足缓存条件但强制缓存
+			So(c.Get(ts.URL+"/force?status=404"), ShouldBeNil)
+			// 最后一次调用生效
+			So(c.Get(ts.URL+"/both"), ShouldBeNil)
+			// 只使用缓存条件
+			So(c.Get(ts.URL+"/default"), ShouldBeNil)
+			So(c.Get(ts.URL+"/default?status=404"), ShouldBeNil)
+		}
+
+		So(fromCache, ShouldResemble, []bool{
+			false, false, false, false, false,
+			false, true, false, true, false,
+		})
+	})
+}
+
+// ttlCache 是支持过期时间的缓存
+type ttlCache struct {
+	memoryCache
+	// map[string]time.Time
+	expires sync.Map
+}
+
+func (tc *ttlCache) IsCached(key string) ([]byte, bool) {
+	if exp, ok := tc.expires.Load(key); ok && time.Now().After(exp.(time.Time)) {
+		return nil, false
+	}
+	return tc.memoryCache.IsCached(key)
+}
+
+func (tc *ttlCache) CacheWithTTL(key string, val []byte, ttl time.Duration) error {
+	tc.expires.Store(key, time.Now().Add(ttl))
+	return tc.Cache(key, val)
+}
+
+func TestCacheTTL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	Convey("测试为响应设置缓存的过期时间", t, func() {
+		c := NewCrawler(WithCache(new(ttlCache), false, nil))
+
+		fromCache := make(map[string][]bool)
+		c.AfterResponse(func(r *Response) {
+			path := string(r.Request.uri.Path())
+			fromCache[path] = append(fromCache[path], r.FromCache)
+
+			switch path {
+			case "/price":
+				r.SetCacheTTL(50 * time.Millisecond)
+			case "/article":
+				r.SetCacheTTL(time.Hour)
+			}
+		})
+
+		get := func() {
+			for _, path := range []string{"/price", "/article", "/default"} {
+				So(c.Get(ts.URL+path), ShouldBeNil)
+			}
+		}
+
+		get()
+		get()
+		time.Sleep(100 * time.Millisecond)
+		get()
+
+		So(fromCache["/price"], ShouldResemble, []bool{false, true, false})
+		So(fromCache["/article"], ShouldResemble, []bool{false, true, true})
+		So(fromCache["/default"], ShouldResemble, []bool{false, true, true})
+	})
+
+	Convey("测试不支持过期时间的缓存", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+			r.SetCacheTTL(time.Millisecond)
+		})
+
+		So(c.Get(ts.URL), ShouldBeNil)
+		time.Sleep(10 * time.Millisecond)
+		So(c.Get(ts.URL), ShouldBeNil)
+		So(fromCache, ShouldResemble, []bool{false, true})
+	})
+}
+
+// plainCache 是不能遍历的缓存
+type plainCache struct {
+	mc memoryCache
+}
+
+func (pc *plainCache) Compressed(yes bool)                {}
+func (pc *plainCache) Init() error                        { return nil }
+func (pc *plainCache) IsCached(key string) ([]byte, bool) { return pc.mc.IsCached(key) }
+func (pc *plainCache) Cache(key string, val []byte) error { return pc.mc.Cache(key, val) }
+func (pc *plainCache) Delete(key string) error            { return pc.mc.Delete(key) }
+func (pc *plainCache) Clear() error                       { return pc.mc.Clear() }
+
+func TestExportCache(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试导出和导入缓存", t, func() {
+		src := new(memoryCache)
+		c := NewCrawler(WithCache(src, false, nil))
+		for i := 0; i < 5; i++ {
+			So(c.Get(fmt.Sprintf("%s/?page=%d", ts.URL, i)), ShouldBeNil)
+		}
+		// 二进制的缓存
+		So(src.Cache("binary", []byte{0xff, 0x00, '\n', 0xfe}), ShouldBeNil)
+
+		var buf bytes.Buffer
+		So(c.ExportCache(&buf), ShouldBeNil)
+		So(strings.Count(buf.String(), "\n"), ShouldEqual, 6)
+
+		dst := new(memoryCache)
+		c2 := NewCrawler(WithCache(dst, false, nil))
+		So(c2.ImportCache(&buf), ShouldBeNil)
+
+		src.m.Range(func(key, val any) bool {
+			v, ok := dst.IsCached(key.(string))
+			So(ok, ShouldBeTrue)
+			So(v, ShouldResemble, val)
+			return true
+		})
+
+		// 导入的缓存可以直接使用
+		var fromCache bool
+		c2.AfterResponse(func(r *Response) {
+			fromCache = r.FromCache
+		})
+		So(c2.Get(ts.URL+"/?page=3"), ShouldBeNil)
+		So(fromCache, ShouldBeTrue)
+	})
+
+	Convey("测试导入无效的缓存", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, nil))
+		err := c.ImportCache(strings.NewReader(`{"key":"a","value":"YQ=="}` + "\n" + "{\n"))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "line 2")
+	})
+
+	Convey("测试不能遍历的缓存", t, func() {
+		c := NewCrawler(WithCache(new(plainCache), false, nil))
+		So(c.ExportCache(io.Discard), ShouldEqual, ErrCacheNotIterable)
+		So(NewCrawler().ExportCache(io.Discard), ShouldEqual, ErrNoCache)
+	})
+}
+
+func TestDeleteCache(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试删除单个缓存", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+		var (
+			fromCache []bool
+			keys      []string
+		)
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+			key, _ := r.Request.Hash()
+			keys = append(keys, key)
+		})
+
+		get := func(URL string) {
+			So(c.Get(URL), ShouldBeNil)
+		}
+
+		get(ts.URL + "/?a=1")
+		get(ts.URL + "/?a=2")
+
+		Convey("根据 key 删除", func() {
+			So(c.DeleteCache(keys[0]), ShouldBeNil)
+
+			get(ts.URL + "/?a=1")
+			get(ts.URL + "/?a=2")
+			So(fromCache, ShouldResemble, []bool{false, false, false, true})
+		})
+
+		Convey("根据 url 删除", func() {
+			So(c.InvalidateURL(MethodGet, ts.URL+"/?a=2"), ShouldBeNil)
+
+			get(ts.URL + "/?a=1")
+			get(ts.URL + "/?a=2")
+			So(fromCache, ShouldResemble, []bool{false, false, true, false})
+		})
+
+		Convey("使用缓存字段", func() {
+			c := NewCrawler(WithCache(new(memoryCache), false, nil, NewQueryParamField("id")))
+
+			var fromCache []bool
+			c.AfterResponse(func(r *Response) {
+				fromCache = append(fromCache, r.FromCache)
+			})
+
+			So(c.Get(ts.URL+"/?id=1&t=1"), ShouldBeNil)
+			So(c.InvalidateURL(MethodGet, ts.URL+"/?id=1&t=2", NewQueryParamField("id")), ShouldBeNil)
+			So(c.Get(ts.URL+"/?id=1&t=3"), ShouldBeNil)
+			So(fromCache, ShouldResemble, []bool{false, false})
+
+			So(c.InvalidateURL(MethodGet, ts.URL+"/?t=2", NewQueryParamField("id")), ShouldNotBeNil)
+		})
+
+		Convey("没有缓存", func() {
+			c := NewCrawler()
+			So(c.DeleteCache("key"), ShouldEqual, ErrNoCache)
+			So(c.InvalidateURL(MethodGet, ts.URL), ShouldEqual, ErrNoCache)
+		})
+	})
+}
+
+func TestRawBodyCacheKey(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	Convey("测试二进制请求体的缓存键", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+		var (
+			keys      []string
+			fromCache []bool
+		)
+		c.AfterResponse(func(r *Response) {
+			key, err := r.Request.Hash()
+			So(err, ShouldBeNil)
+			keys = append(keys, key)
+			fromCache = append(fromCache, r.FromCache)
+		})
+
+		// 两个请求体都是无效的 utf-8，转换为字符串后再编码为 json 会得到相同的结果
+		bodies := [][]byte{
+			{0xff, 0xfe, 0x00, 0x01},
+			{0xff, 0xfd, 0x00, 0x01},
+			{0xff, 0xfe, 0x00, 0x01},
+		}
+		for _, body := range bodies {
+			So(c.PostRaw(ts.URL, body, nil), ShouldBeNil)
+		}
+
+		So(keys[0], ShouldNotEqual, keys[1])
+		So(keys[2], ShouldEqual, keys[0])
+		So(fromCache, ShouldResemble, []bool{false, false, true})
+	})
+}
+
+func TestCacheKeyPreimage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	Convey("测试输出缓存键的原文", t, func() {
+		var buf bytes.Buffer
+		c := NewCrawler(
+			WithLogger(log.NewLogger(log.DEBUG, &buf)),
+			WithCache(new(memoryCache), false, nil, NewQueryParamField("id")),
+		)
+
+		So(c.Get(ts.URL+"/?id=1&t=2"), ShouldBeNil)
+		So(c.PostRaw(ts.URL, []byte("raw"), nil), ShouldBeNil)
+
+		var preimages []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if strings.Contains(line, `"cache key preimage"`) {
+				var entry map[string]any
+				So(json.Unmarshal([]byte(line), &entry), ShouldBeNil)
+				preimages = append(preimages, entry)
+			}
+		}
+		So(preimages, ShouldHaveLength, 2)
+
+		So(preimages[0]["method"], ShouldEqual, "GET")
+		So(preimages[0]["cached_map"], ShouldEqual, `{"0-id": "1"}`)
+		So(preimages[0]["preimage"], ShouldEqual, `{"0-id": "1"}`)
+		So(preimages[0]["cache_key"], ShouldHaveLength, 40)
+
+		So(preimages[1]["method"], ShouldEqual, "POST")
+		So(preimages[1]["preimage"], ShouldContainSubstring, `"CacheKey":"cmF3"`)
+	})
+
+	Convey("测试二进制的原文", t, func() {
+		var buf bytes.Buffer
+		c := NewCrawler(
+			WithLogger(log.NewLogger(log.DEBUG, &buf)),
+			WithCache(new(memoryCache), false, nil),
+		)
+
+		So(c.Do(MethodGet, ts.URL, []byte{0xff, 0xfe}, nil, nil), ShouldBeNil)
+		So(buf.String(), ShouldContainSubstring, `"preimage_base64":"//4="`)
+	})
+
+	Convey("非 debug 级别时不输出", t, func() {
+		var buf bytes.Buffer
+		c := NewCrawler(
+			WithLogger(log.NewLogger(log.INFO, &buf)),
+			WithCache(new(memoryCache), false, nil),
+		)
+
+		So(c.Get(ts.URL), ShouldBeNil)
+		So(buf.String(), ShouldNotContainSubstring, "preimage")
+	})
+}
+
+func TestCacheConditionOnBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("busy") != "" {
+			// 错误页面的状态码也是 200
+			w.Write([]byte(`{"error":"busy"}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"id":1}}`))
+	}))
+	defer ts.Close()
+
+	Convey("测试根据响应体决定是否缓存", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, func(r *Response) bool {
+			return r.IsSuccess() && r.GetJSON("data").Exists()
+		}))
+
+		var (
+			fromCache []bool
+			parsed    []bool
+		)
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+			parsed = append(parsed, r.json != nil)
+		})
+
+		for i := 0; i < 2; i++ {
+			So(c.Get(ts.URL+"/?busy=1"), ShouldBeNil)
+			So(c.Get(ts.URL), ShouldBeNil)
+		}
+
+		So(fromCache, ShouldResemble, []bool{false, false, false, true})
+		// 缓存条件中解析的 json 会留给处理器使用
+		So(parsed, ShouldResemble, []bool{true, true, true, false})
+	})
+}
+
+// BenchmarkCacheWrite 测试并发写入缓存的同时，其他协程频繁使用爬虫的锁
+func BenchmarkCacheWrite(b *testing.B) {
+	c := NewCrawler(WithCache(new(memoryCache), false, nil))
+	val := []byte("value")
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				c.Lock()
+				time.Sleep(time.Microsecond)
+				c.Unlock()
+			}
+		}
+	}()
+
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.writeCache(CacheEntry{Key: fmt.Sprintf("key-%d", atomic.AddInt64(&n, 1)), Value: val})
+		}
+	})
+}
+
+func benchmarkAsyncCache(b *testing.B, cc Cache) {
+	c := NewCrawler(WithCache(cc, false, nil), WithAsyncCache(64))
+	val := []byte("value")
+
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.storeCache(CacheEntry{Key: fmt.Sprintf("key-%d", atomic.AddInt64(&n, 1)), Value: val})
+		}
+	})
+	c.FlushCache()
+}
+
+// BenchmarkAsyncCache 模拟每次写入都有网络往返的缓存后端
+func BenchmarkAsyncCache(b *testing.B) {
+	b.Run("OneByOne", func(b *testing.B) {
+		benchmarkAsyncCache(b, &memoryCache{writeDelay: 100 * time.Microsecond})
+	})
+	b.Run("Batch", func(b *testing.B) {
+		benchmarkAsyncCache(b, &batchCache{memoryCache: memoryCache{writeDelay: 100 * time.Microsecond}})
+	})
+}
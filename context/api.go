@@ -48,7 +48,11 @@ const (
 	WriteOp
 )
 
-var ctxPool sync.Pool
+// 不同类型的上下文使用不同的池，否则申请到的上下文可能不是需要的类型
+var (
+	rctxPool sync.Pool
+	wctxPool sync.Pool
+)
 
 // AcquireCtx returns an empty Context instance from context pool.
 //
@@ -59,7 +63,13 @@ func AcquireCtx(ops ...CtxOp) (Context, error) {
 	if len(ops) > 1 {
 		return nil, fmt.Errorf("only 1 op can be passed in as most, but you passed %d ops", len(ops))
 	}
-	v := ctxPool.Get()
+
+	var v any
+	if len(ops) == 1 && ops[0] == ReadOp {
+		v = rctxPool.Get()
+	} else {
+		v = wctxPool.Get()
+	}
 	if v == nil {
 		return NewContext(ops...)
 	}
@@ -72,7 +82,34 @@ func AcquireCtx(ops ...CtxOp) (Context, error) {
 // it to Context pool.
 func ReleaseCtx(ctx Context) {
 	ctx.Clear()
-	ctxPool.Put(ctx)
+	switch ctx.(type) {
+	case *rcontext:
+		rctxPool.Put(ctx)
+	case *wcontext:
+		wctxPool.Put(ctx)
+	}
+}
+
+// Clone returns a shallow copy of ctx acquired via AcquireCtx, so that
+// putting or deleting keys in the copy doesn't affect ctx and vice versa.
+// The values themselves are not copied.
+func Clone(ctx Context) (Context, error) {
+	op := WriteOp
+	if _, ok := ctx.(*rcontext); ok {
+		op = ReadOp
+	}
+
+	c, err := AcquireCtx(op)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.ForEach(func(key string, val any) any {
+		c.Put(key, val)
+		return nil
+	})
+
+	return c, nil
 }
 
 // NewContext returns a new Context instance
@@ -10,6 +10,7 @@ package context
 
 import (
 	"bytes"
+	"strconv"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -90,3 +91,82 @@ func TestContext(t *testing.T) {
 		})
 	})
 }
+
+func TestAcquireCtx(t *testing.T) {
+	Convey("从池中申请上下文", t, func() {
+		for i := 0; i < 3; i++ {
+			rctx, err := AcquireCtx(ReadOp)
+			So(err, ShouldBeNil)
+			So(rctx, ShouldHaveSameTypeAs, &rcontext{})
+
+			wctx, err := AcquireCtx()
+			So(err, ShouldBeNil)
+			So(wctx, ShouldHaveSameTypeAs, &wcontext{})
+
+			wctx.Put("key", "value")
+
+			ReleaseCtx(rctx)
+			ReleaseCtx(wctx)
+		}
+
+		ctx, _ := AcquireCtx(WriteOp)
+		So(ctx.Length(), ShouldEqual, 0)
+	})
+}
+
+func TestClone(t *testing.T) {
+	Convey("复制上下文", t, func() {
+		for _, op := range []CtxOp{ReadOp, WriteOp} {
+			ctx, _ := NewContext(op)
+			putSomeCtx(ctx)
+
+			c, err := Clone(ctx)
+			So(err, ShouldBeNil)
+			So(c, ShouldHaveSameTypeAs, ctx)
+			So(c.Length(), ShouldEqual, 5)
+			So(c.GetAny("four"), ShouldEqual, 4)
+
+			c.Put("nine", 9)
+			c.Delete("four")
+			So(ctx.Length(), ShouldEqual, 5)
+			So(ctx.GetAny("four"), ShouldEqual, 4)
+		}
+	})
+}
+
+func benchmarkCtx(b *testing.B, op CtxOp, writeEvery int) {
+	ctx, _ := NewContext(op)
+	for i := 0; i < 10; i++ {
+		ctx.Put(strconv.Itoa(i), i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := strconv.Itoa(i % 10)
+			if i%writeEvery == 0 {
+				ctx.Put(key, i)
+			} else {
+				ctx.GetAny(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkReadMostlyReadOp(b *testing.B) {
+	benchmarkCtx(b, ReadOp, 100)
+}
+
+func BenchmarkReadMostlyWriteOp(b *testing.B) {
+	benchmarkCtx(b, WriteOp, 100)
+}
+
+func BenchmarkReadWriteReadOp(b *testing.B) {
+	benchmarkCtx(b, ReadOp, 2)
+}
+
+func BenchmarkReadWriteWriteOp(b *testing.B) {
+	benchmarkCtx(b, WriteOp, 2)
+}
@@ -9,9 +9,12 @@
 package predator
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"net/url"
@@ -26,6 +29,7 @@ import (
 	"github.com/go-predator/predator/html"
 	"github.com/go-predator/predator/json"
 	"github.com/go-predator/predator/proxy"
+	"github.com/rs/zerolog"
 	"github.com/valyala/fasthttp"
 )
 
@@ -38,8 +42,17 @@ type HandleResponse func(r *Response)
 // HandleHTML is used to process html
 type HandleHTML func(he *html.HTMLElement, r *Response)
 
+// HandleHTMLDocument is used to process the whole html document
+type HandleHTMLDocument func(doc *goquery.Document, r *Response) error
+
 type HandleJSON func(j json.JSONResult, r *Response)
 
+// HandleJSONPath is used to process the result of a path of the json
+type HandleJSONPath func(j json.JSONResult, r *Response) error
+
+// HandleParseError is used to handle the html or json body that can't be parsed
+type HandleParseError func(r *Response, err error)
+
 // HTMLParser is used to parse html
 type HTMLParser struct {
 	Selector string
@@ -48,13 +61,26 @@ type HTMLParser struct {
 
 // JSONParser is used to parse json
 type JSONParser struct {
+	// Name of the handler registered by `ParseJSONNamed`
+	name   string
 	strict bool
-	Handle HandleJSON
+	// The response is handled only if it matches the schema
+	schema *json.Schema
+	// The path of the json registered by `ParseJSONPath`
+	path       string
+	handlePath HandleJSONPath
+	Handle     HandleJSON
 }
 
 // CustomRandomBoundary generates a custom boundary
 type CustomRandomBoundary func() string
 
+// CacheCondition decides whether a new response should be cached. It is
+// called before the response handlers, and the body of the response can be
+// inspected, such as to skip the error pages served with 200.
+//
+// `Response.JSON` and `Response.HTML` can be used in the condition, the parsed
+// body is kept and shared with the handlers, so it is not parsed twice.
 type CacheCondition func(r *Response) bool
 
 type ProxyInvalidCondition func(r *Response) error
@@ -79,8 +105,13 @@ type Crawler struct {
 	complementProxyPool   ComplementProxyPool
 	requestCount          uint32
 	responseCount         uint32
-	// TODO: 在多协程中这个上下文管理可以用来退出或取消多个协程
+	// Bytes of the request bodies sent and the response bodies received,
+	// responses read from the cache are not counted
+	bytesSent     uint64
+	bytesReceived uint64
+	// Context is canceled when the crawler is stopped, see `Stop`
 	Context context.Context
+	cancel  context.CancelFunc
 
 	// Cache successful response
 	cache Cache
@@ -90,6 +121,8 @@ type Crawler struct {
 	// The fewer fields the better.
 	cacheFields    []CacheField
 	cacheCondition CacheCondition
+	// Query parameters excluded from the cache key
+	cacheIgnoreParams []string
 
 	requestHandler []HandleRequest
 
@@ -97,11 +130,75 @@ type Crawler struct {
 	responseHandler []HandleResponse
 	// Array of functions to handle parsed html
 	htmlHandler []*HTMLParser
-	jsonHandler []*JSONParser
+	// Array of functions to handle the whole html document
+	documentHandler []HandleHTMLDocument
+	jsonHandler     []*JSONParser
+	// Array of functions to handle the parse errors of html and json
+	parseErrorHandler []HandleParseError
 
 	wg *sync.WaitGroup
 
 	log *log.Logger
+	// Panic with the error instead of calling `os.Exit` when
+	// a fatal error occurs, so that the error can be recovered
+	panicInsteadOfExit bool
+	// Only 1 in `logSampling` logs below the `WARNING` level are emitted
+	logSampling uint32
+	// Fields attached to every log
+	logContext map[string]any
+	// Close the connection after each request instead of reusing it
+	disableKeepAlive bool
+	// Default headers of every request
+	headers map[string]string
+	// Type of the contexts acquired by the crawler
+	ctxOp pctx.CtxOp
+	// Max number of in-flight requests to each host, 0 means no limit
+	perHostConcurrency int
+	// Limits of the hosts, map[string]*hostLimit
+	hostSlots *sync.Map
+	// Follow the meta refresh tags and the js redirects of html responses
+	followMetaRefresh bool
+	// Record the timing of each request, see `Response.Timing`
+	tracing bool
+	// Normalize the urls before sending requests
+	urlNormalizer URLNormalizer
+	// The client dialing through the proxy pool, see `proxyHTTPClient`
+	proxyClient *fasthttp.Client
+	// Sniff the body of the responses without an html "Content-Type"
+	lenientHTML bool
+	// Max duration to retry a request since its first attempt, 0 means no limit
+	maxRetryDuration time.Duration
+	// Base and max delay of the exponential backoff between the retries
+	retryBackoffBase, retryBackoffMax time.Duration
+	// The lock of the cache writes, see `writeCache`
+	cacheLock *sync.Mutex
+	// Buffer size of the async cache writes, 0 means writing synchronously
+	asyncCacheSize int
+	// The writer of the async cache, see `WithAsyncCache`
+	asyncCache *asyncCacheWriter
+	// Fail the requests on the cache read errors, see `WithCacheFailOpen`
+	cacheFailClosed bool
+	// Validate the responses before the handlers, see `WithResponseValidator`
+	responseValidator ResponseValidator
+	// The "Accept-Encoding" header, see `WithAcceptEncoding`
+	acceptEncoding string
+	// The resolver of the hostnames, see `WithResolver`
+	resolver fasthttp.Resolver
+	// Max number of the concurrent DNS lookups, see `WithMaxDNSConcurrency`
+	maxDNSConcurrency int
+	// Return HTTPError for the status codes meeting it, see `WithStatusErrors`
+	statusErrors StatusErrorCondition
+	// Timeout of establishing the connections, see `WithDialTimeout`
+	dialTimeout time.Duration
+	// Timeout of the TLS handshakes, see `WithTLSHandshakeTimeout`
+	tlsTimeout time.Duration
+	// Timeout of waiting for the responses, see `WithResponseHeaderTimeout`
+	headerTimeout time.Duration
+	// Sign the requests right before sending, see `WithRequestSigner`
+	requestSigner RequestSigner
+	// The parked tasks which have got the slots of their hosts
+	readyLock  *sync.Mutex
+	readyTasks []*Task
 }
 
 // NewCrawler creates a new Crawler instance with some CrawlerOptions
@@ -109,6 +206,7 @@ func NewCrawler(opts ...CrawlerOption) *Crawler {
 	c := new(Crawler)
 
 	c.UserAgent = "Predator"
+	c.ctxOp = pctx.WriteOp
 
 	c.client = new(fasthttp.Client)
 
@@ -116,6 +214,8 @@ func NewCrawler(opts ...CrawlerOption) *Crawler {
 		op(c)
 	}
 
+	c.setDialer()
+
 	// If there is `DEBUG` in the environment variable and `c.log` is nil,
 	// create a logger with a level of `DEBUG`
 	if c.log == nil && log.IsDebug() {
@@ -125,9 +225,26 @@ func NewCrawler(opts ...CrawlerOption) *Crawler {
 		)
 	}
 
+	if c.log != nil && len(c.logContext) > 0 {
+		l := c.log.L.With().Fields(c.logContext).Logger()
+		c.log.L = &l
+	}
+
+	if c.log != nil && c.logSampling > 1 {
+		// 只对 DEBUG 和 INFO 等级的日志采样，警告和错误总是输出
+		sampled := c.log.L.Sample(&zerolog.LevelSampler{
+			DebugSampler: &zerolog.BasicSampler{N: c.logSampling},
+			InfoSampler:  &zerolog.BasicSampler{N: c.logSampling},
+		})
+		c.log.L = &sampled
+	}
+
 	c.lock = &sync.RWMutex{}
+	c.cacheLock = &sync.Mutex{}
+	c.hostSlots = &sync.Map{}
+	c.readyLock = &sync.Mutex{}
 
-	c.Context = context.Background()
+	c.Context, c.cancel = context.WithCancel(context.Background())
 
 	capacityState := c.goPool != nil
 
@@ -143,6 +260,10 @@ func NewCrawler(opts ...CrawlerOption) *Crawler {
 		c.goPool.log = c.log
 	}
 
+	if c.asyncCacheSize > 0 {
+		c.asyncCache = newAsyncCacheWriter(c.asyncCacheSize, c.writeCaches)
+	}
+
 	return c
 }
 
@@ -160,7 +281,9 @@ func (c *Crawler) Clone() *Crawler {
 			c.FatalOrPanic(err)
 		}
 	}
-	return &Crawler{
+	// 停止原爬虫时，克隆的爬虫也会停止
+	ctx, cancel := context.WithCancel(c.Context)
+	nc := &Crawler{
 		lock:            c.lock,
 		UserAgent:       c.UserAgent,
 		retryCount:      c.retryCount,
@@ -169,7 +292,8 @@ func (c *Crawler) Clone() *Crawler {
 		cookies:         c.cookies,
 		goPool:          pool,
 		proxyURLPool:    c.proxyURLPool,
-		Context:         c.Context,
+		Context:         ctx,
+		cancel:          cancel,
 		cache:           c.cache,
 		cacheCondition:  c.cacheCondition,
 		cacheFields:     c.cacheFields,
@@ -179,12 +303,47 @@ func (c *Crawler) Clone() *Crawler {
 		jsonHandler:     make([]*JSONParser, 0, 1),
 		wg:              &sync.WaitGroup{},
 		log:             c.log,
-	}
+
+		panicInsteadOfExit: c.panicInsteadOfExit,
+		disableKeepAlive:   c.disableKeepAlive,
+		headers:            c.headers,
+		cacheIgnoreParams:  c.cacheIgnoreParams,
+		ctxOp:              c.ctxOp,
+		perHostConcurrency: c.perHostConcurrency,
+		hostSlots:          &sync.Map{},
+		readyLock:          &sync.Mutex{},
+		followMetaRefresh:  c.followMetaRefresh,
+		urlNormalizer:      c.urlNormalizer,
+		tracing:            c.tracing,
+		lenientHTML:        c.lenientHTML,
+		maxRetryDuration:   c.maxRetryDuration,
+		retryBackoffBase:   c.retryBackoffBase,
+		retryBackoffMax:    c.retryBackoffMax,
+		cacheLock:          c.cacheLock,
+		asyncCacheSize:     c.asyncCacheSize,
+		cacheFailClosed:    c.cacheFailClosed,
+		responseValidator:  c.responseValidator,
+		acceptEncoding:     c.acceptEncoding,
+		resolver:           c.resolver,
+		maxDNSConcurrency:  c.maxDNSConcurrency,
+		statusErrors:       c.statusErrors,
+		dialTimeout:        c.dialTimeout,
+		tlsTimeout:         c.tlsTimeout,
+		headerTimeout:      c.headerTimeout,
+		requestSigner:      c.requestSigner,
+	}
+
+	// 克隆的爬虫使用自己的异步写入器，停止克隆的爬虫不影响原爬虫
+	if nc.asyncCacheSize > 0 {
+		nc.asyncCache = newAsyncCacheWriter(nc.asyncCacheSize, nc.writeCaches)
+	}
+
+	return nc
 }
 
 /************************* http 请求方法 ****************************/
 
-func (c *Crawler) request(method, URL string, body []byte, cachedMap map[string]string, reqHeader *fasthttp.RequestHeader, ctx pctx.Context, isChained bool) error {
+func (c *Crawler) request(method, URL string, body []byte, cachedMap map[string]string, reqHeader *fasthttp.RequestHeader, ctx pctx.Context, isChained bool, opts ...func(*Request)) error {
 	defer func() {
 		if c.goPool != nil {
 			if err := recover(); err != nil {
@@ -195,49 +354,41 @@ func (c *Crawler) request(method, URL string, body []byte, cachedMap map[string]
 
 	var err error
 
-	reqHeader.SetMethod(method)
-	if reqHeader.UserAgent() == nil {
-		reqHeader.SetUserAgent(c.UserAgent)
-	}
-
-	if c.cookies != nil {
-		for k, v := range c.cookies {
-			reqHeader.SetCookie(k, v)
-		}
-		if c.log != nil {
-			c.Debug("cookies is set", log.Arg{Key: "cookies", Value: reqHeader.Peek("Cookie")})
-		}
-	}
+	c.setDefaultHeaders(reqHeader, method)
 
+	ownCtx := ctx == nil || isChained
 	if ctx == nil {
-		ctx, err = pctx.AcquireCtx()
-		if err != nil {
-			if c.log != nil {
-				c.log.Error(err)
-			}
-			return err
+		ctx, err = pctx.AcquireCtx(c.ctxOp)
+	} else if isChained {
+		// 链式请求使用父请求上下文的副本，并发执行的子请求之间互不影响
+		ctx, err = pctx.Clone(ctx)
+	}
+	if err != nil {
+		if c.log != nil {
+			c.log.Error(err)
 		}
+		return err
 	}
 
-	u, err := url.Parse(URL)
+	uri, err := c.parseURI(URL)
 	if err != nil {
 		return err
 	}
-	// Convert non-ascii characters in query parameters to ascii characters
-	u.RawQuery = u.Query().Encode()
-
-	uri := fasthttp.AcquireURI()
-	uri.Parse([]byte(u.Host), []byte(u.String()))
 
 	request := AcquireRequest()
 	request.Headers = reqHeader
 	request.Ctx = ctx
+	request.ownCtx = ownCtx
 	request.Body = body
 	request.cachedMap = cachedMap
 	request.ID = atomic.AddUint32(&c.requestCount, 1)
 	request.crawler = c
 	request.uri = uri
 
+	for _, op := range opts {
+		op(request)
+	}
+
 	if c.goPool != nil {
 		c.wg.Add(1)
 		task := &Task{
@@ -247,6 +398,8 @@ func (c *Crawler) request(method, URL string, body []byte, cachedMap map[string]
 		}
 		err = c.goPool.Put(task)
 		if err != nil {
+			// 任务未能加入协程池，如停止爬虫后，不会再执行 prepare
+			c.wg.Done()
 			if c.log != nil {
 				c.log.Error(err)
 			}
@@ -263,12 +416,104 @@ func (c *Crawler) request(method, URL string, body []byte, cachedMap map[string]
 	return nil
 }
 
+// setDefaultHeaders sets the method, the user agent, the default headers
+// and the cookies of the crawler to the request header
+func (c *Crawler) setDefaultHeaders(reqHeader *fasthttp.RequestHeader, method string) {
+	reqHeader.SetMethod(method)
+	// 复用的请求头中 User-Agent 可能是空切片而不是 nil
+	if len(reqHeader.UserAgent()) == 0 {
+		reqHeader.SetUserAgent(c.UserAgent)
+	}
+
+	for k, v := range c.headers {
+		// 默认请求头不能覆盖为某个请求单独设置的请求头
+		if reqHeader.Peek(k) == nil {
+			reqHeader.Set(k, v)
+		}
+	}
+
+	if c.acceptEncoding != "" && reqHeader.Peek("Accept-Encoding") == nil {
+		reqHeader.Set("Accept-Encoding", c.acceptEncoding)
+	}
+
+	if c.cookies != nil {
+		for k, v := range c.cookies {
+			reqHeader.SetCookie(k, v)
+		}
+		if c.log != nil {
+			c.Debug("cookies is set", log.Arg{Key: "cookies", Value: reqHeader.Peek("Cookie")})
+		}
+	}
+}
+
+// parseURI parses the url normalized by the URLNormalizer of the crawler,
+// so that the equivalent urls share the same cache key
+func (c *Crawler) parseURI(URL string) (*fasthttp.URI, error) {
+	if c.urlNormalizer != nil {
+		URL = c.urlNormalizer(URL)
+	}
+
+	u, err := url.Parse(URL)
+	if err != nil {
+		return nil, err
+	}
+	// Convert non-ascii characters in query parameters to ascii characters
+	u.RawQuery = u.Query().Encode()
+
+	uri := fasthttp.AcquireURI()
+	uri.Parse([]byte(u.Host), []byte(u.String()))
+
+	return uri, nil
+}
+
 func (c *Crawler) prepare(request *Request, isChained bool) (err error) {
 	if c.goPool != nil {
 		defer c.wg.Done()
 	}
 
-	c.processRequestHandler(request)
+	requeued := false
+	if done := request.done; done != nil {
+		// request 可能已随响应一起被释放，所以要提前取得回调
+		defer func() {
+			// 重新入队的请求还没有完成
+			if !requeued {
+				done(err)
+			}
+		}()
+	}
+	ownCtx := request.ownCtx
+	if ownCtx {
+		// 爬虫自己申请的上下文在所有处理函数执行完毕后才释放，
+		// 用户传入的上下文由用户自己管理，不能释放。
+		// request 可能已随响应一起被释放，所以要在此时取得上下文。
+		ctx := request.Ctx
+		// 上下文由这里释放，释放请求时不能再次释放
+		request.ownCtx = false
+		defer func() {
+			// 重新入队的请求之后还要使用上下文
+			if !requeued {
+				pctx.ReleaseCtx(ctx)
+			}
+		}()
+	}
+
+	if c.Context.Err() != nil {
+		if c.log != nil {
+			c.Debug("the crawler has been stopped", log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)})
+		}
+		return ErrCrawlerStopped
+	}
+
+	// 已经取得主机名额的任务在结束时归还名额
+	if slot := request.hostSlot; slot != nil {
+		defer slot.release(c.goPool != nil)
+	}
+
+	// 重新入队的请求已经执行过请求处理函数
+	if !request.handled {
+		c.processRequestHandler(request)
+		request.handled = true
+	}
 
 	if request.abort {
 		if c.log != nil {
@@ -277,6 +522,53 @@ func (c *Crawler) prepare(request *Request, isChained bool) (err error) {
 		return
 	}
 
+	// 已经取得主机名额的任务不再让出，否则会在队列中占用名额
+	if c.goPool != nil && request.priority != 0 && request.hostSlot == nil {
+		// 让出工作协程给优先级更高的请求，本请求重新入队后会被再次执行，
+		// 所以要先增加计数，否则 Wait 可能提前返回
+		c.wg.Add(1)
+		// 重新入队的请求之后由其他调用释放上下文
+		request.ownCtx = ownCtx
+		if next := c.goPool.requeue(&Task{crawler: c, req: request, isChained: isChained}); next != nil {
+			requeued = true
+			if c.log != nil {
+				c.Debug("the request yields to a request with a higher priority",
+					log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)},
+					log.Arg{Key: "priority", Value: request.priority},
+				)
+			}
+			return c.prepare(next.req, next.isChained)
+		}
+		request.ownCtx = false
+		c.wg.Done()
+	}
+
+	// 主机的名额已满时不等待，把任务放到一边，让工作协程执行其他任务
+	if c.goPool != nil && c.perHostConcurrency > 0 && request.hostSlot == nil {
+		// 等待的任务可能在返回之前就被其他协程执行，所以要先增加计数
+		c.wg.Add(1)
+		request.ownCtx = ownCtx
+		slot, err := c.reserveHostSlot(&Task{crawler: c, req: request, isChained: isChained})
+		if slot == nil && err == nil {
+			requeued = true
+			if c.log != nil {
+				c.Debug("the request waits for a free slot of the host",
+					log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)},
+					log.Arg{Key: "host", Value: string(request.uri.Host())},
+				)
+			}
+			return nil
+		}
+		request.ownCtx = false
+		c.wg.Done()
+		if err != nil {
+			return err
+		}
+
+		request.hostSlot = slot
+		defer slot.release(true)
+	}
+
 	if c.log != nil {
 		c.Info(
 			"requesting",
@@ -293,6 +585,14 @@ func (c *Crawler) prepare(request *Request, isChained bool) (err error) {
 		}
 	}
 
+	return c.process(request, false)
+}
+
+// process gets the response of the request from the cache or the remote
+// server, and then handles the response with the registered handlers.
+//
+// If `skipCache` is true, the response will not be read from the cache.
+func (c *Crawler) process(request *Request, skipCache bool) (err error) {
 	var response *Response
 
 	var key string
@@ -314,9 +614,11 @@ func (c *Crawler) prepare(request *Request, isChained bool) (err error) {
 			)
 		}
 
-		response, err = c.checkCache(key)
-		if err != nil {
-			return
+		if !skipCache {
+			response, err = c.checkCache(key)
+			if err != nil {
+				return
+			}
 		}
 
 		if response != nil && c.log != nil {
@@ -327,36 +629,29 @@ func (c *Crawler) prepare(request *Request, isChained bool) (err error) {
 		}
 	}
 
-	var rawResp *fasthttp.Response
+	var (
+		rawResp  *fasthttp.Response
+		cacheVal []byte
+	)
 	// A new request is issued when there
 	// is no response from the cache
 	if response == nil {
-		response, rawResp, err = c.do(request)
+		response, rawResp, err = c.doWithHostLimit(request)
 		if err != nil {
 			return
 		}
 
-		// Cache the response from the request if the statuscode is 20X
-		if c.cache != nil && c.cacheCondition(response) && key != "" {
-			cacheVal, err := response.Marshal()
+		// Cache the response from the request if the statuscode is 20X.
+		// The response is marshaled before being handled, but is cached
+		// only after the response handlers have not asked for a retry.
+		if c.cache != nil && response.validationErr == nil && c.cacheCondition(response) && key != "" {
+			cacheVal, err = response.Marshal()
 			if err != nil {
 				if c.log != nil {
 					c.log.Error(err)
 				}
 				return err
 			}
-
-			if cacheVal != nil {
-				c.lock.Lock()
-				err = c.cache.Cache(key, cacheVal)
-				if err != nil {
-					if c.log != nil {
-						c.log.Error(err)
-					}
-					return err
-				}
-				c.lock.Unlock()
-			}
 		}
 	} else {
 		response.Request = request
@@ -380,12 +675,13 @@ func (c *Crawler) prepare(request *Request, isChained bool) (err error) {
 				Str("method", request.Method()).
 				Int("status_code", response.StatusCode)
 
+			// 缓存的响应没有远程地址
 			if !response.FromCache {
+				key := "server_addr"
 				if c.ProxyPoolAmount() > 0 {
-					l = l.Str("proxy", response.ClientIP())
-				} else {
-					l = l.Str("server_addr", response.ClientIP())
+					key = "proxy"
 				}
+				l = l.Str(key, response.ClientIP())
 			}
 
 			l.Bool("from_cache", response.FromCache).
@@ -396,16 +692,83 @@ func (c *Crawler) prepare(request *Request, isChained bool) (err error) {
 
 	c.processResponseHandler(response)
 
+	// 验证失败的响应在响应处理器之后才标记为无效，处理器中仍可以检查验证错误
+	if response.validationErr != nil {
+		response.invalid = true
+	}
+
+	// 响应处理器可以覆盖缓存条件
+	if !response.FromCache && key != "" {
+		if response.doNotCache {
+			cacheVal = nil
+		} else if response.forceCache && cacheVal == nil {
+			cacheVal, err = response.Marshal()
+			if err != nil {
+				c.Error(err)
+				return err
+			}
+		}
+	}
+
+	if response.retry {
+		if atomic.LoadUint32(&request.retryCounter) < c.retryCount && !c.retryExpired(request) {
+			c.Warning(
+				"the response handler asks for a retry and the request will be retried soon",
+				log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)},
+			)
+			c.countRetry(request)
+
+			// 请求还要用于重试，不能随响应一起释放
+			response.Request = nil
+			ReleaseResponse(response, false)
+			if rawResp != nil {
+				fasthttp.ReleaseResponse(rawResp)
+			}
+
+			return c.process(request, true)
+		}
+
+		c.Warning(
+			"the response handler asks for a retry, but the number of retries has been exhausted",
+			log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)},
+			log.Arg{Key: "retry_count", Value: atomic.LoadUint32(&request.retryCounter)},
+		)
+	} else if cacheVal != nil {
+		err = c.storeCache(CacheEntry{Key: key, Value: cacheVal, TTL: response.cacheTTL})
+		if err != nil {
+			if c.log != nil {
+				c.log.Error(err)
+			}
+			return err
+		}
+	}
+
+	if request.capture != nil {
+		request.capture(response)
+	}
+
 	if !response.invalid {
 		err = c.processHTMLHandler(response)
 		if err != nil {
 			return
 		}
 
-		c.processJSONHandler(response)
+		err = c.processJSONHandler(response)
+		if err != nil {
+			return
+		}
+	}
+
+	if c.statusErrors != nil && c.statusErrors(response.StatusCode) {
+		err = &HTTPError{
+			StatusCode: response.StatusCode,
+			URL:        request.URL(),
+			Body:       append([]byte(nil), response.Body...),
+		}
 	}
 
-	ReleaseResponse(response, !isChained)
+	// 上下文在 prepare 结束时释放
+	ReleaseResponse(response, false)
 	if rawResp != nil {
 		// 原始响应应该在自定义响应之后释放，不然一些字段的值会出错
 		fasthttp.ReleaseResponse(rawResp)
@@ -414,28 +777,197 @@ func (c *Crawler) prepare(request *Request, isChained bool) (err error) {
 	return
 }
 
+// FatalOrPanic logs a `FATAL` message and exits the program if the crawler
+// has a logger, otherwise it panics with the error.
+//
+// If the crawler is created with `WithPanicInsteadOfExit`, it always panics.
 func (c *Crawler) FatalOrPanic(err error) {
-	if c.log != nil {
+	if c.log != nil && !c.panicInsteadOfExit {
 		c.Fatal(err)
 	} else {
 		panic(err)
 	}
 }
 
+// fetch sends a request without body for the internal use, such as fetching
+// the sitemaps, with the user agent, the default headers, the cookies, the
+// proxies and the cache of the crawler, but none of the handlers is called.
+//
+// Unlike the other requests, the network errors, such as a refused
+// connection, are returned rather than exiting the program.
+//
+// The returned response should be released with `ReleaseResponse` after use.
+func (c *Crawler) fetch(method, URL string) (*Response, error) {
+	uri, err := c.parseURI(URL)
+	if err != nil {
+		return nil, err
+	}
+
+	reqHeader := AcquireRequestHeader()
+	c.setDefaultHeaders(reqHeader, method)
+
+	request := AcquireRequest()
+	request.Headers = reqHeader
+	request.ID = atomic.AddUint32(&c.requestCount, 1)
+	request.crawler = c
+	request.uri = uri
+	// 无法连接的链接很常见，调用方需要得到错误，而不是让程序退出
+	request.returnNetErr = true
+
+	var key string
+	if c.cache != nil {
+		key, err = request.Hash()
+		if err != nil {
+			ReleaseRequest(request)
+			return nil, err
+		}
+
+		response, err := c.checkCache(key)
+		if err != nil {
+			ReleaseRequest(request)
+			return nil, err
+		}
+		if response != nil {
+			response.Request = request
+			return response, nil
+		}
+	}
+
+	response, rawResp, err := c.doWithHostLimit(request)
+	if err != nil {
+		return nil, err
+	}
+	fasthttp.ReleaseResponse(rawResp)
+
+	if c.cache != nil && c.cacheCondition(response) {
+		val, err := response.Marshal()
+		if err != nil {
+			ReleaseResponse(response, false)
+			return nil, err
+		}
+
+		err = c.storeCache(CacheEntry{Key: key, Value: val})
+		if err != nil {
+			ReleaseResponse(response, false)
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// storeCache queues the cache write if the async cache is used, otherwise
+// writes the cache synchronously
+func (c *Crawler) storeCache(entry CacheEntry) error {
+	if c.asyncCache != nil && c.asyncCache.put(entry) {
+		return nil
+	}
+	return c.writeCache(entry)
+}
+
+// PingCache checks whether the cache backend is reachable, such as before a
+// long crawl. Nil is returned if the cache doesn't implement `Pinger`.
+func (c *Crawler) PingCache() error {
+	if c.cache == nil {
+		return ErrNoCache
+	}
+	return pingCache(c.cache)
+}
+
+// FlushCache waits for the queued cache writes of the async cache, see
+// `WithAsyncCache`, and the later caches are still written asynchronously.
+//
+// It is called by `Wait`, and should be called before exiting if the
+// crawler doesn't use the concurrency. `Stop` also waits for the queued
+// writes, after which the caches are written synchronously.
+func (c *Crawler) FlushCache() {
+	if c.asyncCache != nil {
+		c.asyncCache.flush()
+	}
+}
+
+// closeAsyncCache stops the async cache writes and waits for the queued
+// ones, then the caches are written synchronously
+func (c *Crawler) closeAsyncCache() {
+	if c.asyncCache != nil {
+		c.asyncCache.close()
+	}
+}
+
+// writeCache writes the cache with its own lock instead of `c.lock`, so the
+// cache writes don't contend with the unrelated operations of the crawler,
+// such as registering the handlers or switching the proxies.
+//
+// The ttl of the entry is ignored if the cache doesn't implement `TTLCache`.
+func (c *Crawler) writeCache(entry CacheEntry) error {
+	c.cacheLock.Lock()
+	// 必须在返回错误前释放锁，否则缓存出错时锁永远不会被释放，
+	// 协程池中的其他任务都会被阻塞
+	defer c.cacheLock.Unlock()
+
+	if tc, ok := c.cache.(TTLCache); ok && entry.TTL > 0 {
+		return tc.CacheWithTTL(entry.Key, entry.Value, entry.TTL)
+	}
+	return c.cache.Cache(entry.Key, entry.Value)
+}
+
+// writeCaches writes the caches queued by the async cache, which are written
+// at once if the cache implements `BatchCache`. The errors are only logged.
+func (c *Crawler) writeCaches(entries []CacheEntry) {
+	if bc, ok := c.cache.(BatchCache); ok && len(entries) > 1 {
+		c.cacheLock.Lock()
+		err := bc.CacheMany(entries)
+		c.cacheLock.Unlock()
+		if err != nil {
+			c.Error(err, log.Arg{Key: "cache_entries", Value: len(entries)})
+		}
+		return
+	}
+
+	for _, e := range entries {
+		if err := c.writeCache(e); err != nil {
+			c.Error(err, log.Arg{Key: "cache_key", Value: e.Key})
+		}
+	}
+}
+
+// checkCache reads the response from the cache. The read errors are treated
+// as cache misses unless `WithCacheFailOpen(false)` is used, so a flaky cache
+// backend doesn't make the requests fail.
 func (c *Crawler) checkCache(key string) (*Response, error) {
-	var err error
+	resp, err := c.readCache(key)
+	if err != nil {
+		if c.cacheFailClosed {
+			c.Error(err, log.Arg{Key: "cache_key", Value: key})
+			return nil, err
+		}
+
+		c.Warning("failed to read the cache, the request will be sent",
+			log.Arg{Key: "cache_key", Value: key},
+			log.Arg{Key: "msg", Value: err},
+		)
+		return nil, nil
+	}
+	return resp, nil
+}
+
+func (c *Crawler) readCache(key string) (resp *Response, err error) {
+	defer func() {
+		// 有的缓存后端在出错时会 panic
+		if r := recover(); r != nil {
+			resp, err = nil, fmt.Errorf("%w: %v", ErrCacheRead, r)
+		}
+	}()
+
 	cachedBody, ok := c.cache.IsCached(key)
 	if !ok {
 		return nil, nil
 	}
 
-	resp := new(Response)
+	resp = new(Response)
 	err = resp.Unmarshal(cachedBody)
 	if err != nil {
-		if c.log != nil {
-			c.log.Error(err)
-		}
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrCacheRead, err)
 	}
 	resp.FromCache = true
 	return resp, nil
@@ -447,11 +979,23 @@ func newFasthttpRequest(request *Request) *fasthttp.Request {
 	request.Headers.CopyTo(&req.Header)
 	req.SetURI(request.uri)
 
-	if request.Method() == MethodPost {
-		req.SetBody(request.Body)
+	if request.Method() == MethodPost || len(request.Body) > 0 {
+		switch request.compression {
+		case "gzip":
+			req.SetBody(fasthttp.AppendGzipBytes(nil, request.Body))
+			req.Header.Set("Content-Encoding", "gzip")
+		case "deflate":
+			req.SetBody(fasthttp.AppendDeflateBytes(nil, request.Body))
+			req.Header.Set("Content-Encoding", "deflate")
+		default:
+			req.SetBody(request.Body)
+		}
 	}
 
-	if request.Method() == MethodPost && req.Header.ContentType() == nil {
+	if request.noContentType {
+		// 请求头的 CopyTo 不会复制这个设置
+		req.Header.SetNoDefaultContentType(true)
+	} else if request.Method() == MethodPost && req.Header.ContentType() == nil {
 		req.Header.SetContentType("application/x-www-form-urlencoded")
 	}
 
@@ -469,38 +1013,157 @@ func newFasthttpRequest(request *Request) *fasthttp.Request {
 	return req
 }
 
-func (c *Crawler) do(request *Request) (*Response, *fasthttp.Response, error) {
-	req := newFasthttpRequest(request)
+// signRequest calls the request signer with the final headers and body of
+// `req`, and applies the changes of the headers to `req`.
+func (c *Crawler) signRequest(request *Request, req *fasthttp.Request) error {
+	req.Header.CopyTo(request.Headers)
 
-	if len(c.proxyURLPool) > 0 {
-		rand.Seed(time.Now().UnixMicro())
+	// 签名使用实际发送的请求体，签名后恢复原始请求体，以免重试时重复压缩
+	body := request.Body
+	request.Body = req.Body()
+	err := c.requestSigner(request)
+	request.Body = body
+	if err != nil {
+		return err
+	}
 
-		c.lock.Lock()
-		c.client.Dial = func(addr string) (net.Conn, error) {
-			return c.ProxyDialerWithTimeout(c.proxyURLPool[rand.Intn(len(c.proxyURLPool))], request.timeout)(addr)
-		}
-		c.lock.Unlock()
-		c.Debug("request infomation", log.Arg{Key: "header", Value: req.Header.String()}, log.Arg{Key: "proxy", Value: c.ProxyInUse()})
-	} else {
-		c.Debug("request infomation", log.Arg{Key: "header", Value: req.Header.String()})
+	request.Headers.CopyTo(&req.Header)
+	if request.noContentType {
+		// 请求头的 CopyTo 不会复制这个设置
+		req.Header.SetNoDefaultContentType(true)
+	}
+
+	return nil
+}
+
+// doWithHostLimit sends the request with a slot of the host if the per-host
+// concurrency is limited, see `WithPerHostConcurrency`. The slot reserved
+// by the worker is used for the first attempt, otherwise it waits for a
+// free slot.
+func (c *Crawler) doWithHostLimit(request *Request) (*Response, *fasthttp.Response, error) {
+	if c.perHostConcurrency == 0 {
+		return c.do(request)
+	}
+
+	// 出错或 panic 时也要归还名额，否则这个主机的请求会被永远阻塞
+	slot := request.hostSlot
+	if slot != nil {
+		// 预留的名额只用于第一次发送，之后的发送需要重新取得名额
+		request.hostSlot = nil
+		defer slot.release(true)
+	} else {
+		// 可能不在工作协程中，如 Crawler.Fetch
+		slot = c.acquireHostSlot(string(request.uri.Host()))
+		defer slot.release(false)
+	}
+
+	response, resp, err := c.do(request)
+	if response != nil && c.tracing {
+		response.timing.Wait = slot.wait
+	}
+	return response, resp, err
+}
+
+// send sends the request. If the crawler uses the goroutine pool, it returns
+// ErrCrawlerStopped without waiting for the response once the crawler is
+// stopped, otherwise the request is sent in the calling goroutine directly.
+//
+// The abandoned request ends in the background when the response arrives or
+// times out, and `req` and `resp` are released by send after that, so they
+// must not be used if ErrCrawlerStopped is returned.
+func (c *Crawler) send(client *fasthttp.Client, request *Request, req *fasthttp.Request, resp *fasthttp.Response) error {
+	if c.Context.Err() != nil {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+		return ErrCrawlerStopped
+	}
+
+	// 停止后 request 可能被释放和复用，所以先取出需要的字段
+	timeout, maxRedirects := request.timeout, int(request.maxRedirectsCount)
+
+	// 不使用协程池的爬虫很少被停止，不必为每个请求创建协程
+	if c.goPool == nil {
+		return doRequest(client, req, resp, timeout, maxRedirects)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- doRequest(client, req, resp, timeout, maxRedirects)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-c.Context.Done():
+		go func() {
+			<-done
+			fasthttp.ReleaseRequest(req)
+			fasthttp.ReleaseResponse(resp)
+		}()
+		return ErrCrawlerStopped
+	}
+}
+
+func doRequest(client *fasthttp.Client, req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration, maxRedirects int) error {
+	switch {
+	case maxRedirects > 0:
+		return client.DoRedirects(req, resp, maxRedirects)
+	case timeout > 0:
+		return client.DoTimeout(req, resp, timeout)
+	default:
+		return client.Do(req, resp)
+	}
+}
+
+func (c *Crawler) do(request *Request) (*Response, *fasthttp.Response, error) {
+	if request.firstAttempt.IsZero() {
+		request.firstAttempt = time.Now()
+	}
+
+	req := newFasthttpRequest(request)
+
+	client := c.client
+	if c.ProxyPoolAmount() > 0 {
+		client = c.proxyHTTPClient()
+		c.Debug("request infomation", log.Arg{Key: "header", Value: req.Header.String()}, log.Arg{Key: "proxy", Value: c.ProxyInUse()})
+	} else {
+		c.Debug("request infomation", log.Arg{Key: "header", Value: req.Header.String()})
 	}
 
 	var err error
 
 	resp := fasthttp.AcquireResponse()
 
-	if request.maxRedirectsCount == 0 {
-		if c.ProxyPoolAmount() > 0 {
-			req.SetConnectionClose()
-		}
+	// 使用代理池时，复用连接会导致一直使用同一个代理
+	if c.ProxyPoolAmount() > 0 || c.disableKeepAlive {
+		req.SetConnectionClose()
+	}
 
-		if request.timeout > 0 {
-			err = c.client.DoTimeout(req, resp, request.timeout)
-		} else {
-			err = c.client.Do(req, resp)
+	// HEAD 请求不读取响应体，即使服务器错误地返回了响应体
+	resp.SkipBody = req.Header.IsHead()
+
+	if c.requestSigner != nil {
+		if err = c.signRequest(request, req); err != nil {
+			c.Error(err, log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)})
+			fasthttp.ReleaseRequest(req)
+			fasthttp.ReleaseResponse(resp)
+			return nil, nil, err
 		}
-	} else {
-		err = c.client.DoRedirects(req, resp, int(request.maxRedirectsCount))
+	}
+
+	var start time.Time
+	if c.tracing {
+		start = time.Now()
+	}
+
+	err = c.send(client, request, req, resp)
+	if err == ErrCrawlerStopped {
+		// req 和 resp 已由 send 负责释放
+		return nil, nil, err
+	}
+	var elapsed time.Duration
+	if c.tracing {
+		elapsed = time.Since(start)
 	}
 	req.Header.CopyTo(request.Headers)
 
@@ -509,12 +1172,20 @@ func (c *Crawler) do(request *Request) (*Response, *fasthttp.Response, error) {
 	response.Body = append(response.Body, resp.Body()...)
 	response.Ctx = request.Ctx
 	response.Request = request
+	if request.maxRedirectsCount > 0 {
+		// 重定向时 req 中的 uri 已被 fasthttp 替换为最终的 url
+		response.finalURL = req.URI().String()
+	}
 	resp.Header.CopyTo(&response.Headers)
 	response.clientIP = resp.RemoteAddr()
 	response.localIP = resp.LocalAddr()
+	if c.tracing {
+		response.timing = Timing{Start: start, Total: elapsed}
+	}
 
-	if response.StatusCode == fasthttp.StatusOK && len(response.Body) == 0 {
-		// fasthttp.Response 会将空响应的状态码设置为 200，这不合理
+	if response.StatusCode == fasthttp.StatusOK && len(response.Body) == 0 && !req.Header.IsHead() {
+		// fasthttp.Response 会将空响应的状态码设置为 200，这不合理。
+		// HEAD 请求的响应本来就没有响应体
 		response.StatusCode = 0
 	}
 
@@ -523,11 +1194,11 @@ func (c *Crawler) do(request *Request) (*Response, *fasthttp.Response, error) {
 		err = ErrTimeout
 	}
 
-	if err == nil || err == ErrTimeout || err == fasthttp.ErrDialTimeout {
+	if err == nil || err == ErrTimeout || err == fasthttp.ErrDialTimeout || err == fasthttp.ErrTLSHandshakeTimeout {
 		if c.ProxyPoolAmount() > 0 && c.proxyInvalidCondition != nil {
 			e := c.proxyInvalidCondition(response)
 			if e != nil {
-				err = e
+				err = c.wrapProxyInvalidError(response, e)
 			}
 		}
 	}
@@ -555,42 +1226,66 @@ func (c *Crawler) do(request *Request) (*Response, *fasthttp.Response, error) {
 
 			return c.do(request)
 		} else {
-			if err == ErrTimeout || err == fasthttp.ErrDialTimeout {
+			if err == ErrTimeout || err == fasthttp.ErrDialTimeout || err == fasthttp.ErrTLSHandshakeTimeout {
 				// re-request if the request timed out.
 				// re-request 3 times by default when the request times out.
 
 				// if you are using a proxy, the timeout error is probably
 				// because the proxy is invalid, and it is recommended
 				// to try a new proxy
-				if c.retryCount == 0 {
-					c.retryCount = 3
+				//
+				// 并发的请求共享同一个爬虫，不能修改 c.retryCount
+				retryCount := c.retryCount
+				if retryCount == 0 {
+					retryCount = 3
 				}
 
 				c.Error(err, log.Arg{Key: "timeout", Value: request.timeout.String()}, log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)})
 
-				if atomic.LoadUint32(&request.retryCounter) < c.retryCount {
+				if atomic.LoadUint32(&request.retryCounter) < retryCount && !c.retryExpired(request) {
 					c.retryPrepare(request, req, resp)
 					return c.do(request)
 				}
 				fasthttp.ReleaseRequest(req)
 				fasthttp.ReleaseResponse(resp)
-				ReleaseResponse(response, true)
+				ReleaseResponse(response, false)
 
 				return nil, nil, ErrTimeout
+			} else if err == fasthttp.ErrBodyTooLarge {
+				// 响应体过大不是致命错误，重试也没有意义
+				c.Error(err, log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)})
+
+				fasthttp.ReleaseRequest(req)
+				fasthttp.ReleaseResponse(resp)
+				ReleaseResponse(response, false)
+
+				return nil, nil, ErrBodyTooLarge
 			} else {
 				if err == fasthttp.ErrConnectionClosed {
 					// Feature error of fasthttp, there is no solution yet, only try again if c.retryCount > 0 or panic
 					c.Error(err, log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)})
 
-					if c.retryCount == 0 {
-						c.retryCount = 1
+					retryCount := c.retryCount
+					if retryCount == 0 {
+						retryCount = 1
 					}
 
-					if atomic.LoadUint32(&request.retryCounter) < c.retryCount {
+					if atomic.LoadUint32(&request.retryCounter) < retryCount && !c.retryExpired(request) {
 						c.retryPrepare(request, req, resp)
 						return c.do(request)
 					}
 				}
+
+				if request.returnNetErr {
+					c.Error(err, log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)})
+
+					fasthttp.ReleaseRequest(req)
+					fasthttp.ReleaseResponse(resp)
+					ReleaseResponse(response, false)
+
+					return nil, nil, err
+				}
+
 				c.FatalOrPanic(err)
 				return nil, nil, err
 			}
@@ -601,21 +1296,185 @@ func (c *Crawler) do(request *Request) (*Response, *fasthttp.Response, error) {
 
 	// Only count successful responses
 	atomic.AddUint32(&c.responseCount, 1)
+	atomic.AddUint64(&c.bytesSent, uint64(len(req.Body())))
+	atomic.AddUint64(&c.bytesReceived, uint64(len(response.Body)))
 	// release req
 	fasthttp.ReleaseRequest(req)
 
-	if c.retryCount > 0 && atomic.LoadUint32(&request.retryCounter) < c.retryCount {
-		if c.retryCondition != nil && c.retryCondition(response) {
+	if c.acceptEncoding != "" {
+		if err = decodeBody(response, resp); err != nil {
+			c.Error(err, log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)})
+
+			fasthttp.ReleaseResponse(resp)
+			ReleaseResponse(response, false)
+
+			return nil, nil, err
+		}
+	}
+
+	invalid := c.validateResponse(response)
+
+	if c.retryCount > 0 && (invalid || c.retryCondition != nil && c.retryCondition(response)) {
+		if atomic.LoadUint32(&request.retryCounter) < c.retryCount && !c.retryExpired(request) {
 			c.Warning("the response meets the retry condition and will be retried soon")
-			c.retryPrepare(request, req, resp)
+			// req 已经被释放，不能再用 retryPrepare 释放一次，否则会有两个请求共用同一个 req
+			c.countRetry(request)
+			fasthttp.ReleaseResponse(resp)
+			// 请求还要用于重试，不能随响应一起释放
+			response.Request = nil
+			ReleaseResponse(response, false)
 			return c.do(request)
 		}
+
+		// 重试已用尽，返回最后一次的响应
+		response.retriesExhausted = true
 	}
 
 	return response, resp, nil
 }
 
+// decodeBody decodes the body of the response according to the
+// "Content-Encoding" header, which is removed after decoding
+func decodeBody(response *Response, resp *fasthttp.Response) error {
+	encoding := string(resp.Header.ContentEncoding())
+	// HEAD 请求的响应没有响应体
+	if encoding == "" || len(resp.Body()) == 0 {
+		return nil
+	}
+
+	body, err := resp.BodyUncompressed()
+	if err != nil {
+		if err == fasthttp.ErrContentEncodingUnsupported {
+			return fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+		}
+		return fmt.Errorf("failed to decode the %s response body: %w", encoding, err)
+	}
+
+	response.Body = append(response.Body[:0], body...)
+	response.Headers.Del("Content-Encoding")
+	response.Headers.SetContentLength(len(body))
+
+	return nil
+}
+
+// validateResponse validates the response with the response validator, and
+// reports whether the validation fails
+func (c *Crawler) validateResponse(response *Response) bool {
+	if c.responseValidator == nil {
+		return false
+	}
+
+	err := c.responseValidator(response)
+	if err == nil {
+		return false
+	}
+	response.validationErr = err
+
+	c.Warning(
+		"the response is invalid",
+		log.Arg{Key: "error", Value: err.Error()},
+		log.Arg{Key: "url", Value: response.Request.URL()},
+		log.Arg{Key: "request_id", Value: atomic.LoadUint32(&response.Request.ID)},
+	)
+
+	return true
+}
+
+// proxyHTTPClient returns the client dialing through the proxy pool.
+//
+// It is created from the settings of `c.client` on first use instead of
+// setting the dial function of `c.client`, which may be shared with the
+// other crawlers, see `WithHTTPClient`.
+func (c *Crawler) proxyHTTPClient() *fasthttp.Client {
+	c.lock.RLock()
+	client := c.proxyClient
+	c.lock.RUnlock()
+	if client != nil {
+		return client
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.proxyClient == nil {
+		rand.Seed(time.Now().UnixMicro())
+
+		c.proxyClient = &fasthttp.Client{
+			Name:                          c.client.Name,
+			NoDefaultUserAgentHeader:      c.client.NoDefaultUserAgentHeader,
+			Dial:                          c.dialProxy,
+			TLSConfig:                     c.client.TLSConfig,
+			MaxConnsPerHost:               c.client.MaxConnsPerHost,
+			MaxIdleConnDuration:           c.client.MaxIdleConnDuration,
+			MaxConnDuration:               c.client.MaxConnDuration,
+			MaxIdemponentCallAttempts:     c.client.MaxIdemponentCallAttempts,
+			ReadBufferSize:                c.client.ReadBufferSize,
+			WriteBufferSize:               c.client.WriteBufferSize,
+			ReadTimeout:                   c.client.ReadTimeout,
+			WriteTimeout:                  c.client.WriteTimeout,
+			MaxResponseBodySize:           c.client.MaxResponseBodySize,
+			DisableHeaderNamesNormalizing: c.client.DisableHeaderNamesNormalizing,
+			DisablePathNormalizing:        c.client.DisablePathNormalizing,
+			MaxConnWaitTimeout:            c.client.MaxConnWaitTimeout,
+			RetryIf:                       c.client.RetryIf,
+			ConnPoolStrategy:              c.client.ConnPoolStrategy,
+			ConfigureClient:               c.client.ConfigureClient,
+		}
+	}
+	return c.proxyClient
+}
+
+// dialProxy connects to `addr` through a random proxy of the proxy pool.
+//
+// The connection to the proxy is limited by the dial timeout, see
+// `WithDialTimeout`, and the whole request is still limited by the timeout
+// of the request.
+func (c *Crawler) dialProxy(addr string) (net.Conn, error) {
+	c.lock.RLock()
+	if len(c.proxyURLPool) == 0 {
+		c.lock.RUnlock()
+		return nil, proxy.NewProxyError(
+			proxy.ErrEmptyProxyPoolCode,
+			"",
+			errors.New("the current proxy pool is empty"),
+		)
+	}
+	proxyAddr := c.proxyURLPool[rand.Intn(len(c.proxyURLPool))]
+	c.lock.RUnlock()
+
+	return c.ProxyDialerWithTimeout(proxyAddr, c.dialTimeout)(addr)
+}
+
+// wrapProxyInvalidError converts the error returned by the custom
+// `ProxyInvalidCondition` into a proxy error, so that the proxy used
+// by the response can be removed from the proxy pool.
+func (c *Crawler) wrapProxyInvalidError(response *Response, err error) error {
+	if _, ok := proxy.IsProxyError(err); ok {
+		return err
+	}
+
+	// 使用代理时，响应的远程地址就是代理的地址
+	proxyAddr := response.ClientIP()
+	if proxyAddr == "" {
+		proxyAddr = c.ProxyInUse()
+	}
+
+	return proxy.NewProxyError(
+		proxy.ErrInvalidProxyCode,
+		proxyAddr,
+		fmt.Errorf("the response meets the proxy invalid condition: %w", err),
+	)
+}
+
 func (c *Crawler) retryPrepare(request *Request, req *fasthttp.Request, resp *fasthttp.Response) {
+	c.countRetry(request)
+	fasthttp.ReleaseRequest(req)
+	fasthttp.ReleaseResponse(resp)
+}
+
+// countRetry increases the retry counter of the request, logs the retry and
+// waits for the backoff delay before the retry
+func (c *Crawler) countRetry(request *Request) {
 	atomic.AddUint32(&request.retryCounter, 1)
 	c.Info(
 		"retrying",
@@ -624,8 +1483,55 @@ func (c *Crawler) retryPrepare(request *Request, req *fasthttp.Request, resp *fa
 		log.Arg{Key: "url", Value: request.URL()},
 		log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)},
 	)
-	fasthttp.ReleaseRequest(req)
-	fasthttp.ReleaseResponse(resp)
+
+	if d := c.retryDelay(request); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// retryExpired reports whether the request has been retried for longer than
+// the max retry duration
+func (c *Crawler) retryExpired(request *Request) bool {
+	if c.maxRetryDuration <= 0 || time.Since(request.firstAttempt) < c.maxRetryDuration {
+		return false
+	}
+
+	c.Warning(
+		"the max retry duration has been exceeded and the request will not be retried",
+		log.Arg{Key: "max_retry_duration", Value: c.maxRetryDuration.String()},
+		log.Arg{Key: "retry_count", Value: atomic.LoadUint32(&request.retryCounter)},
+		log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)},
+	)
+	return true
+}
+
+// retryDelay returns a random delay between 0 and the exponential backoff of
+// the current retry, which never exceeds the rest of the max retry duration
+func (c *Crawler) retryDelay(request *Request) time.Duration {
+	if c.retryBackoffBase <= 0 {
+		return 0
+	}
+
+	backoff := c.retryBackoffMax
+	// 避免移位溢出
+	if n := atomic.LoadUint32(&request.retryCounter) - 1; n < 32 {
+		if d := c.retryBackoffBase << n; d > 0 && (backoff <= 0 || d < backoff) {
+			backoff = d
+		}
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	// 随机抖动，避免大量请求同时重试
+	delay := time.Duration(rand.Int63n(int64(backoff) + 1))
+
+	if c.maxRetryDuration > 0 {
+		if rest := c.maxRetryDuration - time.Since(request.firstAttempt); delay > rest {
+			delay = rest
+		}
+	}
+	return delay
 }
 
 func createBody(requestData map[string]string) []byte {
@@ -639,6 +1545,25 @@ func createBody(requestData map[string]string) []byte {
 	return []byte(form.Encode())
 }
 
+// createOrderedBody encodes the `fields` in order, unlike `url.Values.Encode`
+// which sorts the keys
+func createOrderedBody(fields []FormField) []byte {
+	if fields == nil {
+		return nil
+	}
+
+	var b strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(url.QueryEscape(field.Key))
+		b.WriteByte('=')
+		b.WriteString(url.QueryEscape(field.Value))
+	}
+	return []byte(b.String())
+}
+
 func NewRequestHeaders(headers map[string]string) *fasthttp.RequestHeader {
 	reqHeaders := new(fasthttp.RequestHeader)
 
@@ -658,37 +1583,54 @@ func setRequestHeaders(headers map[string]string) *fasthttp.RequestHeader {
 	return header
 }
 
-func (c *Crawler) get(URL string, headers map[string]string, ctx pctx.Context, isChained bool, cacheFields ...CacheField) error {
-	// Parse the query parameters and create a `cachedMap` based on `cacheFields`
+// queryCachedMap parses the query parameters and creates a `cachedMap`
+// based on `cacheFields`, which can only be the query parameters
+func queryCachedMap(URL string, cacheFields []CacheField) (map[string]string, error) {
+	if len(cacheFields) == 0 {
+		return nil, nil
+	}
+
 	u, err := url.Parse(URL)
 	if err != nil {
-		c.Error(err)
-		return err
+		return nil, err
 	}
 
 	params := u.Query()
-	var cachedMap map[string]string
-	if len(cacheFields) > 0 {
-		cachedMap = make(map[string]string)
-		for _, field := range cacheFields {
-			if field.code != queryParam {
-				c.FatalOrPanic(ErrNotAllowedCacheFieldType)
-			}
-
-			key, value, err := addQueryParamCacheField(params, field)
-			if err != nil {
-				c.FatalOrPanic(err)
-			}
+	cachedMap := make(map[string]string)
+	for _, field := range cacheFields {
+		if field.code != queryParam {
+			return nil, ErrNotAllowedCacheFieldType
+		}
 
-			cachedMap[key] = value
+		key, value, err := addQueryParamCacheField(params, field)
+		if err != nil {
+			return nil, err
 		}
 
+		cachedMap[key] = value
+	}
+
+	return cachedMap, nil
+}
+
+func (c *Crawler) get(URL string, headers map[string]string, ctx pctx.Context, isChained bool, opts []func(*Request), cacheFields ...CacheField) error {
+	if _, err := url.Parse(URL); err != nil {
+		c.Error(err)
+		return err
+	}
+
+	cachedMap, err := queryCachedMap(URL, cacheFields)
+	if err != nil {
+		c.Error(err)
+		return err
+	}
+	if cachedMap != nil {
 		c.Debug("use some specified cache fields", log.Arg{Key: "cached_map", Value: cachedMap})
 	}
 
 	reqHeader := setRequestHeaders(headers)
 
-	return c.request(MethodGet, URL, nil, cachedMap, reqHeader, ctx, isChained)
+	return c.request(MethodGet, URL, nil, cachedMap, reqHeader, ctx, isChained, opts...)
 }
 
 // Get is used to send GET requests
@@ -698,10 +1640,126 @@ func (c *Crawler) Get(URL string) error {
 
 // GetWithCtx is used to send GET requests with a context
 func (c *Crawler) GetWithCtx(URL string, ctx pctx.Context) error {
-	return c.get(URL, nil, ctx, false, c.cacheFields...)
+	return c.get(URL, nil, ctx, false, nil, c.cacheFields...)
+}
+
+// GetSync sends a GET request and waits for it to be done, and returns a
+// copy of the response. Unlike `Get`, it blocks even if the concurrency is
+// used, but the request is still sent through the goroutine pool, so the
+// other requests in the pool are not waited for.
+//
+// The handlers are called with the response as usual, and the returned
+// response is copied before the html and json handlers, see
+// `Response.Clone`, which should be released with `ReleaseResponse(r, true)`
+// after use. The response is nil if an error occurs or the request is aborted
+// by a `BeforeRequest` handler.
+func (c *Crawler) GetSync(URL string) (*Response, error) {
+	var (
+		response *Response
+		done     = make(chan error, 1)
+	)
+
+	err := c.get(URL, nil, nil, false, []func(*Request){func(r *Request) {
+		r.capture = func(resp *Response) {
+			response, _ = resp.Clone(true)
+		}
+		r.done = func(err error) {
+			done <- err
+		}
+	}}, c.cacheFields...)
+	// 不使用协程池时请求已经完成，请求未能发出时不会调用 done
+	if err == nil && c.goPool != nil {
+		err = <-done
+	}
+
+	if err != nil {
+		if response != nil {
+			ReleaseResponse(response, true)
+		}
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Fetch sends a GET request and returns the response directly, like
+// `http.Get`, which is convenient for the one-shot fetches.
+//
+// The request is sent in the calling goroutine instead of the goroutine
+// pool, with the user agent, the default headers, the cookies, the proxies,
+// the retries and the cache of the crawler, but none of the handlers is
+// called, use `GetSync` to send a request with the handlers. An `*HTTPError`
+// is returned if the status code meets the condition of `WithStatusErrors`,
+// and the network errors, such as a refused connection, are returned too.
+//
+// The returned response has a new context, and should be released with
+// `ReleaseResponse(r, true)` after use.
+func (c *Crawler) Fetch(URL string) (*Response, error) {
+	if c.Context.Err() != nil {
+		return nil, ErrCrawlerStopped
+	}
+
+	response, err := c.fetch(MethodGet, URL)
+	if err != nil {
+		c.Error(err, log.Arg{Key: "url", Value: URL})
+		return nil, err
+	}
+
+	if c.statusErrors != nil && c.statusErrors(response.StatusCode) {
+		err = &HTTPError{
+			StatusCode: response.StatusCode,
+			URL:        response.Request.URL(),
+			Body:       append([]byte(nil), response.Body...),
+		}
+		ReleaseResponse(response, false)
+		return nil, err
+	}
+
+	// 只传入一个 op 时不会返回错误
+	ctx, _ := pctx.AcquireCtx(c.ctxOp)
+	response.Ctx = ctx
+	response.Request.Ctx = ctx
+
+	return response, nil
+}
+
+// Head sends a HEAD request and returns the response synchronously, which
+// has the status code and the headers but no body, such as to check the
+// links or the "Content-Type" without downloading the bodies.
+//
+// The user agent, the default headers, the cookies, the proxies and the
+// cache of the crawler are used, but none of the handlers is called. The
+// returned response should be released with `ReleaseResponse` after use.
+//
+// The network errors, such as a host refusing the connection, are returned
+// as well.
+func (c *Crawler) Head(URL string) (*Response, error) {
+	if c.Context.Err() != nil {
+		return nil, ErrCrawlerStopped
+	}
+
+	response, err := c.fetch(MethodHead, URL)
+	if err != nil {
+		c.Error(err, log.Arg{Key: "url", Value: URL})
+		return nil, err
+	}
+	return response, nil
 }
 
-func (c *Crawler) post(URL string, requestData, headers map[string]string, ctx pctx.Context, isChained bool, cacheFields ...CacheField) error {
+func (c *Crawler) post(URL string, requestData, headers map[string]string, ctx pctx.Context, isChained bool, opts []func(*Request), cacheFields ...CacheField) error {
+	var form url.Values
+	if requestData != nil {
+		form = make(url.Values, len(requestData))
+		for k, v := range requestData {
+			form.Set(k, v)
+		}
+	}
+	return c.postForm(URL, createBody(requestData), form, headers, ctx, isChained, opts, cacheFields...)
+}
+
+// postForm sends the encoded form `body`, and `form` is used to look up the
+// cache fields in the body
+func (c *Crawler) postForm(URL string, body []byte, form url.Values, headers map[string]string, ctx pctx.Context, isChained bool, opts []func(*Request), cacheFields ...CacheField) error {
 	var cachedMap map[string]string
 	if len(cacheFields) > 0 {
 		cachedMap = make(map[string]string)
@@ -718,7 +1776,8 @@ func (c *Crawler) post(URL string, requestData, headers map[string]string, ctx p
 				if queryParams == nil {
 					u, err := url.Parse(URL)
 					if err != nil {
-						c.FatalOrPanic(err)
+						c.Error(err)
+						return err
 					}
 
 					queryParams = u.Query()
@@ -726,11 +1785,12 @@ func (c *Crawler) post(URL string, requestData, headers map[string]string, ctx p
 
 				key, value, err = addQueryParamCacheField(queryParams, field)
 			case requestBodyParam:
-				if val, ok := requestData[field.Field]; ok {
-					key, value = field.String(), val
+				if vals, ok := form[field.Field]; ok {
+					// 重复的字段的所有值都作为缓存标志
+					key, value = field.String(), strings.Join(vals, ",")
 				} else {
-					keys := make([]string, 0, len(requestData))
-					for k := range requestData {
+					keys := make([]string, 0, len(form))
+					for k := range form {
 						keys = append(keys, k)
 					}
 
@@ -741,7 +1801,8 @@ func (c *Crawler) post(URL string, requestData, headers map[string]string, ctx p
 			}
 
 			if err != nil {
-				c.FatalOrPanic(err)
+				c.Error(err)
+				return err
 			}
 
 			cachedMap[key] = value
@@ -760,27 +1821,175 @@ func (c *Crawler) post(URL string, requestData, headers map[string]string, ctx p
 
 	reqHeader := setRequestHeaders(headers)
 
-	return c.request(MethodPost, URL, createBody(requestData), cachedMap, reqHeader, ctx, isChained)
+	return c.request(MethodPost, URL, body, cachedMap, reqHeader, ctx, isChained, opts...)
 }
 
 // Post is used to send POST requests
 func (c *Crawler) Post(URL string, requestData map[string]string, ctx pctx.Context) error {
-	return c.post(URL, requestData, nil, ctx, false, c.cacheFields...)
+	return c.post(URL, requestData, nil, ctx, false, nil, c.cacheFields...)
 }
 
-func (c *Crawler) createJSONBody(requestData map[string]any) []byte {
-	if requestData == nil {
-		return nil
+// PostForm sends a POST request whose content-type is
+// `application/x-www-form-urlencoded` with `values`, which can have the
+// repeated keys, such as `tag=a&tag=b`.
+//
+// The body is encoded by `url.Values.Encode`, so the keys are sorted and the
+// values of a key keep their order. Use `PostOrderedForm` if the fields must
+// be sent in a specific order.
+func (c *Crawler) PostForm(URL string, values url.Values, ctx pctx.Context) error {
+	var body []byte
+	if values != nil {
+		body = []byte(values.Encode())
+	}
+	return c.postForm(URL, body, values, nil, ctx, false, nil, c.cacheFields...)
+}
+
+// FormField is a field of the form sent by `PostOrderedForm`
+type FormField struct {
+	Key, Value string
+}
+
+// PostOrderedForm sends a POST request whose content-type is
+// `application/x-www-form-urlencoded` with the `fields` encoded in the given
+// order, such as for the apis signing the exact encoded body. The keys can
+// be repeated.
+func (c *Crawler) PostOrderedForm(URL string, fields []FormField, ctx pctx.Context) error {
+	form := make(url.Values, len(fields))
+	for _, field := range fields {
+		form.Add(field.Key, field.Value)
+	}
+	return c.postForm(URL, createOrderedBody(fields), form, nil, ctx, false, nil, c.cacheFields...)
+}
+
+// GetAll sends GET requests to `urls` and returns after all of them are
+// done. The requests are sent through the goroutine pool if the concurrency
+// is used, and the pool is still usable after `GetAll` returns.
+//
+// A `*BatchError` containing the errors of the failed requests is returned
+// if any request fails.
+func (c *Crawler) GetAll(urls []string) error {
+	return c.all(urls, func(URL string, done func(*Request)) error {
+		return c.get(URL, nil, nil, false, []func(*Request){done}, c.cacheFields...)
+	})
+}
+
+// PostAll sends POST requests with the same `requestData` to `urls`, see
+// `GetAll`.
+func (c *Crawler) PostAll(urls []string, requestData map[string]string) error {
+	return c.all(urls, func(URL string, done func(*Request)) error {
+		return c.post(URL, requestData, nil, nil, false, []func(*Request){done}, c.cacheFields...)
+	})
+}
+
+// Paginate requests the pages one by one until `next` returns false.
+//
+// `next` is called with nil to get the url of the first page, and then with
+// the response of the previous page to get the url of the next page. Each
+// page is handled by `handle` before `next` is called, and all the pages
+// share the same context, which can be used to pass data between pages.
+//
+// The pages are requested in the calling goroutine with the user agent, the
+// cookies, the proxies and the cache of the crawler, but the handlers of the
+// crawler are not called. The responses are released after `next` returns,
+// so they shouldn't be used after that.
+//
+// Paginating stops at the first page that fails, such as a page whose host
+// refuses the connection, and the error is returned.
+func (c *Crawler) Paginate(next func(prev *Response) (string, bool), handle HandleResponse) error {
+	ctx, err := pctx.AcquireCtx(c.ctxOp)
+	if err != nil {
+		return err
+	}
+	defer pctx.ReleaseCtx(ctx)
+
+	URL, ok := next(nil)
+	for ok {
+		if c.Context.Err() != nil {
+			return ErrCrawlerStopped
+		}
+
+		response, err := c.fetch(MethodGet, URL)
+		if err != nil {
+			return err
+		}
+		response.Ctx = ctx
+		response.Request.Ctx = ctx
+
+		handle(response)
+
+		URL, ok = next(response)
+		ReleaseResponse(response, false)
+	}
+
+	return nil
+}
+
+// all sends the requests to `urls` with `send` and waits for them to be done.
+// `send` should apply the option to the request, which records the result
+// of the request when the request is done.
+func (c *Crawler) all(urls []string, send func(URL string, done func(*Request)) error) error {
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		errs = make(map[string]error)
+	)
+
+	for _, URL := range urls {
+		URL := URL
+
+		var once sync.Once
+		finish := func(err error) {
+			once.Do(func() {
+				if err != nil {
+					lock.Lock()
+					errs[URL] = err
+					lock.Unlock()
+				}
+				wg.Done()
+			})
+		}
+
+		wg.Add(1)
+		err := send(URL, func(r *Request) {
+			r.done = finish
+		})
+		if err != nil {
+			// 请求未能发出时不会调用 done
+			finish(err)
+		}
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return &BatchError{Errors: errs}
+	}
+	return nil
+}
+
+func (c *Crawler) createJSONBody(requestData any) ([]byte, error) {
+	switch v := requestData.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		// 值为 nil 的 map 不会被序列化为 null
+		if v == nil {
+			return nil, nil
+		}
 	}
 	body, err := json.Marshal(requestData)
 	if err != nil {
-		c.FatalOrPanic(err)
+		c.Error(err)
+		return nil, err
 	}
-	return body
+	return body, nil
 }
 
-func (c *Crawler) postJSON(URL string, requestData map[string]any, headers map[string]string, ctx pctx.Context, isChained bool, cacheFields ...CacheField) error {
-	body := c.createJSONBody(requestData)
+func (c *Crawler) postJSON(URL string, requestData any, headers map[string]string, ctx pctx.Context, isChained bool, cacheFields ...CacheField) error {
+	body, err := c.createJSONBody(requestData)
+	if err != nil {
+		return err
+	}
 
 	var cachedMap map[string]string
 	if len(cacheFields) > 0 {
@@ -800,7 +2009,8 @@ func (c *Crawler) postJSON(URL string, requestData map[string]any, headers map[s
 				if queryParams == nil {
 					u, err := url.Parse(URL)
 					if err != nil {
-						c.FatalOrPanic(err)
+						c.Error(err)
+						return err
 					}
 
 					queryParams = u.Query()
@@ -814,7 +2024,7 @@ func (c *Crawler) postJSON(URL string, requestData map[string]any, headers map[s
 					for k := range m {
 						keys = append(keys, k)
 					}
-					err = fmt.Errorf("there is no such field [%s] in the request body: %v", field, keys)
+					err = fmt.Errorf("there is no such field [%s] in the request body: %v", field.Field, keys)
 				} else {
 					key, value = field.String(), bodyJson.Get(field.Field).String()
 				}
@@ -823,7 +2033,8 @@ func (c *Crawler) postJSON(URL string, requestData map[string]any, headers map[s
 			}
 
 			if err != nil {
-				c.FatalOrPanic(err)
+				c.Error(err)
+				return err
 			}
 
 			cachedMap[key] = value
@@ -847,6 +2058,16 @@ func (c *Crawler) PostJSON(URL string, requestData map[string]any, ctx pctx.Cont
 	return c.postJSON(URL, requestData, nil, ctx, false, c.cacheFields...)
 }
 
+// PostJSONBody is used to send POST requests whose content-type is json,
+// and whose body is `v` marshaled with the json package, `v` can be any
+// value, such as a struct, a slice or a map.
+//
+// The cache fields of the request body are the paths of gjson, such as
+// "user.name" or "0.id" for a slice.
+func (c *Crawler) PostJSONBody(URL string, v any, ctx pctx.Context) error {
+	return c.postJSON(URL, v, nil, ctx, false, c.cacheFields...)
+}
+
 func (c *Crawler) postMultipart(URL string, form *MultipartForm, headers map[string]string, ctx pctx.Context, isChained bool, cacheFields ...CacheField) error {
 	var cachedMap map[string]string
 	if len(cacheFields) > 0 {
@@ -865,7 +2086,8 @@ func (c *Crawler) postMultipart(URL string, form *MultipartForm, headers map[str
 				if queryParams == nil {
 					u, err := url.Parse(URL)
 					if err != nil {
-						c.FatalOrPanic(err)
+						c.Error(err)
+						return err
 					}
 
 					queryParams = u.Query()
@@ -880,60 +2102,219 @@ func (c *Crawler) postMultipart(URL string, form *MultipartForm, headers map[str
 					for k := range form.bodyMap {
 						keys = append(keys, k)
 					}
-					err = fmt.Errorf("there is no such field [%s] in the request body: %v", field, keys)
+					err = fmt.Errorf("there is no such field [%s] in the request body: %v", field.Field, keys)
 				}
 			default:
 				err = ErrInvalidCacheTypeCode
 			}
 
 			if err != nil {
-				c.FatalOrPanic(err)
+				c.Error(err)
+				return err
 			}
 
-			cachedMap[key] = value
-		}
+			cachedMap[key] = value
+		}
+
+		c.Debug("use some specified cache fields", log.Arg{Key: "cached_map", Value: cachedMap})
+	}
+
+	if len(headers) == 0 {
+		headers = make(map[string]string)
+	}
+	headers["Content-Type"] = form.FormDataContentType()
+
+	reqHeader := setRequestHeaders(headers)
+
+	return c.request(MethodPost, URL, form.Bytes(), cachedMap, reqHeader, ctx, isChained)
+}
+
+// PostMultipart is used to send POST requests whose content-type is `multipart/form-data`
+func (c *Crawler) PostMultipart(URL string, form *MultipartForm, ctx pctx.Context) error {
+	return c.postMultipart(URL, form, nil, ctx, false, c.cacheFields...)
+}
+
+// PostRaw is used to send POST requests whose content-type is not in [json, `application/x-www-form-urlencoded`, `multipart/form-data`].
+// No "Content-Type" is sent, see `PostRawWithContentType` to set one.
+//
+// When the cache is used, the URL and the whole body together identify the
+// request.
+func (c *Crawler) PostRaw(URL string, body []byte, ctx pctx.Context) error {
+	return c.PostRawWithContentType(URL, body, "", ctx)
+}
+
+// PostRawWithContentType sends a POST request with the raw `body` and the
+// `contentType`, such as "application/xml" or "text/plain". No "Content-Type"
+// is sent if `contentType` is empty, instead of the default one of fasthttp.
+func (c *Crawler) PostRawWithContentType(URL string, body []byte, contentType string, ctx pctx.Context) error {
+	reqHeader := AcquireRequestHeader()
+	if contentType != "" {
+		reqHeader.SetContentType(contentType)
+	}
+
+	// 缓存键直接由请求体生成，见 Request.marshal
+	return c.request(MethodPost, URL, body, nil, reqHeader, ctx, false, func(r *Request) {
+		r.noContentType = contentType == ""
+	})
+}
+
+// Do sends a request with any method, such as `DELETE` with a body or
+// `PROPFIND`, through the same process as the other request methods,
+// including retry, proxy, cache and handlers.
+//
+// When the cache is used, the method, the URL and the whole body together
+// identify the request.
+func (c *Crawler) Do(method, URL string, body []byte, headers map[string]string, ctx pctx.Context) error {
+	return c.request(method, URL, body, nil, setRequestHeaders(headers), ctx, false)
+}
+
+/************************* Public methods ****************************/
+
+// ClearCache will clear all cache
+func (c *Crawler) ClearCache() error {
+	if c.cache == nil {
+		c.Error(ErrNoCache)
+		return ErrNoCache
+	}
+	if c.log != nil {
+		c.Warning("clear all cache")
+	}
+	return c.cache.Clear()
+}
+
+// DeleteCache removes the cached response of `key`, which is the hash of the
+// request, see `Request.Hash`.
+func (c *Crawler) DeleteCache(key string) error {
+	if c.cache == nil {
+		c.Error(ErrNoCache)
+		return ErrNoCache
+	}
+	c.Debug("delete cache", log.Arg{Key: "key", Value: key})
+	return c.cache.Delete(key)
+}
+
+// InvalidateURL removes the cached response of the request to `URL` with
+// `method`, whose key is computed the same as `Request.Hash`, so the next
+// request to `URL` will be sent again.
+//
+// Only the cache fields of the query parameters are supported. For the
+// requests whose cache keys depend on their bodies, get the key with
+// `Request.Hash` and remove it with `DeleteCache`.
+func (c *Crawler) InvalidateURL(method, URL string, cacheFields ...CacheField) error {
+	if c.cache == nil {
+		c.Error(ErrNoCache)
+		return ErrNoCache
+	}
 
-		c.Debug("use some specified cache fields", log.Arg{Key: "cached_map", Value: cachedMap})
+	cachedMap, err := queryCachedMap(URL, cacheFields)
+	if err != nil {
+		return err
 	}
 
-	if len(headers) == 0 {
-		headers = make(map[string]string)
+	uri, err := c.parseURI(URL)
+	if err != nil {
+		return err
 	}
-	headers["Content-Type"] = form.FormDataContentType()
 
-	reqHeader := setRequestHeaders(headers)
+	request := AcquireRequest()
+	defer ReleaseRequest(request)
 
-	return c.request(MethodPost, URL, form.Bytes(), cachedMap, reqHeader, ctx, isChained)
+	request.Headers = AcquireRequestHeader()
+	request.Headers.SetMethod(method)
+	request.uri = uri
+	request.cachedMap = cachedMap
+	request.crawler = c
+
+	key, err := request.Hash()
+	if err != nil {
+		return err
+	}
+
+	return c.DeleteCache(key)
 }
 
-// PostMultipart is used to send POST requests whose content-type is `multipart/form-data`
-func (c *Crawler) PostMultipart(URL string, form *MultipartForm, ctx pctx.Context) error {
-	return c.postMultipart(URL, form, nil, ctx, false, c.cacheFields...)
+// exportedCache is a line of the file exported by `ExportCache`, whose value
+// is encoded in base64
+type exportedCache struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
 }
 
-// PostRaw is used to send POST requests whose content-type is not in [json, `application/x-www-form-urlencoded`, `multipart/form-data`]
-func (c *Crawler) PostRaw(URL string, body []byte, ctx pctx.Context) error {
-	cachedMap := map[string]string{
-		"cache": string(body),
+// ExportCache writes all the cached responses to `w` as newline-delimited
+// json, each line of which is an object with the "key" and the base64 encoded
+// "value", such as for the offline analysis. The cache must implement
+// `Iterable`, otherwise ErrCacheNotIterable is returned.
+//
+// The writes queued by the async cache are not exported until they are
+// flushed, see `FlushCache`.
+func (c *Crawler) ExportCache(w io.Writer) error {
+	if c.cache == nil {
+		c.Error(ErrNoCache)
+		return ErrNoCache
 	}
-	return c.request(MethodPost, URL, body, cachedMap, nil, ctx, false)
-}
 
-/************************* Public methods ****************************/
+	it, ok := c.cache.(Iterable)
+	if !ok {
+		return ErrCacheNotIterable
+	}
 
-// ClearCache will clear all cache
-func (c *Crawler) ClearCache() error {
+	bw := bufio.NewWriter(w)
+	var count int
+	err := it.Iterate(func(key string, val []byte) error {
+		line, err := json.Marshal(exportedCache{key, val})
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+
+		count++
+		_, err = bw.Write(line)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Debug("exported the cache", log.Arg{Key: "count", Value: count})
+	return bw.Flush()
+}
+
+// ImportCache reads the cached responses exported by `ExportCache` from `r`
+// and writes them to the cache, and the existing caches with the same keys
+// are overwritten if the cache allows.
+func (c *Crawler) ImportCache(r io.Reader) error {
 	if c.cache == nil {
 		c.Error(ErrNoCache)
 		return ErrNoCache
 	}
-	if c.log != nil {
-		c.Warning("clear all cache")
+
+	var count int
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		// 缓存的响应可能很大，不能使用有长度限制的 bufio.Scanner
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var e exportedCache
+			if err := json.Unmarshal(line, &e); err != nil {
+				return fmt.Errorf("invalid cache at line %d: %w", n, err)
+			}
+			if err := c.writeCache(CacheEntry{Key: e.Key, Value: e.Value}); err != nil {
+				return err
+			}
+			count++
+		}
+
+		if err == io.EOF {
+			c.Debug("imported the cache", log.Arg{Key: "count", Value: count})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 	}
-	return c.cache.Clear()
 }
 
-func (c Crawler) ProxyInUse() string {
+func (c *Crawler) ProxyInUse() string {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 
@@ -947,6 +2328,40 @@ func (c *Crawler) ConcurrencyState() bool {
 	return c.goPool != nil
 }
 
+// BytesSent returns the total bytes of the request bodies sent by the crawler
+func (c *Crawler) BytesSent() uint64 {
+	return atomic.LoadUint64(&c.bytesSent)
+}
+
+// BytesReceived returns the total bytes of the response bodies received
+// by the crawler, responses read from the cache are not counted
+func (c *Crawler) BytesReceived() uint64 {
+	return atomic.LoadUint64(&c.bytesReceived)
+}
+
+// Stats is a snapshot of the traffic of the crawler
+type Stats struct {
+	// Number of requests issued
+	Requests uint32
+	// Number of successful responses received from the remote servers
+	Responses uint32
+	// Total bytes of the request bodies sent
+	BytesSent uint64
+	// Total bytes of the response bodies received
+	BytesReceived uint64
+}
+
+// Stats returns the number of requests and responses and the
+// bytes sent and received by the crawler so far
+func (c *Crawler) Stats() Stats {
+	return Stats{
+		Requests:      atomic.LoadUint32(&c.requestCount),
+		Responses:     atomic.LoadUint32(&c.responseCount),
+		BytesSent:     c.BytesSent(),
+		BytesReceived: c.BytesReceived(),
+	}
+}
+
 /************************* 公共注册方法 ****************************/
 
 // BeforeRequest used to process requests, such as
@@ -975,6 +2390,34 @@ func (c *Crawler) ParseHTML(selector string, f HandleHTML) {
 	c.lock.Unlock()
 }
 
+// OnHTMLDocument registers a function to handle the whole parsed document
+// of each html response, which is convenient for page-level logic that
+// doesn't fit a single selector.
+//
+// The functions are called after the `ParseHTML` handlers, and the error
+// returned by a function stops the processing of the response.
+func (c *Crawler) OnHTMLDocument(f HandleHTMLDocument) {
+	c.lock.Lock()
+	c.documentHandler = append(c.documentHandler, f)
+	c.lock.Unlock()
+}
+
+// OnParseError registers a function to handle the responses whose bodies
+// can't be parsed as html by the `ParseHTML` and `OnHTMLDocument` handlers,
+// or as json by the `ParseJSON` handlers, such as to log the url and the body.
+// It is also called with a `SchemaMismatchError` when a json response doesn't
+// match the schema of a `ParseJSONWithSchema` handler.
+//
+// The html and json handlers are not called for the response when a parse
+// error occurs. Only the responses whose "Content-Type" is json are checked
+// for the json handlers. The html parser is lenient, so a malformed html
+// document is still parsed and doesn't cause a parse error.
+func (c *Crawler) OnParseError(f HandleParseError) {
+	c.lock.Lock()
+	c.parseErrorHandler = append(c.parseErrorHandler, f)
+	c.lock.Unlock()
+}
+
 // ParseJSON can parse json to find the data you need,
 // and process the data.
 //
@@ -982,14 +2425,91 @@ func (c *Crawler) ParseHTML(selector string, f HandleHTML) {
 // `application/json` in the content-type of the response header will
 // not be processed.
 //
-// It is recommended to do full processing of the json response in one
-// call to `ParseJSON` instead of multiple calls to `ParseJSON`.
+// Multiple handlers are called in the order they are registered with
+// the same parsed result.
 func (c *Crawler) ParseJSON(strict bool, f HandleJSON) {
 	c.lock.Lock()
 	if c.jsonHandler == nil {
 		c.jsonHandler = make([]*JSONParser, 0, 1)
 	}
-	c.jsonHandler = append(c.jsonHandler, &JSONParser{strict, f})
+	c.jsonHandler = append(c.jsonHandler, &JSONParser{strict: strict, Handle: f})
+	c.lock.Unlock()
+}
+
+// ParseJSONNamed is like `ParseJSON`, but the handler can be replaced by
+// another handler with the same `name` or removed by `RemoveJSONHandler`.
+//
+// A replaced handler keeps its position among the handlers.
+func (c *Crawler) ParseJSONNamed(name string, strict bool, f HandleJSON) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	parser := &JSONParser{name: name, strict: strict, Handle: f}
+	for i, p := range c.jsonHandler {
+		if name != "" && p.name == name {
+			// 正在处理的响应可能还在遍历原来的切片，所以替换副本中的处理器
+			handlers := make([]*JSONParser, len(c.jsonHandler))
+			copy(handlers, c.jsonHandler)
+			handlers[i] = parser
+			c.jsonHandler = handlers
+			return
+		}
+	}
+
+	c.jsonHandler = append(c.jsonHandler, parser)
+}
+
+// RemoveJSONHandler removes the handler registered by `ParseJSONNamed` with
+// `name`, and reports whether the handler exists.
+func (c *Crawler) RemoveJSONHandler(name string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for i, p := range c.jsonHandler {
+		if name != "" && p.name == name {
+			// 不能原地删除，正在处理的响应可能还在遍历原来的切片
+			handlers := make([]*JSONParser, 0, len(c.jsonHandler)-1)
+			handlers = append(handlers, c.jsonHandler[:i]...)
+			c.jsonHandler = append(handlers, c.jsonHandler[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseJSONWithSchema is like `ParseJSON`, but `f` is called only if the
+// json response matches the `schema`, otherwise the `OnParseError` handlers
+// are called with a `SchemaMismatchError`.
+//
+// Only a subset of JSON Schema is supported, see `json.Schema`.
+func (c *Crawler) ParseJSONWithSchema(schema []byte, f HandleJSON) error {
+	s, err := json.CompileSchema(schema)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	if c.jsonHandler == nil {
+		c.jsonHandler = make([]*JSONParser, 0, 1)
+	}
+	c.jsonHandler = append(c.jsonHandler, &JSONParser{schema: s, Handle: f})
+	c.lock.Unlock()
+
+	return nil
+}
+
+// ParseJSONPath registers a function to handle the result of the gjson
+// `path` of the json responses, such as "data.items", which is only called
+// if the path exists, like `ParseHTML` for json.
+//
+// The error returned by the function stops the processing of the response.
+func (c *Crawler) ParseJSONPath(path string, f HandleJSONPath) {
+	c.lock.Lock()
+	if c.jsonHandler == nil {
+		c.jsonHandler = make([]*JSONParser, 0, 1)
+	}
+	c.jsonHandler = append(c.jsonHandler, &JSONParser{path: path, handlePath: f})
 	c.lock.Unlock()
 }
 
@@ -1006,9 +2526,57 @@ func (c *Crawler) AfterResponse(f HandleResponse) {
 	c.lock.Unlock()
 }
 
+// ClearRequestHandlers removes all the functions registered by `BeforeRequest`
+func (c *Crawler) ClearRequestHandlers() {
+	c.lock.Lock()
+	c.requestHandler = nil
+	c.lock.Unlock()
+}
+
+// ClearResponseHandlers removes all the functions registered by `AfterResponse`
+func (c *Crawler) ClearResponseHandlers() {
+	c.lock.Lock()
+	c.responseHandler = nil
+	c.lock.Unlock()
+}
+
+// ClearHTMLHandlers removes all the functions registered by `ParseHTML`
+// and `OnHTMLDocument`
+func (c *Crawler) ClearHTMLHandlers() {
+	c.lock.Lock()
+	c.htmlHandler = nil
+	c.documentHandler = nil
+	c.lock.Unlock()
+}
+
+// ClearJSONHandlers removes all the functions registered by `ParseJSON`,
+// `ParseJSONNamed` and `ParseJSONWithSchema`
+func (c *Crawler) ClearJSONHandlers() {
+	c.lock.Lock()
+	c.jsonHandler = nil
+	c.lock.Unlock()
+}
+
+// ClearParseErrorHandlers removes all the functions registered by `OnParseError`
+func (c *Crawler) ClearParseErrorHandlers() {
+	c.lock.Lock()
+	c.parseErrorHandler = nil
+	c.lock.Unlock()
+}
+
+// ResetHandlers removes all the registered handlers, so that the crawler
+// can be reused with different processing rules
+func (c *Crawler) ResetHandlers() {
+	c.ClearRequestHandlers()
+	c.ClearResponseHandlers()
+	c.ClearHTMLHandlers()
+	c.ClearJSONHandlers()
+	c.ClearParseErrorHandlers()
+}
+
 // ProxyPoolAmount returns the number of proxies in
 // the proxy pool
-func (c Crawler) ProxyPoolAmount() int {
+func (c *Crawler) ProxyPoolAmount() int {
 	return len(c.proxyURLPool)
 }
 
@@ -1016,8 +2584,57 @@ func (c Crawler) ProxyPoolAmount() int {
 func (c *Crawler) Wait() {
 	c.wg.Wait()
 	c.goPool.Close()
+	c.FlushCache()
+
+	stats := c.Stats()
+	c.Info("all tasks are done",
+		log.Arg{Key: "requests", Value: stats.Requests},
+		log.Arg{Key: "responses", Value: stats.Responses},
+		log.Arg{Key: "bytes_sent", Value: stats.BytesSent},
+		log.Arg{Key: "bytes_received", Value: stats.BytesReceived},
+	)
+}
+
+// Stop stops the crawler as soon as possible. The queued requests that
+// haven't started are discarded, the requests that haven't been sent will
+// be skipped, and the in-flight requests of the crawler using the goroutine
+// pool are canceled with `ErrCrawlerStopped`, so `Wait` returns promptly.
+//
+// The canceled requests don't wait for their responses, and their
+// connections are released in the background when the responses arrive or
+// time out. Without the goroutine pool, the in-flight requests end normally.
+// No more requests can be sent after the crawler is stopped.
+func (c *Crawler) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	if c.goPool == nil {
+		c.closeAsyncCache()
+		return
+	}
+
+	discarded := c.goPool.Stop()
+	discarded = append(discarded, c.discardParkedTasks()...)
+	for _, task := range discarded {
+		// ReleaseRequest 会释放爬虫自己申请的上下文
+		if task.req.done != nil {
+			task.req.done(ErrCrawlerStopped)
+		}
+		ReleaseRequest(task.req)
+		c.wg.Done()
+	}
+
+	c.closeAsyncCache()
+
+	c.Info("the crawler is stopped", log.Arg{Key: "discarded_requests", Value: len(discarded)})
 }
 
+// SetProxyInvalidCondition sets the condition for judging whether the proxy
+// is invalid based on the response, such as a captcha page returned with 200.
+//
+// When the condition returns an error, the proxy used by the response will be
+// removed from the proxy pool and the request will be retried with a new proxy.
 func (c *Crawler) SetProxyInvalidCondition(condition ProxyInvalidCondition) {
 	c.proxyInvalidCondition = condition
 }
@@ -1066,10 +2683,13 @@ func (c *Crawler) SetCache(cc Cache, compressed bool, cacheCondition CacheCondit
 	if err != nil {
 		panic(err)
 	}
+	if err = pingCache(cc); err != nil {
+		panic(err)
+	}
 	c.cache = cc
 	if cacheCondition == nil {
 		cacheCondition = func(r *Response) bool {
-			return r.StatusCode/100 == 2
+			return r.IsSuccess()
 		}
 	}
 	c.cacheCondition = cacheCondition
@@ -1097,32 +2717,41 @@ func (c *Crawler) UnsetCache() {
 	}
 }
 
-func (c Crawler) Lock() {
+func (c *Crawler) Lock() {
 	c.lock.Lock()
 }
 
-func (c Crawler) Unlock() {
+func (c *Crawler) Unlock() {
 	c.lock.Unlock()
 }
 
-func (c Crawler) RLock() {
+func (c *Crawler) RLock() {
 	c.lock.RLock()
 }
 
-func (c Crawler) RUnlock() {
+func (c *Crawler) RUnlock() {
 	c.lock.RUnlock()
 }
 
 /************************* 私有注册方法 ****************************/
 
 func (c *Crawler) processRequestHandler(r *Request) {
-	for _, f := range c.requestHandler {
+	// 处理器可能在爬取过程中被清除，所以遍历加锁取得的副本
+	c.lock.RLock()
+	requestHandler := c.requestHandler
+	c.lock.RUnlock()
+
+	for _, f := range requestHandler {
 		f(r)
 	}
 }
 
 func (c *Crawler) processResponseHandler(r *Response) {
-	for _, f := range c.responseHandler {
+	c.lock.RLock()
+	responseHandler := c.responseHandler
+	c.lock.RUnlock()
+
+	for _, f := range responseHandler {
 		if r.invalid {
 			break
 		}
@@ -1130,21 +2759,29 @@ func (c *Crawler) processResponseHandler(r *Response) {
 	}
 }
 
-func (c *Crawler) processJSONHandler(r *Response) {
-	if c.jsonHandler == nil {
-		return
+func (c *Crawler) processJSONHandler(r *Response) error {
+	// 处理器可能在爬取过程中被注册或删除，所以遍历加锁取得的副本
+	c.lock.RLock()
+	jsonHandler := c.jsonHandler
+	c.lock.RUnlock()
+
+	if len(jsonHandler) == 0 {
+		return nil
 	}
 
-	if len(c.jsonHandler) > 1 {
-		if c.log != nil {
-			c.Warning("it is recommended to do full processing of the json response in one call to `ParseJSON` instead of multiple calls to `ParseJSON`")
-		}
+	isJSON := strings.Contains(strings.ToLower(r.ContentType()), "application/json")
+
+	// 非严格模式的处理器也会处理非 json 的响应，所以只检查声明为 json 的响应
+	if isJSON && !json.ValidBytes(r.Body) {
+		c.processParseErrorHandler(r, ErrNotJSONResponse)
+		return nil
 	}
 
-	result := json.ParseBytesToJSON(r.Body)
-	for _, parser := range c.jsonHandler {
+	// 与 Response.JSON 共用解析结果，缓存的响应和新的响应的处理方式完全相同
+	result := r.JSON()
+	for _, parser := range jsonHandler {
 		if parser.strict {
-			if !strings.Contains(strings.ToLower(r.ContentType()), "application/json") {
+			if !isJSON {
 				if c.log != nil {
 					c.Debug(
 						`the "Content-Type" of the response header is not of the "json" type`,
@@ -1154,16 +2791,82 @@ func (c *Crawler) processJSONHandler(r *Response) {
 				continue
 			}
 		}
+		if parser.schema != nil {
+			if err := parser.schema.Validate(result); err != nil {
+				c.processParseErrorHandler(r, &SchemaMismatchError{Err: err})
+				continue
+			}
+		}
+		if parser.handlePath != nil {
+			if sub := result.Get(parser.path); sub.Exists() {
+				if err := parser.handlePath(sub, r); err != nil {
+					return err
+				}
+			}
+			continue
+		}
 		parser.Handle(result, r)
 	}
+
+	return nil
+}
+
+func (c *Crawler) processParseErrorHandler(r *Response, err error) {
+	c.Warning("failed to parse the response",
+		log.Arg{Key: "error", Value: err.Error()},
+		log.Arg{Key: "request_id", Value: atomic.LoadUint32(&r.Request.ID)},
+	)
+
+	c.lock.RLock()
+	parseErrorHandler := c.parseErrorHandler
+	c.lock.RUnlock()
+
+	for _, f := range parseErrorHandler {
+		f(r, err)
+	}
+}
+
+// isAmbiguousContentType reports whether the "Content-Type" may be used by
+// the misconfigured servers for html
+func isAmbiguousContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return contentType == "" || strings.Contains(contentType, "text/plain")
+}
+
+// looksLikeHTML sniffs the beginning of the body for the html tags
+func looksLikeHTML(body []byte) bool {
+	const sniffLen = 512
+
+	body = bytes.TrimLeft(body, "\ufeff \t\r\n")
+	if len(body) > sniffLen {
+		body = body[:sniffLen]
+	}
+	body = bytes.ToLower(body)
+
+	return bytes.HasPrefix(body, []byte("<!doctype html")) || bytes.Contains(body, []byte("<html"))
 }
 
 func (c *Crawler) processHTMLHandler(r *Response) error {
-	if len(c.htmlHandler) == 0 {
+	// 处理器可能在爬取过程中被注册或删除，所以遍历加锁取得的副本
+	c.lock.RLock()
+	htmlHandler, documentHandler := c.htmlHandler, c.documentHandler
+	c.lock.RUnlock()
+
+	if len(htmlHandler) == 0 && len(documentHandler) == 0 && !c.followMetaRefresh {
 		return nil
 	}
 
-	if !strings.Contains(strings.ToLower(r.ContentType()), "html") {
+	var (
+		doc *goquery.Document
+		err error
+	)
+	if strings.Contains(strings.ToLower(r.ContentType()), "html") {
+		doc, err = r.HTML()
+	} else if c.lenientHTML && isAmbiguousContentType(r.ContentType()) && looksLikeHTML(r.Body) {
+		// r.HTML 会检查 Content-Type，所以直接解析后保存到响应中
+		doc, err = html.ParseHTML(r.Body)
+		r.doc = doc
+	} else {
 		if c.log != nil {
 			c.Debug(
 				`the "Content-Type" of the response header is not of the "html" type`,
@@ -1172,16 +2875,15 @@ func (c *Crawler) processHTMLHandler(r *Response) error {
 		}
 		return nil
 	}
-
-	doc, err := html.ParseHTML(r.Body)
 	if err != nil {
 		if c.log != nil {
 			c.log.Error(err)
 		}
+		c.processParseErrorHandler(r, err)
 		return err
 	}
 
-	for _, parser := range c.htmlHandler {
+	for _, parser := range htmlHandler {
 		if r.invalid {
 			break
 		}
@@ -1194,25 +2896,89 @@ func (c *Crawler) processHTMLHandler(r *Response) error {
 			}
 		})
 	}
+
+	for _, f := range documentHandler {
+		if r.invalid {
+			break
+		}
+
+		if err = f(doc, r); err != nil {
+			c.Error(err, log.Arg{Key: "request_id", Value: atomic.LoadUint32(&r.Request.ID)})
+			return err
+		}
+	}
+
+	if c.followMetaRefresh && !r.invalid {
+		return c.followRedirectPage(r, doc)
+	}
+
 	return nil
 }
 
+// maxMetaRefreshCount is the max number of the meta refreshes followed in
+// a chain, which avoids the redirect loops
+const maxMetaRefreshCount = 5
+
+// followRedirectPage issues a chained GET request to the target of the
+// meta refresh tag or the js redirect of the document, with the headers
+// and the context of the request of the response.
+func (c *Crawler) followRedirectPage(r *Response, doc *goquery.Document) error {
+	target := html.RedirectURL(doc)
+	if target == "" {
+		return nil
+	}
+
+	request := r.Request
+	target = request.AbsoluteURL(target)
+	if target == "" || target == request.URL() {
+		return nil
+	}
+
+	if request.metaRefreshCount >= maxMetaRefreshCount {
+		c.Warning(
+			"too many meta refreshes, the redirect is not followed",
+			log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)},
+			log.Arg{Key: "target", Value: target},
+		)
+		return nil
+	}
+
+	if c.log != nil {
+		c.Debug(
+			"follow the redirect of the html page",
+			log.Arg{Key: "request_id", Value: atomic.LoadUint32(&request.ID)},
+			log.Arg{Key: "target", Value: target},
+		)
+	}
+
+	headers := AcquireRequestHeader()
+	request.Headers.CopyTo(headers)
+	// 跳转后的请求总是 GET 请求，不再需要原请求体的类型
+	headers.Del("Content-Type")
+
+	count := request.metaRefreshCount + 1
+	return c.request(MethodGet, target, nil, nil, headers, request.Ctx, true, func(req *Request) {
+		req.metaRefreshCount = count
+	})
+}
+
 // removeInvalidProxy 只有在使用代理池且当前请求使用的代理来自于代理池时，才能真正删除失效代理
 func (c *Crawler) removeInvalidProxy(proxyAddr string) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
 	if c.ProxyPoolAmount() == 0 {
-		return proxy.ProxyErr{
-			Code: proxy.ErrEmptyProxyPoolCode,
-			Msg:  "the current proxy pool is empty",
-		}
+		return proxy.NewProxyError(
+			proxy.ErrEmptyProxyPoolCode,
+			"",
+			errors.New("the current proxy pool is empty"),
+		)
 	}
 
 	if c.ProxyPoolAmount() == 1 && c.complementProxyPool != nil {
 		newProxyPool := c.complementProxyPool()
 		c.proxyURLPool = append(c.proxyURLPool, newProxyPool...)
-		c.log.Info(
+		c.Info(
 			"a new proxy pool has replaced to the old proxy pool",
 			log.Arg{Key: "new_proxy_pool", Value: newProxyPool},
 		)
@@ -1220,8 +2986,9 @@ func (c *Crawler) removeInvalidProxy(proxyAddr string) error {
 
 	targetIndex := -1
 	for i, p := range c.proxyURLPool {
-		addr := strings.Split(p, "//")[1]
-		if addr == proxyAddr {
+		// 代理错误中的是拨号的地址，不含代理的认证信息
+		u, err := url.Parse(p)
+		if err == nil && u.Host == proxyAddr {
 			targetIndex = i
 			break
 		}
@@ -1241,10 +3008,11 @@ func (c *Crawler) removeInvalidProxy(proxyAddr string) error {
 		}
 
 		if len(c.proxyURLPool) == 0 {
-			return proxy.ProxyErr{
-				Code: proxy.ErrEmptyProxyPoolCode,
-				Msg:  "the current proxy pool is empty after removing a invalid proxy",
-			}
+			return proxy.NewProxyError(
+				proxy.ErrEmptyProxyPoolCode,
+				proxyAddr,
+				errors.New("the current proxy pool is empty after removing a invalid proxy"),
+			)
 		}
 	} else {
 		// 并发时可能也会存在找不到失效的代理的情况，这时不能返回 error
@@ -1253,13 +3021,11 @@ func (c *Crawler) removeInvalidProxy(proxyAddr string) error {
 		}
 
 		// 没有在代理池中找到失效代理，这个代理来路不明，一样报错
-		return &proxy.ProxyErr{
-			Code: proxy.ErrUnkownProxyIPCode,
-			Msg:  "proxy address is unkown",
-			Args: map[string]string{
-				"unkown_proxy_addr": proxyAddr,
-			},
-		}
+		return proxy.NewProxyError(
+			proxy.ErrUnkownProxyIPCode,
+			proxyAddr,
+			errors.New("proxy address is unkown"),
+		)
 	}
 
 	return nil
@@ -1289,7 +3055,17 @@ func (c *Crawler) Error(err error, args ...log.Arg) {
 	}
 }
 
+// Fatal logs a `FATAL` message with some `Arg`s, and the logger calls
+// `os.Exit(1)` to exit the application.
+//
+// If the crawler is created with `WithPanicInsteadOfExit`, it logs an
+// `ERROR` message instead and panics with the error, which can be recovered.
 func (c *Crawler) Fatal(err error, args ...log.Arg) {
+	if c.panicInsteadOfExit {
+		c.Error(err, args...)
+		panic(err)
+	}
+
 	if c.log != nil {
 		c.log.Fatal(err, args...)
 	}
@@ -11,22 +11,49 @@ package predator
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/go-predator/log"
+	pctx "github.com/go-predator/predator/context"
 	"github.com/go-predator/predator/html"
 	"github.com/go-predator/predator/proxy"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/tidwall/gjson"
 	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
 )
 
 func TestNewCrawler(t *testing.T) {
@@ -241,6 +268,245 @@ func TestRequest(t *testing.T) {
 
 }
 
+func TestPostForm(t *testing.T) {
+	var (
+		bodies       []string
+		contentTypes []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	Convey("测试重复字段的表单", t, func() {
+		bodies, contentTypes = nil, nil
+
+		c := NewCrawler()
+		err := c.PostForm(ts.URL, url.Values{"tag": {"b", "a"}, "id": {"1"}}, nil)
+		So(err, ShouldBeNil)
+
+		So(bodies, ShouldResemble, []string{"id=1&tag=b&tag=a"})
+		So(contentTypes, ShouldResemble, []string{"application/x-www-form-urlencoded"})
+	})
+
+	Convey("测试有序的表单", t, func() {
+		bodies = nil
+
+		c := NewCrawler()
+		err := c.PostOrderedForm(ts.URL, []FormField{
+			{"z", "1"},
+			{"tag", "a b"},
+			{"a", "&"},
+			{"tag", "c"},
+		}, nil)
+		So(err, ShouldBeNil)
+
+		So(bodies, ShouldResemble, []string{"z=1&tag=a+b&a=%26&tag=c"})
+	})
+
+	Convey("测试重复字段作为缓存字段", t, func() {
+		bodies = nil
+
+		c := NewCrawler(WithCache(new(memoryCache), false, nil, NewRequestBodyParamField("tag")))
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+		})
+
+		for _, values := range []url.Values{
+			{"tag": {"a", "b"}, "t": {"1"}},
+			{"tag": {"a", "b"}, "t": {"2"}},
+			{"tag": {"a"}, "t": {"3"}},
+		} {
+			So(c.PostForm(ts.URL, values, nil), ShouldBeNil)
+		}
+
+		So(fromCache, ShouldResemble, []bool{false, true, false})
+
+		err := c.PostForm(ts.URL, url.Values{"id": {"1"}}, nil)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPostRaw(t *testing.T) {
+	var (
+		bodies       []string
+		contentTypes []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	Convey("测试原始请求体", t, func() {
+		bodies, contentTypes = nil, nil
+
+		c := NewCrawler()
+		So(c.PostRaw(ts.URL, []byte("<a>1</a>"), nil), ShouldBeNil)
+		So(c.PostRawWithContentType(ts.URL, []byte("<a>2</a>"), "application/xml", nil), ShouldBeNil)
+
+		So(bodies, ShouldResemble, []string{"<a>1</a>", "<a>2</a>"})
+		// 不使用 fasthttp 默认的 Content-Type
+		So(contentTypes, ShouldResemble, []string{"", "application/xml"})
+	})
+
+	Convey("测试原始请求体的缓存", t, func() {
+		bodies = nil
+
+		c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+		})
+
+		for _, body := range []string{"a", "b", "a", `{"cache":"a"}`} {
+			So(c.PostRaw(ts.URL, []byte(body), nil), ShouldBeNil)
+		}
+
+		So(fromCache, ShouldResemble, []bool{false, false, true, false})
+		So(bodies, ShouldResemble, []string{"a", "b", `{"cache":"a"}`})
+	})
+}
+
+func TestPostJSONBody(t *testing.T) {
+	var (
+		bodies       []string
+		contentTypes []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	type user struct {
+		ID   int      `json:"id"`
+		Name string   `json:"name"`
+		Tags []string `json:"tags,omitempty"`
+	}
+
+	Convey("测试发送结构体和切片", t, func() {
+		bodies, contentTypes = nil, nil
+
+		c := NewCrawler()
+		So(c.PostJSONBody(ts.URL, user{ID: 1, Name: "a", Tags: []string{"x"}}, nil), ShouldBeNil)
+		So(c.PostJSONBody(ts.URL, []user{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}, nil), ShouldBeNil)
+
+		So(bodies, ShouldResemble, []string{
+			`{"id":1,"name":"a","tags":["x"]}`,
+			`[{"id":1,"name":"a"},{"id":2,"name":"b"}]`,
+		})
+		So(contentTypes, ShouldResemble, []string{"application/json", "application/json"})
+	})
+
+	Convey("测试结构体和切片的缓存字段", t, func() {
+		bodies = nil
+
+		var fromCache []bool
+		c := NewCrawler(WithCache(new(memoryCache), false, nil, NewRequestBodyParamField("0.id")))
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+		})
+
+		So(c.PostJSONBody(ts.URL, []user{{ID: 1, Name: "a"}}, nil), ShouldBeNil)
+		So(c.PostJSONBody(ts.URL, []user{{ID: 1, Name: "b"}}, nil), ShouldBeNil)
+		So(c.PostJSONBody(ts.URL, []user{{ID: 2, Name: "a"}}, nil), ShouldBeNil)
+		So(fromCache, ShouldResemble, []bool{false, true, false})
+
+		c = NewCrawler(WithCache(new(memoryCache), false, nil, NewRequestBodyParamField("name")))
+		So(c.PostJSONBody(ts.URL, user{ID: 1}, nil), ShouldBeNil)
+		So(c.PostJSONBody(ts.URL, struct{}{}, nil), ShouldNotBeNil)
+	})
+}
+
+func TestPostMultipartInMemory(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试上传内存中的文件", t, func() {
+		c := NewCrawler()
+
+		form := NewMultipartForm("-------------------", randomBoundary)
+		form.AppendString("id", "1")
+		form.AppendBytes("text", "a.txt", []byte("hello"))
+		err := form.AppendReader("html", "b.html", strings.NewReader("<html></html>"))
+		So(err, ShouldBeNil)
+
+		c.AfterResponse(func(r *Response) {
+			So(r.StatusCode, ShouldEqual, 200)
+			So(r.String(), ShouldEqual, "1")
+		})
+
+		err = c.PostMultipart(ts.URL+"/post", form, nil)
+		So(err, ShouldBeNil)
+
+		mr := multipart.NewReader(bytes.NewReader(form.buf.Bytes()), form.Boundary())
+		mf, err := mr.ReadForm(1 << 20)
+		So(err, ShouldBeNil)
+
+		So(mf.File["text"][0].Filename, ShouldEqual, "a.txt")
+		So(mf.File["text"][0].Header.Get("Content-Type"), ShouldEqual, "text/plain; charset=utf-8")
+		So(mf.File["html"][0].Filename, ShouldEqual, "b.html")
+		So(mf.File["html"][0].Header.Get("Content-Type"), ShouldEqual, "text/html; charset=utf-8")
+
+		f, _ := mf.File["text"][0].Open()
+		content, _ := io.ReadAll(f)
+		So(string(content), ShouldEqual, "hello")
+
+		So(form.bodyMap["a.txt"], ShouldEqual, fmt.Sprintf("%x", sha1.Sum([]byte("hello"))))
+	})
+
+	Convey("测试指定文件的 Content-Type", t, func() {
+		form := NewMultipartForm("-------------------", randomBoundary)
+		err := form.AppendReaderWithContentType("pdf", "a.bin", strings.NewReader("%PDF-1.4"), "application/pdf")
+		So(err, ShouldBeNil)
+		err = form.AppendReaderWithContentType("png", "b.png", strings.NewReader("hello"), "")
+		So(err, ShouldBeNil)
+
+		mr := multipart.NewReader(bytes.NewReader(form.Bytes()), form.Boundary())
+		mf, err := mr.ReadForm(1 << 20)
+		So(err, ShouldBeNil)
+
+		So(mf.File["pdf"][0].Header.Get("Content-Type"), ShouldEqual, "application/pdf")
+		So(mf.File["png"][0].Header.Get("Content-Type"), ShouldEqual, "image/png")
+	})
+
+	Convey("测试自定义 boundary", t, func() {
+		form := NewMultipartForm("-------------------", randomBoundary)
+
+		err := form.SetBoundary("----WebKitFormBoundary", func() string { return "7MA4YWxkTrZu0gW" })
+		So(err, ShouldBeNil)
+		So(form.Boundary(), ShouldEqual, "----WebKitFormBoundary7MA4YWxkTrZu0gW")
+
+		err = form.SetBoundary("--", func() string { return "a\"b" })
+		So(err, ShouldEqual, ErrInvalidBoundary)
+		err = form.SetBoundary("--", func() string { return "ab " })
+		So(err, ShouldEqual, ErrInvalidBoundary)
+		err = form.SetBoundary("", func() string { return strings.Repeat("a", 71) })
+		So(err, ShouldEqual, ErrInvalidBoundary)
+		So(form.Boundary(), ShouldEqual, "----WebKitFormBoundary7MA4YWxkTrZu0gW")
+
+		form.AppendString("id", "1")
+		err = form.SetBoundary("--", randomBoundary)
+		So(err, ShouldEqual, ErrBoundaryAfterWrite)
+
+		mr := multipart.NewReader(bytes.NewReader(form.Bytes()), "----WebKitFormBoundary7MA4YWxkTrZu0gW")
+		mf, err := mr.ReadForm(1 << 20)
+		So(err, ShouldBeNil)
+		So(mf.Value["id"], ShouldResemble, []string{"1"})
+	})
+}
+
 func TestHTTPProxy(t *testing.T) {
 	ts := server()
 	defer ts.Close()
@@ -378,10 +644,252 @@ func TestRetry(t *testing.T) {
 		c.AfterResponse(func(r *Response) {
 			So(r.Request.NumberOfRetries(), ShouldEqual, 5)
 			So(r.StatusCode, ShouldNotEqual, 200)
+			So(r.RetriesExhausted(), ShouldBeTrue)
 		})
 
 		c.Get(ts.URL + "/check_cookie")
 	})
+
+	Convey("测试重试后成功的请求", t, func() {
+		var calls int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) <= 3 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+		defer ts.Close()
+
+		c := NewCrawler(
+			WithRetry(5, func(r *Response) bool {
+				return r.StatusCode != 200
+			}),
+		)
+
+		var handled bool
+		c.AfterResponse(func(r *Response) {
+			handled = true
+			So(r.Request.NumberOfRetries(), ShouldEqual, 3)
+			So(r.StatusCode, ShouldEqual, 200)
+			So(r.RetriesExhausted(), ShouldBeFalse)
+		})
+
+		So(c.Get(ts.URL), ShouldBeNil)
+		So(handled, ShouldBeTrue)
+	})
+}
+
+func TestMaxRetryDuration(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	Convey("测试重试的总时长", t, func() {
+		atomic.StoreInt32(&hits, 0)
+
+		c := NewCrawler(
+			WithRetry(1000, func(r *Response) bool {
+				return r.StatusCode != 200
+			}),
+			WithMaxRetryDuration(300*time.Millisecond),
+			WithRetryBackoff(10*time.Millisecond, 50*time.Millisecond),
+		)
+
+		var status []int
+		c.AfterResponse(func(r *Response) {
+			status = append(status, r.StatusCode)
+		})
+
+		start := time.Now()
+		So(c.Get(ts.URL), ShouldBeNil)
+		elapsed := time.Since(start)
+
+		So(elapsed, ShouldBeGreaterThanOrEqualTo, 300*time.Millisecond)
+		So(elapsed, ShouldBeLessThan, 2*time.Second)
+		So(atomic.LoadInt32(&hits), ShouldBeBetween, 1, 1000)
+		// 返回最后一次的响应
+		So(status, ShouldResemble, []int{http.StatusServiceUnavailable})
+	})
+
+	Convey("测试超时重试的总时长", t, func() {
+		block := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-block
+		}))
+		defer slow.Close()
+		defer close(block)
+
+		c := NewCrawler(
+			WithRetry(1000, nil),
+			WithMaxRetryDuration(250*time.Millisecond),
+		)
+
+		c.BeforeRequest(func(r *Request) {
+			r.SetTimeout(100 * time.Millisecond)
+		})
+
+		start := time.Now()
+		So(c.Get(slow.URL), ShouldEqual, ErrTimeout)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+}
+
+func TestResponseValidator(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		// 前两次请求返回拦截页面
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.Write([]byte("<html><title>blocked</title></html>"))
+			return
+		}
+		w.Write([]byte("<html><title>ok</title></html>"))
+	}))
+	defer ts.Close()
+
+	validator := func(r *Response) error {
+		if bytes.Contains(r.Body, []byte("blocked")) {
+			return errors.New("blocked by the waf")
+		}
+		return nil
+	}
+
+	Convey("测试验证失败后重试", t, func() {
+		atomic.StoreInt32(&calls, 0)
+
+		c := NewCrawler(
+			WithRetry(3, nil),
+			WithResponseValidator(validator),
+		)
+
+		var titles []string
+		c.ParseHTML("title", func(he *html.HTMLElement, r *Response) {
+			titles = append(titles, he.Text())
+		})
+
+		var retries uint32
+		c.AfterResponse(func(r *Response) {
+			retries = r.Request.NumberOfRetries()
+			So(r.RetriesExhausted(), ShouldBeFalse)
+		})
+
+		So(c.Get(ts.URL), ShouldBeNil)
+		So(atomic.LoadInt32(&calls), ShouldEqual, 3)
+		So(retries, ShouldEqual, 2)
+		So(titles, ShouldResemble, []string{"ok"})
+	})
+
+	Convey("测试验证失败的响应", t, func() {
+		atomic.StoreInt32(&calls, 0)
+
+		c := NewCrawler(
+			WithRetry(1, nil),
+			WithResponseValidator(validator),
+			WithCache(new(memoryCache), false, nil),
+		)
+
+		var titles []string
+		c.ParseHTML("title", func(he *html.HTMLElement, r *Response) {
+			titles = append(titles, he.Text())
+		})
+
+		var (
+			exhausted []bool
+			fromCache []bool
+			errs      []error
+		)
+		c.AfterResponse(func(r *Response) {
+			exhausted = append(exhausted, r.RetriesExhausted())
+			fromCache = append(fromCache, r.FromCache)
+			errs = append(errs, r.ValidationError())
+		})
+
+		// 重试用尽后跳过 html 处理器，且不缓存无效的响应
+		So(c.Get(ts.URL), ShouldBeNil)
+		So(c.Get(ts.URL), ShouldBeNil)
+		So(c.Get(ts.URL), ShouldBeNil)
+
+		So(atomic.LoadInt32(&calls), ShouldEqual, 3)
+		So(exhausted, ShouldResemble, []bool{true, false, false})
+		So(fromCache, ShouldResemble, []bool{false, false, true})
+		So(errs[0], ShouldBeError, "blocked by the waf")
+		So(errs[1:], ShouldResemble, []error{nil, nil})
+		So(titles, ShouldResemble, []string{"ok", "ok"})
+	})
+}
+
+func TestResponseRetry(t *testing.T) {
+	Convey("测试在响应处理函数中发起重试", t, func() {
+		var calls int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			// 前两次请求返回逻辑错误
+			if atomic.AddInt32(&calls, 1) <= 2 {
+				w.Write([]byte(`{"code":1}`))
+				return
+			}
+			w.Write([]byte(`{"code":0}`))
+		}))
+		defer ts.Close()
+
+		Convey("重试次数足够", func() {
+			mc := new(memoryCache)
+			c := NewCrawler(
+				WithRetry(3, func(r *Response) bool { return false }),
+				WithCache(mc, false, nil),
+			)
+
+			var handled int
+			c.AfterResponse(func(r *Response) {
+				handled++
+				if gjson.GetBytes(r.Body, "code").Int() != 0 {
+					r.Retry()
+					return
+				}
+				So(r.Request.NumberOfRetries(), ShouldEqual, 2)
+			})
+
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+			So(handled, ShouldEqual, 3)
+
+			// 只有最后一次成功的响应被缓存
+			var cached []byte
+			mc.m.Range(func(key, value any) bool {
+				cached = value.([]byte)
+				return true
+			})
+			resp := new(Response)
+			So(resp.Unmarshal(cached), ShouldBeNil)
+			So(string(resp.Body), ShouldEqual, `{"code":0}`)
+		})
+
+		Convey("重试次数用尽", func() {
+			atomic.StoreInt32(&calls, 0)
+			c := NewCrawler(WithRetry(1, func(r *Response) bool { return false }))
+
+			var (
+				handled int
+				retries uint32
+			)
+			c.AfterResponse(func(r *Response) {
+				handled++
+				if gjson.GetBytes(r.Body, "code").Int() != 0 {
+					r.Retry()
+				}
+				retries = r.Request.NumberOfRetries()
+			})
+
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+			So(handled, ShouldEqual, 2)
+			So(retries, ShouldEqual, 1)
+		})
+	})
 }
 
 func TestCookies(t *testing.T) {
@@ -564,6 +1072,55 @@ func TestJSONWithInvalidCacheField(t *testing.T) {
 	c.PostJSON("https://httpbin.org/post", body, nil)
 }
 
+func TestInvalidCacheFieldError(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试无效的缓存字段返回错误而不是退出", t, func() {
+		Convey("GET 中不存在的查询参数", func() {
+			c := NewCrawler(WithCache(&memoryCache{}, false, nil, NewQueryParamField("id")))
+
+			err := c.Get(ts.URL + "/?page=1")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "[id]")
+		})
+
+		Convey("GET 中使用请求体缓存字段", func() {
+			c := NewCrawler(WithCache(&memoryCache{}, false, nil, NewRequestBodyParamField("id")))
+
+			err := c.Get(ts.URL)
+			So(err, ShouldEqual, ErrNotAllowedCacheFieldType)
+		})
+
+		Convey("POST 中不存在的请求体字段", func() {
+			c := NewCrawler(WithCache(&memoryCache{}, false, nil, NewRequestBodyParamField("id")))
+
+			err := c.Post(ts.URL+"/post", map[string]string{"name": "tom"}, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "[id]")
+		})
+
+		Convey("PostJSON 中不存在的请求体字段", func() {
+			c := NewCrawler(WithCache(&memoryCache{}, false, nil, NewRequestBodyParamField("user.id")))
+
+			err := c.PostJSON(ts.URL+"/json", map[string]any{"user": map[string]any{"name": "tom"}}, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "user.id")
+		})
+
+		Convey("PostMultipart 中不存在的请求体字段", func() {
+			c := NewCrawler(WithCache(&memoryCache{}, false, nil, NewRequestBodyParamField("id")))
+
+			form := NewMultipartForm("-------------------", randomBoundary)
+			form.AppendString("name", "tom")
+
+			err := c.PostMultipart(ts.URL+"/post", form, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "[id]")
+		})
+	})
+}
+
 func TestParseHTML(t *testing.T) {
 	ts := server()
 	defer ts.Close()
@@ -719,6 +1276,2439 @@ func TestLog(t *testing.T) {
 	})
 }
 
+func TestJSONLogger(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试以 JSON 格式输出日志", t, func() {
+		var buf bytes.Buffer
+		c := NewCrawler(WithJSONLogger(log.INFO, &buf))
+
+		err := c.Get(ts.URL)
+		So(err, ShouldBeNil)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		So(len(lines), ShouldBeGreaterThan, 0)
+
+		entries := make(map[string]map[string]any)
+		for _, line := range lines {
+			var entry map[string]any
+			So(json.Unmarshal([]byte(line), &entry), ShouldBeNil)
+			entries[entry["message"].(string)] = entry
+		}
+
+		So(entries["requesting"]["request_id"], ShouldEqual, 1)
+		So(entries["requesting"]["method"], ShouldEqual, MethodGet)
+		So(entries["requesting"]["url"], ShouldEqual, ts.URL+"/")
+		So(entries["response"]["status_code"], ShouldEqual, 200)
+	})
+}
+
+func TestLogSampling(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试日志采样", t, func() {
+		var buf bytes.Buffer
+		c := NewCrawler(
+			WithLogSampling(5),
+			WithJSONLogger(log.INFO, &buf),
+		)
+
+		for i := 0; i < 10; i++ {
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+		}
+
+		for i := 0; i < 3; i++ {
+			c.Warning("warning")
+		}
+
+		levels := make(map[string]int)
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry map[string]any
+			So(json.Unmarshal([]byte(line), &entry), ShouldBeNil)
+			levels[entry["level"].(string)]++
+		}
+
+		// 1 条并发状态日志，每个请求有 requesting 和 response 两条日志，共 21 条，
+		// 每 5 条输出 1 条
+		So(levels["info"], ShouldEqual, 5)
+		So(levels["warn"], ShouldEqual, 3)
+	})
+}
+
+func TestLogContext(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试为每条日志附加字段", t, func() {
+		var buf bytes.Buffer
+		c := NewCrawler(
+			WithJSONLogger(log.DEBUG, &buf),
+			WithLogContext(map[string]any{
+				"job_id": "job-1",
+				"worker": 3,
+			}),
+		)
+
+		err := c.Get(ts.URL)
+		So(err, ShouldBeNil)
+		c.Error(errors.New("an error"))
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		So(len(lines), ShouldBeGreaterThan, 1)
+
+		for _, line := range lines {
+			var entry map[string]any
+			So(json.Unmarshal([]byte(line), &entry), ShouldBeNil)
+			So(entry["job_id"], ShouldEqual, "job-1")
+			So(entry["worker"], ShouldEqual, 3)
+		}
+	})
+}
+
+func TestPanicInsteadOfExit(t *testing.T) {
+	Convey("测试致命错误时 panic 而不是退出程序", t, func() {
+		var buf bytes.Buffer
+		c := NewCrawler(
+			WithLogger(log.NewLogger(log.DEBUG, &buf)),
+			WithPanicInsteadOfExit(),
+		)
+
+		e := errors.New("fatal error")
+
+		recovered := func(f func()) (r any) {
+			defer func() {
+				r = recover()
+			}()
+			f()
+			return
+		}
+
+		So(recovered(func() { c.Fatal(e) }), ShouldEqual, e)
+		So(recovered(func() { c.FatalOrPanic(e) }), ShouldEqual, e)
+		So(buf.String(), ShouldContainSubstring, `"level":"error"`)
+		So(buf.String(), ShouldNotContainSubstring, `"level":"fatal"`)
+
+		Convey("克隆的爬虫保留此设置", func() {
+			So(recovered(func() { c.Clone().Fatal(e) }), ShouldEqual, e)
+		})
+	})
+}
+
+func TestStatusErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.WriteHeader(status)
+		w.Write([]byte("status " + r.URL.Query().Get("status")))
+	}))
+	defer ts.Close()
+
+	Convey("测试默认不返回状态码错误", t, func() {
+		c := NewCrawler()
+		So(c.Get(ts.URL+"/?status=500"), ShouldBeNil)
+	})
+
+	Convey("测试返回状态码错误", t, func() {
+		c := NewCrawler(WithStatusErrors(nil))
+
+		var handled []int
+		c.AfterResponse(func(r *Response) {
+			handled = append(handled, r.StatusCode)
+		})
+
+		So(c.Get(ts.URL+"/?status=200"), ShouldBeNil)
+
+		err := c.Get(ts.URL + "/?status=500")
+		var he *HTTPError
+		So(errors.As(err, &he), ShouldBeTrue)
+		So(he.StatusCode, ShouldEqual, 500)
+		So(he.URL, ShouldEqual, ts.URL+"/?status=500")
+		So(string(he.Body), ShouldEqual, "status 500")
+		So(err.Error(), ShouldEqual, ts.URL+"/?status=500 responds with status code 500")
+
+		So(c.Post(ts.URL+"/?status=404", map[string]string{"a": "1"}, nil), ShouldHaveSameTypeAs, he)
+
+		// 处理器仍然会被调用
+		So(handled, ShouldResemble, []int{200, 500, 404})
+	})
+
+	Convey("测试自定义的状态码范围", t, func() {
+		c := NewCrawler(
+			WithStatusErrors(func(statusCode int) bool {
+				return statusCode >= 500
+			}),
+			WithConcurrency(2, false),
+		)
+
+		err := c.GetAll([]string{ts.URL + "/?status=404", ts.URL + "/?status=503"})
+		var be *BatchError
+		So(errors.As(err, &be), ShouldBeTrue)
+		So(be.Errors, ShouldHaveLength, 1)
+
+		var he *HTTPError
+		So(errors.As(be.Errors[ts.URL+"/?status=503"], &he), ShouldBeTrue)
+		So(he.StatusCode, ShouldEqual, 503)
+	})
+}
+
+func TestDo(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" {
+			w.WriteHeader(405)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.Write([]byte(r.Header.Get("Depth") + ":"))
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	Convey("测试使用任意请求方法", t, func() {
+		c := NewCrawler()
+
+		c.AfterResponse(func(r *Response) {
+			So(r.StatusCode, ShouldEqual, 200)
+			So(r.ContentType(), ShouldEqual, "application/xml")
+			So(r.String(), ShouldEqual, "1:<propfind/>")
+		})
+
+		err := c.Do("PROPFIND", ts.URL, []byte("<propfind/>"), map[string]string{
+			"Content-Type": "application/xml",
+			"Depth":        "1",
+		}, nil)
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestKeepAlive(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	}))
+	defer ts.Close()
+
+	remoteAddrs := func(c *Crawler) []string {
+		addrs := make([]string, 0, 3)
+		c.AfterResponse(func(r *Response) {
+			addrs = append(addrs, r.String())
+		})
+		for i := 0; i < 3; i++ {
+			c.Get(ts.URL)
+		}
+		return addrs
+	}
+
+	Convey("测试连接复用", t, func() {
+		Convey("默认复用连接", func() {
+			addrs := remoteAddrs(NewCrawler())
+			So(addrs[1], ShouldEqual, addrs[0])
+			So(addrs[2], ShouldEqual, addrs[0])
+		})
+
+		Convey("禁用连接复用", func() {
+			addrs := remoteAddrs(NewCrawler(WithDisableKeepAlive()))
+			So(addrs[1], ShouldNotEqual, addrs[0])
+			So(addrs[2], ShouldNotEqual, addrs[1])
+		})
+	})
+}
+
+// writeClientCert 生成一对自签名的客户端证书和私钥，返回它们的文件路径
+func writeClientCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "predator"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	certFile, keyFile := writeClientCert(t)
+
+	Convey("测试自定义 tls 配置", t, func() {
+		Convey("使用客户端证书", func() {
+			c := NewCrawler(
+				WithClientCert(certFile, keyFile),
+				SkipVerification(),
+			)
+
+			var body string
+			c.AfterResponse(func(r *Response) {
+				body = r.String()
+			})
+
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "predator")
+		})
+
+		Convey("与其他选项的顺序无关", func() {
+			cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+			for _, c := range []*Crawler{
+				NewCrawler(SkipVerification(), WithClientCert(certFile, keyFile), WithTLSConfig(cfg)),
+				NewCrawler(WithTLSConfig(cfg), WithClientCert(certFile, keyFile), SkipVerification()),
+			} {
+				So(c.client.TLSConfig.MinVersion, ShouldEqual, tls.VersionTLS12)
+				So(c.client.TLSConfig.InsecureSkipVerify, ShouldBeTrue)
+				So(c.client.TLSConfig.Certificates, ShouldHaveLength, 1)
+			}
+
+			So(cfg.InsecureSkipVerify, ShouldBeFalse)
+			So(cfg.Certificates, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestDialFunc(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试将域名解析到指定的地址", t, func() {
+		var dialed string
+		c := NewCrawler(WithDialFunc(func(addr string) (net.Conn, error) {
+			dialed = addr
+			if addr == "predator.test:80" {
+				addr = ts.Listener.Addr().String()
+			}
+			return fasthttp.Dial(addr)
+		}))
+
+		var body string
+		c.AfterResponse(func(r *Response) {
+			body = r.String()
+		})
+
+		err := c.Get("http://predator.test/")
+		So(err, ShouldBeNil)
+		So(dialed, ShouldEqual, "predator.test:80")
+		So(body, ShouldEqual, string(serverIndexResponse))
+	})
+}
+
+func TestQueryParams(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RawQuery))
+	}))
+	defer ts.Close()
+
+	Convey("测试在请求前设置查询参数", t, func() {
+		c := NewCrawler()
+
+		c.BeforeRequest(func(r *Request) {
+			r.SetQueryParam("page", "2")
+			r.SetQueryParams(map[string]string{"api_key": "foo"})
+		})
+
+		c.AfterResponse(func(r *Response) {
+			So(r.String(), ShouldEqual, "page=2&api_key=foo")
+		})
+
+		err := c.Get(ts.URL + "/?page=1")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("测试设置的查询参数影响缓存键", t, func() {
+		mc := new(memoryCache)
+		c := NewCrawler(WithCache(mc, false, nil, NewQueryParamField("page")))
+
+		c.BeforeRequest(func(r *Request) {
+			r.SetQueryParam("page", "2")
+		})
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+		})
+
+		err := c.Get(ts.URL + "/?page=1")
+		So(err, ShouldBeNil)
+		err = c.Get(ts.URL + "/?page=3")
+		So(err, ShouldBeNil)
+
+		So(fromCache, ShouldResemble, []bool{false, true})
+	})
+
+}
+
+func TestResponseProto(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试响应的协议", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+		var protos []string
+		c.AfterResponse(func(r *Response) {
+			protos = append(protos, r.Proto())
+		})
+
+		for i := 0; i < 2; i++ {
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+		}
+
+		So(protos, ShouldResemble, []string{"HTTP/1.1", ""})
+	})
+}
+
+func TestResponseRedirected(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试重定向后的 url", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+		c.BeforeRequest(func(r *Request) {
+			r.AllowRedirect(1)
+		})
+
+		type result struct {
+			requestURL, finalURL string
+			redirected           bool
+		}
+		var results []result
+		c.AfterResponse(func(r *Response) {
+			results = append(results, result{r.RequestURL(), r.FinalURL(), r.Redirected()})
+		})
+
+		Convey("未重定向", func() {
+			err := c.Get(ts.URL + "/html")
+			So(err, ShouldBeNil)
+			So(results, ShouldResemble, []result{{ts.URL + "/html", ts.URL + "/html", false}})
+		})
+
+		Convey("重定向及缓存", func() {
+			for i := 0; i < 2; i++ {
+				err := c.Get(ts.URL + "/redirect")
+				So(err, ShouldBeNil)
+			}
+			So(results, ShouldResemble, []result{
+				{ts.URL + "/redirect", ts.URL + "/html", true},
+				{ts.URL + "/redirect", ts.URL + "/redirect", false},
+			})
+		})
+	})
+}
+
+func TestResponseGetJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/text" {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("not json"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"items": [{"id": 1}, {"id": 2}]}}`))
+	}))
+	defer ts.Close()
+
+	Convey("测试获取响应中的 json", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+		var ids [][]int64
+		c.AfterResponse(func(r *Response) {
+			So(r.JSON().IsObject(), ShouldBeTrue)
+			So(r.GetJSON("data.items.#").Int(), ShouldEqual, 2)
+
+			var id []int64
+			for _, item := range r.GetJSON("data.items.#.id").Array() {
+				id = append(id, item.Int())
+			}
+			ids = append(ids, id)
+		})
+
+		// 第二次请求的响应来自缓存
+		for i := 0; i < 2; i++ {
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+		}
+		So(ids, ShouldResemble, [][]int64{{1, 2}, {1, 2}})
+
+		Convey("非 json 响应", func() {
+			c := NewCrawler()
+
+			c.AfterResponse(func(r *Response) {
+				So(r.JSON().Exists(), ShouldBeFalse)
+				So(r.GetJSON("data").Exists(), ShouldBeFalse)
+			})
+
+			err := c.Get(ts.URL + "/text")
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestCachedJSONHandler(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.Write([]byte(`{"code": 0, "data": {"name": "tom"}}`))
+	}))
+	defer ts.Close()
+
+	Convey("测试缓存的 json 响应", t, func() {
+		for _, strict := range []bool{true, false} {
+			c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+			type result struct {
+				name      string
+				fromCache bool
+				same      bool
+			}
+			var results []result
+			c.ParseJSON(strict, func(j gjson.Result, r *Response) {
+				results = append(results, result{
+					name:      j.Get("data.name").String(),
+					fromCache: r.FromCache,
+					same:      j.Raw == r.JSON().Raw,
+				})
+			})
+
+			for i := 0; i < 2; i++ {
+				err := c.Get(ts.URL)
+				So(err, ShouldBeNil)
+			}
+
+			So(results, ShouldResemble, []result{{"tom", false, true}, {"tom", true, true}})
+		}
+	})
+}
+
+func TestMaxBodySize(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试响应体大小限制", t, func() {
+		c := NewCrawler(WithMaxBodySize(1024))
+
+		var handled bool
+		c.AfterResponse(func(r *Response) {
+			handled = true
+		})
+
+		err := c.Get(ts.URL + "/large_binary")
+		So(err, ShouldEqual, ErrBodyTooLarge)
+		So(handled, ShouldBeFalse)
+
+		err = c.Get(ts.URL + "/html")
+		So(err, ShouldBeNil)
+		So(handled, ShouldBeTrue)
+	})
+}
+
+func TestPerHostConcurrency(t *testing.T) {
+	newServer := func(inFlight, maxInFlight *int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(inFlight, 1)
+			defer atomic.AddInt32(inFlight, -1)
+
+			for {
+				m := atomic.LoadInt32(maxInFlight)
+				if n <= m || atomic.CompareAndSwapInt32(maxInFlight, m, n) {
+					break
+				}
+			}
+
+			time.Sleep(20 * time.Millisecond)
+			w.Write([]byte("ok"))
+		}))
+	}
+
+	Convey("测试单个主机的并发数", t, func() {
+		var inFlight1, max1, inFlight2, max2 int32
+		ts1 := newServer(&inFlight1, &max1)
+		defer ts1.Close()
+		ts2 := newServer(&inFlight2, &max2)
+		defer ts2.Close()
+
+		c := NewCrawler(WithConcurrency(10, false), WithPerHostConcurrency(2))
+
+		for i := 0; i < 10; i++ {
+			So(c.Get(ts1.URL), ShouldBeNil)
+			So(c.Get(ts2.URL), ShouldBeNil)
+		}
+		c.Wait()
+
+		So(atomic.LoadInt32(&max1), ShouldEqual, 2)
+		So(atomic.LoadInt32(&max2), ShouldEqual, 2)
+		So(c.Stats().Responses, ShouldEqual, 20)
+
+		Convey("慢主机不占用所有工作协程", func() {
+			var (
+				inFlight, maxInFlight int32
+				fastDone              int32
+			)
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+				if n > atomic.LoadInt32(&maxInFlight) {
+					atomic.StoreInt32(&maxInFlight, n)
+				}
+
+				time.Sleep(200 * time.Millisecond)
+				w.Write([]byte("slow"))
+			}))
+			defer slow.Close()
+			fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("fast"))
+			}))
+			defer fast.Close()
+
+			c := NewCrawler(WithConcurrency(4, false), WithPerHostConcurrency(1))
+
+			start := time.Now()
+			var fastElapsed int64
+			c.AfterResponse(func(r *Response) {
+				if r.String() == "fast" && atomic.AddInt32(&fastDone, 1) == 4 {
+					atomic.StoreInt64(&fastElapsed, int64(time.Since(start)))
+				}
+			})
+
+			// 先加入慢主机的请求，它们不能让快主机的请求一直等待
+			for i := 0; i < 8; i++ {
+				So(c.Get(fmt.Sprintf("%s/?page=%d", slow.URL, i)), ShouldBeNil)
+			}
+			for i := 0; i < 4; i++ {
+				So(c.Get(fmt.Sprintf("%s/?page=%d", fast.URL, i)), ShouldBeNil)
+			}
+			c.Wait()
+
+			So(atomic.LoadInt32(&fastDone), ShouldEqual, 4)
+			So(time.Duration(atomic.LoadInt64(&fastElapsed)), ShouldBeLessThan, 600*time.Millisecond)
+			So(atomic.LoadInt32(&maxInFlight), ShouldEqual, 1)
+			So(c.Stats().Responses, ShouldEqual, 12)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 1600*time.Millisecond)
+		})
+
+		Convey("出错时归还名额", func() {
+			ts := server()
+			defer ts.Close()
+
+			c := NewCrawler(WithPerHostConcurrency(1), WithMaxBodySize(1024))
+
+			for i := 0; i < 3; i++ {
+				err := c.Get(ts.URL + "/large_binary")
+				So(err, ShouldEqual, ErrBodyTooLarge)
+			}
+
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestStop(t *testing.T) {
+	var served int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	Convey("测试停止爬虫", t, func() {
+		c := NewCrawler(WithConcurrency(2, false))
+
+		var stoppedErrs int32
+		go func() {
+			for i := 0; i < 100; i++ {
+				if err := c.Get(ts.URL); err == ErrPoolAlreadyClosed {
+					atomic.AddInt32(&stoppedErrs, 1)
+				}
+			}
+		}()
+
+		time.Sleep(150 * time.Millisecond)
+
+		start := time.Now()
+		c.Stop()
+		c.Wait()
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+
+		// 停止后的请求不会发出
+		time.Sleep(50 * time.Millisecond)
+		So(atomic.LoadInt32(&served), ShouldBeLessThan, 10)
+		So(atomic.LoadInt32(&stoppedErrs), ShouldBeGreaterThan, 0)
+
+		Convey("不使用协程池", func() {
+			c := NewCrawler()
+			c.Stop()
+			So(c.Get(ts.URL), ShouldEqual, ErrCrawlerStopped)
+		})
+
+		Convey("不使用协程池时正在进行的请求正常结束", func() {
+			c := NewCrawler()
+
+			var body string
+			c.AfterResponse(func(r *Response) {
+				body = r.String()
+			})
+
+			time.AfterFunc(30*time.Millisecond, c.Stop)
+			So(c.Get(ts.URL), ShouldBeNil)
+			So(body, ShouldEqual, "ok")
+		})
+	})
+
+	Convey("测试停止爬虫时取消正在进行的请求", t, func() {
+		release := make(chan struct{})
+		var started int32
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&started, 1)
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			w.Write([]byte("ok"))
+		}))
+		defer slow.Close()
+		defer close(release)
+
+		c := NewCrawler(WithConcurrency(2, false))
+
+		var handled int32
+		c.AfterResponse(func(r *Response) {
+			atomic.AddInt32(&handled, 1)
+		})
+
+		for i := 0; i < 2; i++ {
+			So(c.Get(slow.URL), ShouldBeNil)
+		}
+
+		fetched := make(chan error, 1)
+		go func() {
+			_, err := c.Fetch(slow.URL)
+			fetched <- err
+		}()
+
+		for atomic.LoadInt32(&started) < 3 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		start := time.Now()
+		c.Stop()
+		c.Wait()
+		So(<-fetched, ShouldEqual, ErrCrawlerStopped)
+		So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+
+		// 取消的请求不会调用响应处理器
+		So(atomic.LoadInt32(&handled), ShouldEqual, 0)
+	})
+}
+
+func TestChainedContext(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试链式请求的上下文", t, func() {
+		const count = 50
+
+		c := NewCrawler(WithConcurrency(10, false))
+
+		c.BeforeRequest(func(r *Request) {
+			if i := r.uri.QueryArgs().Peek("i"); i != nil {
+				r.Ctx.Put("i", string(i))
+			}
+		})
+
+		var matched int32
+		c.AfterResponse(func(r *Response) {
+			i := string(r.Request.uri.QueryArgs().Peek("i"))
+			if i == "" {
+				for n := 0; n < count; n++ {
+					r.Request.Get(fmt.Sprintf("%s/?i=%d", ts.URL, n))
+				}
+				return
+			}
+
+			if r.Ctx.Get("i") == i && r.Ctx.Get("parent") == "value" {
+				atomic.AddInt32(&matched, 1)
+			}
+		})
+
+		ctx, _ := pctx.AcquireCtx()
+		ctx.Put("parent", "value")
+
+		err := c.GetWithCtx(ts.URL, ctx)
+		So(err, ShouldBeNil)
+		c.Wait()
+
+		So(atomic.LoadInt32(&matched), ShouldEqual, count)
+		So(ctx.Length(), ShouldEqual, 1)
+	})
+}
+
+func TestFollowMetaRefresh(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/stub":
+			w.Write([]byte(`<html><head><meta http-equiv="refresh" content="0; url=/js"></head></html>`))
+		case "/js":
+			w.Write([]byte(`<html><script>window.location.href = "/final";</script></html>`))
+		case "/a", "/b":
+			target := "/b"
+			if r.URL.Path == "/b" {
+				target = "/a"
+			}
+			fmt.Fprintf(w, `<meta http-equiv="refresh" content="0; url=%s">`, target)
+		default:
+			w.Write([]byte(`<p>final</p>`))
+		}
+	}))
+	defer ts.Close()
+
+	Convey("测试跟随 meta refresh", t, func() {
+		c := NewCrawler(WithFollowMetaRefresh(true))
+
+		var paths []string
+		c.AfterResponse(func(r *Response) {
+			paths = append(paths, fmt.Sprintf("%s %s", r.Request.uri.Path(), r.Ctx.Get("key")))
+		})
+
+		ctx, _ := pctx.AcquireCtx()
+		ctx.Put("key", "value")
+		err := c.GetWithCtx(ts.URL+"/stub", ctx)
+		So(err, ShouldBeNil)
+		So(paths, ShouldResemble, []string{"/stub value", "/js value", "/final value"})
+
+		Convey("重定向循环", func() {
+			paths = nil
+			err := c.Get(ts.URL + "/a")
+			So(err, ShouldBeNil)
+			So(paths, ShouldHaveLength, maxMetaRefreshCount+1)
+		})
+
+		Convey("不跟随", func() {
+			c := NewCrawler()
+
+			count := 0
+			c.AfterResponse(func(r *Response) {
+				count++
+			})
+
+			err := c.Get(ts.URL + "/stub")
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestResponseReader(t *testing.T) {
+	Convey("测试响应体的 Reader", t, func() {
+		r := &Response{Body: []byte("a,b\n1,2\n")}
+
+		b, err := io.ReadAll(r.Reader())
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "a,b\n1,2\n")
+
+		rc := r.ReadCloser()
+		b, err = io.ReadAll(rc)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, r.String())
+		So(rc.Close(), ShouldBeNil)
+
+		// 每次都从头读取
+		b, _ = io.ReadAll(r.Reader())
+		So(b, ShouldResemble, r.Body)
+	})
+}
+
+func TestTracing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	Convey("测试请求耗时", t, func() {
+		Convey("开启", func() {
+			c := NewCrawler(WithTracing(), WithPerHostConcurrency(1))
+
+			var timing Timing
+			c.AfterResponse(func(r *Response) {
+				timing = r.Timing()
+				So(r.Time(), ShouldEqual, timing.Total)
+			})
+
+			before := time.Now()
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+			So(timing.Start, ShouldHappenOnOrAfter, before)
+			So(timing.Total, ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+		})
+
+		Convey("关闭", func() {
+			c := NewCrawler()
+
+			var timing Timing
+			c.AfterResponse(func(r *Response) {
+				timing = r.Timing()
+			})
+
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+			So(timing, ShouldResemble, Timing{})
+		})
+	})
+}
+
+func TestHTTPClient(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go fasthttp.Serve(ln, func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("in memory")
+	})
+
+	ts := server()
+	defer ts.Close()
+
+	p, closeProxy := connectProxy(t, "")
+	defer closeProxy()
+
+	Convey("测试自定义 http 客户端", t, func() {
+		var dials int32
+		client := &fasthttp.Client{
+			Dial: func(addr string) (net.Conn, error) {
+				atomic.AddInt32(&dials, 1)
+				return ln.Dial()
+			},
+		}
+
+		get := func(c *Crawler, URL string) string {
+			var body string
+			c.AfterResponse(func(r *Response) {
+				body = r.String()
+			})
+			So(c.Get(URL), ShouldBeNil)
+			return body
+		}
+
+		c1 := NewCrawler(WithHTTPClient(client), WithDisableKeepAlive())
+		So(get(c1, "http://in.memory/"), ShouldEqual, "in memory")
+		So(c1.client, ShouldEqual, client)
+
+		// 使用代理的爬虫不会修改共享的客户端
+		c2 := NewCrawler(WithHTTPClient(client), WithProxy(p))
+		So(get(c2, ts.URL), ShouldEqual, string(serverIndexResponse))
+
+		c3 := NewCrawler(WithHTTPClient(client), WithDisableKeepAlive())
+		So(get(c3, "http://in.memory/"), ShouldEqual, "in memory")
+		So(atomic.LoadInt32(&dials), ShouldEqual, 2)
+	})
+}
+
+func TestConcurrentTimeouts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	Convey("测试并发请求使用不同的超时时间", t, func() {
+		c := NewCrawler(WithConcurrency(10, false))
+
+		c.BeforeRequest(func(r *Request) {
+			if strings.HasSuffix(r.URL(), "short") {
+				r.SetTimeout(10 * time.Millisecond)
+			} else {
+				r.SetTimeout(time.Second)
+			}
+		})
+
+		var responses int32
+		c.AfterResponse(func(r *Response) {
+			atomic.AddInt32(&responses, 1)
+		})
+
+		for i := 0; i < 10; i++ {
+			So(c.Get(fmt.Sprintf("%s/%d/short", ts.URL, i)), ShouldBeNil)
+			So(c.Get(fmt.Sprintf("%s/%d/long", ts.URL, i)), ShouldBeNil)
+		}
+		c.Wait()
+
+		So(atomic.LoadInt32(&responses), ShouldEqual, 10)
+		// 超时重试不会修改爬虫的配置
+		So(c.retryCount, ShouldEqual, 0)
+	})
+}
+
+func TestPaginate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		w.Header().Set("Content-Type", "application/json")
+		if page > 3 {
+			w.Write([]byte(`{"items":[]}`))
+			return
+		}
+		fmt.Fprintf(w, `{"items":[%d,%d]}`, page*2-1, page*2)
+	}))
+	defer ts.Close()
+
+	Convey("测试分页请求", t, func() {
+		c := NewCrawler()
+
+		var (
+			pages []string
+			items []int64
+		)
+		err := c.Paginate(func(prev *Response) (string, bool) {
+			page := 1
+			if prev != nil {
+				if len(prev.GetJSON("items").Array()) == 0 {
+					return "", false
+				}
+				page = prev.Ctx.GetAny("page").(int) + 1
+			}
+			return fmt.Sprintf("%s/?page=%d", ts.URL, page), true
+		}, func(r *Response) {
+			page, _ := strconv.Atoi(string(r.Request.uri.QueryArgs().Peek("page")))
+			r.Ctx.Put("page", page)
+			pages = append(pages, r.Request.URL())
+			for _, item := range r.GetJSON("items").Array() {
+				items = append(items, item.Int())
+			}
+		})
+		So(err, ShouldBeNil)
+		So(pages, ShouldHaveLength, 4)
+		So(items, ShouldResemble, []int64{1, 2, 3, 4, 5, 6})
+
+		Convey("没有第一页", func() {
+			err := c.Paginate(func(prev *Response) (string, bool) {
+				return "", false
+			}, func(r *Response) {
+				t.Fatal("no page should be requested")
+			})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("无法连接的页面", func() {
+			c := NewCrawler(WithLogger(nil))
+
+			var handled int
+			err := c.Paginate(func(prev *Response) (string, bool) {
+				if prev == nil {
+					return ts.URL + "/?page=1", true
+				}
+				return "http://127.0.0.1:1/?page=2", true
+			}, func(r *Response) {
+				handled++
+			})
+			So(err, ShouldNotBeNil)
+			So(handled, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestGetSync(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	Convey("测试在协程池中同步请求", t, func() {
+		c := NewCrawler(WithConcurrency(3, false))
+
+		var (
+			lock    sync.Mutex
+			handled []string
+		)
+		c.AfterResponse(func(r *Response) {
+			lock.Lock()
+			handled = append(handled, r.String())
+			lock.Unlock()
+		})
+
+		// 异步请求立即返回
+		start := time.Now()
+		So(c.Get(ts.URL+"/slow"), ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, 300*time.Millisecond)
+
+		// 同步请求只等待自身完成
+		resp, err := c.GetSync(ts.URL + "/fast")
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, 300*time.Millisecond)
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.String(), ShouldEqual, "/fast")
+		So(resp.Request.URL(), ShouldEqual, ts.URL+"/fast")
+		ReleaseResponse(resp, true)
+
+		lock.Lock()
+		So(handled, ShouldResemble, []string{"/fast"})
+		lock.Unlock()
+
+		c.Wait()
+		So(handled, ShouldResemble, []string{"/fast", "/slow"})
+	})
+
+	Convey("测试不使用协程池时同步请求", t, func() {
+		c := NewCrawler()
+
+		resp, err := c.GetSync(ts.URL + "/fast")
+		So(err, ShouldBeNil)
+		So(resp.String(), ShouldEqual, "/fast")
+		ReleaseResponse(resp, true)
+
+		_, err = c.GetSync("http://[::1")
+		So(err, ShouldNotBeNil)
+
+		c.BeforeRequest(func(r *Request) {
+			r.Abort()
+		})
+		resp, err = c.GetSync(ts.URL + "/fast")
+		So(err, ShouldBeNil)
+		So(resp, ShouldBeNil)
+	})
+}
+
+func TestFetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Write([]byte("hello " + r.Header.Get("User-Agent")))
+	}))
+	defer ts.Close()
+
+	Convey("测试直接返回响应", t, func() {
+		c := NewCrawler(WithUserAgent("fetcher"), WithConcurrency(2, false))
+
+		var handled bool
+		c.BeforeRequest(func(r *Request) {
+			handled = true
+		})
+		c.AfterResponse(func(r *Response) {
+			handled = true
+		})
+
+		resp, err := c.Fetch(ts.URL + "/a")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.String(), ShouldEqual, "hello fetcher")
+		So(string(resp.Headers.Peek("X-Path")), ShouldEqual, "/a")
+		So(resp.Request.URL(), ShouldEqual, ts.URL+"/a")
+
+		resp.Ctx.Put("key", "value")
+		So(resp.Request.Ctx.Get("key"), ShouldEqual, "value")
+		ReleaseResponse(resp, true)
+
+		// 不调用处理器，也不经过协程池
+		So(handled, ShouldBeFalse)
+
+		resp, err = c.Fetch(ts.URL + "/error")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 500)
+		ReleaseResponse(resp, true)
+
+		_, err = c.Fetch("http://[::1")
+		So(err, ShouldNotBeNil)
+
+		// 连接失败时返回错误
+		resp, err = c.Fetch("http://127.0.0.1:1/")
+		So(err, ShouldNotBeNil)
+		So(resp, ShouldBeNil)
+
+		c.Stop()
+		_, err = c.Fetch(ts.URL)
+		So(err, ShouldEqual, ErrCrawlerStopped)
+	})
+
+	Convey("测试状态码错误", t, func() {
+		c := NewCrawler(WithStatusErrors(nil))
+
+		resp, err := c.Fetch(ts.URL + "/error")
+		So(resp, ShouldBeNil)
+
+		var he *HTTPError
+		So(errors.As(err, &he), ShouldBeTrue)
+		So(he.StatusCode, ShouldEqual, 500)
+	})
+}
+
+func TestHead(t *testing.T) {
+	var methods []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", "1024")
+		w.Write(bytes.Repeat([]byte("a"), 1024))
+	}))
+	defer ts.Close()
+
+	Convey("测试 HEAD 请求", t, func() {
+		c := NewCrawler()
+
+		var handled bool
+		c.AfterResponse(func(r *Response) {
+			handled = true
+		})
+
+		resp, err := c.Head(ts.URL)
+		So(err, ShouldBeNil)
+		defer ReleaseResponse(resp, false)
+
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.Body, ShouldBeEmpty)
+		So(resp.ContentType(), ShouldEqual, "text/html; charset=utf-8")
+		So(resp.Headers.ContentLength(), ShouldEqual, 1024)
+		So(resp.Request.Method(), ShouldEqual, MethodHead)
+		So(handled, ShouldBeFalse)
+
+		missing, err := c.Head(ts.URL + "/missing")
+		So(err, ShouldBeNil)
+		So(missing.StatusCode, ShouldEqual, 404)
+		ReleaseResponse(missing, false)
+
+		So(methods, ShouldResemble, []string{MethodHead, MethodHead})
+		// 没有读取响应体
+		So(c.Stats().BytesReceived, ShouldEqual, 0)
+
+		Convey("无法连接的主机", func() {
+			c := NewCrawler(WithLogger(nil))
+
+			resp, err := c.Head("http://127.0.0.1:1/")
+			So(err, ShouldNotBeNil)
+			So(resp, ShouldBeNil)
+		})
+	})
+}
+
+func TestRequestSpawn(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+
+	Convey("测试派生请求", t, func() {
+		c := NewCrawler(WithConcurrency(10, false))
+
+		c.BeforeRequest(func(r *Request) {
+			if r.Ctx.Length() == 0 {
+				r.Headers.Set("Authorization", "token")
+				r.Ctx.Put("parent", true)
+			}
+		})
+
+		var (
+			lock    sync.Mutex
+			results = make(map[string]string)
+			leaked  bool
+		)
+		c.AfterResponse(func(r *Response) {
+			if r.Ctx.GetAny("parent") != nil && r.Ctx.Get("id") == "" {
+				var wg sync.WaitGroup
+				for i := 0; i < 50; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+
+						child := r.Request.Spawn()
+						defer ReleaseRequest(child)
+
+						id := strconv.Itoa(i)
+						child.Ctx.Put("id", id)
+						child.Get(fmt.Sprintf("%s/child?id=%s", ts.URL, id))
+					}(i)
+				}
+				wg.Wait()
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			if r.Ctx.GetAny("parent") != nil {
+				leaked = true
+			}
+			results[r.Ctx.Get("id")] = string(r.Body) + " " + r.Request.uri.QueryArgs().String()
+		})
+
+		So(c.Get(ts.URL), ShouldBeNil)
+		c.Wait()
+
+		So(leaked, ShouldBeFalse)
+		So(results, ShouldHaveLength, 50)
+		for i := 0; i < 50; i++ {
+			id := strconv.Itoa(i)
+			So(results[id], ShouldEqual, "token id="+id)
+		}
+	})
+
+	Convey("测试释放派生请求的上下文", t, func() {
+		c := NewCrawler()
+
+		var ctx pctx.Context
+		c.AfterResponse(func(r *Response) {
+			if ctx != nil {
+				return
+			}
+
+			child := r.Request.Spawn()
+			ctx = child.Ctx
+			ctx.Put("id", "1")
+			So(child.Get(ts.URL+"/child"), ShouldBeNil)
+
+			// 释放派生的请求时一并释放它的上下文
+			ReleaseRequest(child)
+		})
+
+		So(c.Get(ts.URL), ShouldBeNil)
+		So(ctx, ShouldNotBeNil)
+		So(ctx.Length(), ShouldEqual, 0)
+	})
+}
+
+func TestResponseClone(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>hello</p>"))
+	}))
+	defer ts.Close()
+
+	Convey("测试复制响应", t, func() {
+		c := NewCrawler()
+
+		var (
+			wg    sync.WaitGroup
+			texts = make([]string, 10)
+		)
+		c.BeforeRequest(func(r *Request) {
+			r.Ctx.Put("shared", "yes")
+		})
+		c.AfterResponse(func(r *Response) {
+			for i := 0; i < 10; i++ {
+				cloned, err := r.Clone(i%2 == 0)
+				So(err, ShouldBeNil)
+
+				wg.Add(1)
+				go func(i int, cloned *Response) {
+					defer wg.Done()
+
+					doc, err := cloned.HTML()
+					if err == nil {
+						texts[i] = doc.Find("p").Text()
+					}
+					cloned.Ctx.Put(fmt.Sprintf("worker-%d", i), i)
+					_ = cloned.Request.URL()
+					ReleaseResponse(cloned, i%2 == 0)
+				}(i, cloned)
+			}
+
+			// 原响应在复制后被修改
+			r.Body[0] = '!'
+			r.Headers.Set("Content-Type", "text/plain")
+
+			// 共享的上下文会在原响应释放时释放，需在处理器返回前等待
+			wg.Wait()
+		})
+
+		So(c.Get(ts.URL), ShouldBeNil)
+
+		for _, text := range texts {
+			So(text, ShouldEqual, "hello")
+		}
+	})
+
+	Convey("测试复制的上下文", t, func() {
+		r := AcquireResponse()
+		r.Ctx, _ = pctx.AcquireCtx()
+		r.Ctx.Put("a", 1)
+
+		shared, err := r.Clone(false)
+		So(err, ShouldBeNil)
+		cloned, err := r.Clone(true)
+		So(err, ShouldBeNil)
+
+		shared.Ctx.Put("b", 2)
+		cloned.Ctx.Put("c", 3)
+
+		So(r.Ctx.GetAny("b"), ShouldEqual, 2)
+		So(r.Ctx.GetAny("c"), ShouldBeNil)
+		So(cloned.Ctx.GetAny("a"), ShouldEqual, 1)
+		So(cloned.Ctx.GetAny("b"), ShouldBeNil)
+
+		ReleaseResponse(cloned, true)
+		ReleaseResponse(shared, false)
+		ReleaseResponse(r, true)
+	})
+}
+
+func TestTransport(t *testing.T) {
+	Convey("测试自定义 Transport", t, func() {
+		var urls []string
+		c := NewCrawler(
+			WithPanicInsteadOfExit(),
+			WithTransport(func(req *fasthttp.Request, resp *fasthttp.Response) error {
+				urls = append(urls, req.URI().String())
+				if string(req.URI().Path()) == "/error" {
+					return errors.New("stub error")
+				}
+
+				resp.SetStatusCode(fasthttp.StatusOK)
+				resp.Header.SetContentType("text/html")
+				resp.SetBodyString("<html><body><p>stub</p></body></html>")
+				return nil
+			}),
+		)
+
+		var text string
+		c.ParseHTML("p", func(he *html.HTMLElement, r *Response) {
+			text = he.Text()
+		})
+
+		err := c.Get("http://stub.test/page")
+		So(err, ShouldBeNil)
+		So(text, ShouldEqual, "stub")
+		So(urls, ShouldResemble, []string{"http://stub.test/page"})
+
+		Convey("Transport 返回错误", func() {
+			So(func() { c.Get("http://stub.test/error") }, ShouldPanic)
+		})
+	})
+}
+
+func TestContextOp(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试爬虫申请的上下文类型", t, func() {
+		for op, typ := range map[pctx.CtxOp]string{
+			pctx.ReadOp:  "*context.rcontext",
+			pctx.WriteOp: "*context.wcontext",
+		} {
+			c := NewCrawler(WithContextOp(op))
+
+			var ctxType string
+			c.AfterResponse(func(r *Response) {
+				ctxType = fmt.Sprintf("%T", r.Ctx)
+			})
+
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+			So(ctxType, ShouldEqual, typ)
+		}
+
+		So(NewCrawler().ctxOp, ShouldEqual, pctx.WriteOp)
+	})
+}
+
+func TestContextRelease(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试上下文的释放", t, func() {
+		Convey("爬虫申请的上下文", func() {
+			c := NewCrawler()
+
+			var ctx pctx.Context
+			c.AfterResponse(func(r *Response) {
+				ctx = r.Ctx
+				ctx.Put("key", "value")
+			})
+
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+			So(ctx, ShouldNotBeNil)
+			So(ctx.Length(), ShouldEqual, 0)
+		})
+
+		Convey("用户传入的上下文", func() {
+			c := NewCrawler(WithConcurrency(10, false))
+
+			c.AfterResponse(func(r *Response) {
+				r.Ctx.Put("status", r.StatusCode)
+			})
+
+			ctxs := make([]pctx.Context, 20)
+			for i := range ctxs {
+				ctx, err := pctx.AcquireCtx()
+				So(err, ShouldBeNil)
+				ctx.Put("id", i)
+				ctxs[i] = ctx
+
+				err = c.GetWithCtx(ts.URL, ctx)
+				So(err, ShouldBeNil)
+			}
+			c.Wait()
+
+			for i, ctx := range ctxs {
+				So(ctx.GetAny("id"), ShouldEqual, i)
+				So(ctx.GetAny("status"), ShouldEqual, StatusOK)
+			}
+		})
+	})
+}
+
+func TestJSONStream(t *testing.T) {
+	const total = 100000
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"items":[`))
+		for i := 0; i < total; i++ {
+			if i > 0 {
+				w.Write([]byte(","))
+			}
+			fmt.Fprintf(w, `{"id":%d,"name":"item-%d"}`, i, i)
+		}
+		w.Write([]byte(`]}}`))
+	}))
+	defer ts.Close()
+
+	Convey("测试逐个遍历大型 json 数组", t, func() {
+		c := NewCrawler()
+
+		c.AfterResponse(func(r *Response) {
+			var count, sum int64
+			err := r.JSONStream("data.items", func(item gjson.Result) bool {
+				count++
+				sum += item.Get("id").Int()
+				return true
+			})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, total)
+			So(sum, ShouldEqual, int64(total)*(total-1)/2)
+
+			Convey("提前结束遍历", func() {
+				var names []string
+				err := r.JSONStream("data.items", func(item gjson.Result) bool {
+					names = append(names, item.Get("name").String())
+					return len(names) < 2
+				})
+				So(err, ShouldBeNil)
+				So(names, ShouldResemble, []string{"item-0", "item-1"})
+			})
+
+			Convey("路径的值不是数组", func() {
+				err := r.JSONStream("data", func(item gjson.Result) bool { return true })
+				So(err, ShouldEqual, ErrNotJSONArray)
+			})
+		})
+
+		err := c.Get(ts.URL)
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestOnParseError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.Write([]byte(r.URL.Query().Get("body")))
+	}))
+	defer ts.Close()
+
+	target := func(contentType, body string) string {
+		return fmt.Sprintf("%s/?type=%s&body=%s", ts.URL, url.QueryEscape(contentType), url.QueryEscape(body))
+	}
+
+	Convey("测试解析错误处理器", t, func() {
+		c := NewCrawler(WithConcurrency(5, false))
+
+		var (
+			lock   sync.Mutex
+			errs   = make(map[string]error)
+			parsed []string
+		)
+		c.OnParseError(func(r *Response, err error) {
+			lock.Lock()
+			errs[r.String()] = err
+			lock.Unlock()
+		})
+		c.ParseJSON(false, func(j gjson.Result, r *Response) {
+			lock.Lock()
+			parsed = append(parsed, r.String())
+			lock.Unlock()
+		})
+		c.ParseHTML("p", func(he *html.HTMLElement, r *Response) {
+			lock.Lock()
+			parsed = append(parsed, he.Text())
+			lock.Unlock()
+		})
+
+		So(c.Get(target("application/json", `{"a":`)), ShouldBeNil)
+		So(c.Get(target("application/json", `{"a":1}`)), ShouldBeNil)
+		So(c.Get(target("text/html", `<html><body><p>unclosed<div></body>`)), ShouldBeNil)
+		c.Wait()
+
+		So(errs, ShouldHaveLength, 1)
+		So(errs[`{"a":`], ShouldEqual, ErrNotJSONResponse)
+		So(parsed, ShouldContain, `{"a":1}`)
+		// html 解析器是宽松的，格式错误的 html 仍然可以解析
+		So(parsed, ShouldContain, "unclosed")
+	})
+
+	Convey("测试严格模式的 json 处理器", t, func() {
+		c := NewCrawler()
+
+		var called bool
+		c.OnParseError(func(r *Response, err error) {
+			called = true
+		})
+		c.ParseJSON(true, func(j gjson.Result, r *Response) {})
+
+		So(c.Get(target("text/plain", `{"a":`)), ShouldBeNil)
+		So(called, ShouldBeFalse)
+	})
+}
+
+func TestLenientHTMLDetection(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contentType := r.URL.Query().Get("type"); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		} else {
+			// 阻止 net/http 自动检测 Content-Type
+			w.Header()["Content-Type"] = nil
+		}
+		w.Write([]byte(r.URL.Query().Get("body")))
+	}))
+	defer ts.Close()
+
+	target := func(contentType, body string) string {
+		return fmt.Sprintf("%s/?type=%s&body=%s", ts.URL, url.QueryEscape(contentType), url.QueryEscape(body))
+	}
+
+	const page = "\n<!DOCTYPE html><html><body><p>hello</p></body></html>"
+
+	get := func(c *Crawler, URL string) []string {
+		var texts []string
+		c.ParseHTML("p", func(he *html.HTMLElement, r *Response) {
+			texts = append(texts, he.Text())
+		})
+		So(c.Get(URL), ShouldBeNil)
+		return texts
+	}
+
+	Convey("测试宽松的 html 检测", t, func() {
+		Convey("默认只处理 html 类型的响应", func() {
+			So(get(NewCrawler(), target("text/plain", page)), ShouldBeEmpty)
+			So(get(NewCrawler(), target("text/html", page)), ShouldResemble, []string{"hello"})
+		})
+
+		Convey("text/plain 类型的 html", func() {
+			So(get(NewCrawler(WithLenientHTMLDetection()), target("text/plain; charset=utf-8", page)), ShouldResemble, []string{"hello"})
+		})
+
+		Convey("没有 Content-Type 的 html", func() {
+			So(get(NewCrawler(WithLenientHTMLDetection()), target("", "<html><p>hi</p></html>")), ShouldResemble, []string{"hi"})
+		})
+
+		Convey("不是 html 的文本", func() {
+			So(get(NewCrawler(WithLenientHTMLDetection()), target("text/plain", "<p>not a page</p>")), ShouldBeEmpty)
+		})
+
+		Convey("其他类型的响应不检测", func() {
+			So(get(NewCrawler(WithLenientHTMLDetection()), target("application/octet-stream", page)), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestParseJSONWithSchema(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(r.URL.Query().Get("body")))
+	}))
+	defer ts.Close()
+
+	Convey("测试使用 json schema 校验响应", t, func() {
+		c := NewCrawler()
+
+		var handled []string
+		err := c.ParseJSONWithSchema([]byte(`{"type": "object", "required": ["code"]}`), func(j gjson.Result, r *Response) {
+			handled = append(handled, j.Raw)
+		})
+		So(err, ShouldBeNil)
+
+		var parseErrs []error
+		c.OnParseError(func(r *Response, err error) {
+			parseErrs = append(parseErrs, err)
+		})
+
+		for _, body := range []string{`{"code":0}`, `{"msg":"error"}`} {
+			err = c.Get(ts.URL + "/?body=" + url.QueryEscape(body))
+			So(err, ShouldBeNil)
+		}
+
+		So(handled, ShouldResemble, []string{`{"code":0}`})
+
+		So(parseErrs, ShouldHaveLength, 1)
+		So(errors.Is(parseErrs[0], ErrSchemaMismatch), ShouldBeTrue)
+		var schemaErr *SchemaMismatchError
+		So(errors.As(parseErrs[0], &schemaErr), ShouldBeTrue)
+		So(schemaErr.Err, ShouldNotBeNil)
+
+		err = c.ParseJSONWithSchema([]byte(`{"type": "map"}`), nil)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestParseJSONPath(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(r.URL.Query().Get("body")))
+	}))
+	defer ts.Close()
+
+	Convey("测试处理 json 的指定路径", t, func() {
+		c := NewCrawler()
+
+		var (
+			items []string
+			codes []int64
+		)
+		c.ParseJSONPath("data.items", func(j gjson.Result, r *Response) error {
+			items = append(items, j.Raw)
+			return nil
+		})
+		c.ParseJSONPath("code", func(j gjson.Result, r *Response) error {
+			codes = append(codes, j.Int())
+			return nil
+		})
+
+		for _, body := range []string{
+			`{"code":0,"data":{"items":[1,2]}}`,
+			`{"code":1,"msg":"error"}`,
+			`{"data":{"items":null}}`,
+		} {
+			So(c.Get(ts.URL+"/?body="+url.QueryEscape(body)), ShouldBeNil)
+		}
+
+		// 值为 null 的路径也是存在的
+		So(items, ShouldResemble, []string{"[1,2]", "null"})
+		So(codes, ShouldResemble, []int64{0, 1})
+	})
+
+	Convey("测试处理函数返回错误", t, func() {
+		c := NewCrawler()
+
+		e := errors.New("invalid code")
+		c.ParseJSONPath("code", func(j gjson.Result, r *Response) error {
+			if j.Int() != 0 {
+				return e
+			}
+			return nil
+		})
+
+		var called int
+		c.ParseJSON(false, func(j gjson.Result, r *Response) {
+			called++
+		})
+
+		So(c.Get(ts.URL+"/?body="+url.QueryEscape(`{"code":0}`)), ShouldBeNil)
+		So(c.Get(ts.URL+"/?body="+url.QueryEscape(`{"code":1}`)), ShouldEqual, e)
+		So(called, ShouldEqual, 1)
+	})
+}
+
+func TestNamedJSONHandler(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"code":0}`))
+	}))
+	defer ts.Close()
+
+	Convey("测试具名的 json 处理函数", t, func() {
+		c := NewCrawler()
+
+		var called []string
+		handler := func(name string) HandleJSON {
+			return func(j gjson.Result, r *Response) {
+				called = append(called, name)
+			}
+		}
+
+		c.ParseJSON(false, handler("anonymous"))
+		c.ParseJSONNamed("validate", true, handler("validate"))
+		c.ParseJSONNamed("extract", true, handler("extract"))
+
+		c.Get(ts.URL)
+		So(called, ShouldResemble, []string{"anonymous", "validate", "extract"})
+
+		Convey("替换", func() {
+			called = called[:0]
+			c.ParseJSONNamed("validate", true, handler("validate2"))
+
+			c.Get(ts.URL)
+			So(called, ShouldResemble, []string{"anonymous", "validate2", "extract"})
+		})
+
+		Convey("删除", func() {
+			called = called[:0]
+			So(c.RemoveJSONHandler("validate"), ShouldBeTrue)
+			So(c.RemoveJSONHandler("validate"), ShouldBeFalse)
+			So(c.RemoveJSONHandler(""), ShouldBeFalse)
+
+			c.Get(ts.URL)
+			So(called, ShouldResemble, []string{"anonymous", "extract"})
+		})
+	})
+
+	Convey("测试在处理响应时删除处理函数", t, func() {
+		c := NewCrawler()
+
+		var called []string
+		handler := func(name string) HandleJSON {
+			return func(j gjson.Result, r *Response) {
+				called = append(called, name)
+			}
+		}
+
+		c.ParseJSON(false, func(j gjson.Result, r *Response) {
+			called = append(called, "anonymous")
+			c.RemoveJSONHandler("validate")
+		})
+		c.ParseJSONNamed("validate", true, handler("validate"))
+		c.ParseJSONNamed("extract", true, handler("extract"))
+
+		// 删除只影响之后的响应，正在处理的响应的每个处理函数都只执行一次
+		c.Get(ts.URL)
+		So(called, ShouldResemble, []string{"anonymous", "validate", "extract"})
+
+		called = called[:0]
+		c.Get(ts.URL)
+		So(called, ShouldResemble, []string{"anonymous", "extract"})
+	})
+}
+
+func TestClearHandlers(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试清除已注册的处理函数", t, func() {
+		c := NewCrawler()
+
+		var called []string
+		register := func() {
+			c.BeforeRequest(func(r *Request) { called = append(called, "request") })
+			c.AfterResponse(func(r *Response) { called = append(called, "response") })
+			c.ParseHTML("h1", func(he *html.HTMLElement, r *Response) { called = append(called, "html") })
+			c.ParseJSON(false, func(j gjson.Result, r *Response) { called = append(called, "json") })
+		}
+
+		register()
+		c.Get(ts.URL + "/html")
+		So(called, ShouldResemble, []string{"request", "response", "html", "json"})
+
+		Convey("分别清除", func() {
+			c.ClearRequestHandlers()
+			c.ClearJSONHandlers()
+
+			called = called[:0]
+			c.Get(ts.URL + "/html")
+			So(called, ShouldResemble, []string{"response", "html"})
+
+			c.ClearResponseHandlers()
+			c.ClearHTMLHandlers()
+
+			called = called[:0]
+			c.Get(ts.URL + "/html")
+			So(called, ShouldBeEmpty)
+		})
+
+		Convey("全部清除后重新注册", func() {
+			c.ResetHandlers()
+			register()
+
+			called = called[:0]
+			c.Get(ts.URL + "/html")
+			So(called, ShouldResemble, []string{"request", "response", "html", "json"})
+		})
+	})
+
+	Convey("测试在爬取时清除和注册处理函数", t, func() {
+		c := NewCrawler(WithConcurrency(10, false))
+
+		var handled int32
+		register := func() {
+			c.BeforeRequest(func(r *Request) { atomic.AddInt32(&handled, 1) })
+			c.AfterResponse(func(r *Response) { atomic.AddInt32(&handled, 1) })
+			c.ParseHTML("h1", func(he *html.HTMLElement, r *Response) { atomic.AddInt32(&handled, 1) })
+			c.OnParseError(func(r *Response, err error) { atomic.AddInt32(&handled, 1) })
+		}
+		register()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 50; i++ {
+				c.ClearRequestHandlers()
+				c.ClearResponseHandlers()
+				c.ClearHTMLHandlers()
+				c.ClearParseErrorHandlers()
+				register()
+			}
+		}()
+
+		for i := 0; i < 50; i++ {
+			So(c.Get(ts.URL+"/html"), ShouldBeNil)
+		}
+		c.Wait()
+		<-done
+
+		So(atomic.LoadInt32(&handled), ShouldBeGreaterThan, 0)
+	})
+}
+
+func TestOnHTMLDocument(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试处理整个 html 文档", t, func() {
+		c := NewCrawler()
+
+		var (
+			title  string
+			called int
+		)
+		c.OnHTMLDocument(func(doc *goquery.Document, r *Response) error {
+			called++
+			title = doc.Find("title").Text()
+			return nil
+		})
+
+		err := c.Get(ts.URL + "/html")
+		So(err, ShouldBeNil)
+		So(called, ShouldEqual, 1)
+		So(title, ShouldEqual, "Test Page")
+
+		Convey("无效的响应不处理", func() {
+			c.AfterResponse(func(r *Response) {
+				r.Invalidate()
+			})
+
+			err := c.Get(ts.URL + "/html")
+			So(err, ShouldBeNil)
+			So(called, ShouldEqual, 1)
+		})
+
+		Convey("返回错误", func() {
+			errStop := errors.New("stop")
+			c.OnHTMLDocument(func(doc *goquery.Document, r *Response) error {
+				return errStop
+			})
+
+			err := c.Get(ts.URL + "/html")
+			So(err, ShouldEqual, errStop)
+		})
+	})
+}
+
+func TestResponseHTML(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试复用解析后的 html 文档", t, func() {
+		c := NewCrawler()
+
+		var first, second *goquery.Document
+		c.AfterResponse(func(r *Response) {
+			doc, err := r.HTML()
+			So(err, ShouldBeNil)
+			So(doc.Find("p.description").Length(), ShouldEqual, 3)
+			first = doc
+		})
+		c.OnHTMLDocument(func(doc *goquery.Document, r *Response) error {
+			second = doc
+			return nil
+		})
+
+		err := c.Get(ts.URL + "/html")
+		So(err, ShouldBeNil)
+		So(second, ShouldNotBeNil)
+		So(second, ShouldEqual, first)
+	})
+
+	Convey("测试非 html 响应", t, func() {
+		c := NewCrawler()
+
+		c.AfterResponse(func(r *Response) {
+			doc, err := r.HTML()
+			So(doc, ShouldBeNil)
+			So(err, ShouldEqual, ErrNotHTMLResponse)
+		})
+
+		err := c.Get(ts.URL)
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	body := []byte(strings.Repeat("hello ", 100))
+
+	var acceptEncodings []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncodings = append(acceptEncodings, r.Header.Get("Accept-Encoding"))
+
+		encoding := r.URL.Query().Get("encoding")
+		if encoding == "" {
+			encoding = strings.Split(r.Header.Get("Accept-Encoding"), ",")[0]
+		}
+
+		switch encoding {
+		case "":
+			w.Write(body)
+			return
+		case "gzip":
+			w.Header().Set("Content-Encoding", encoding)
+			w.Write(fasthttp.AppendGzipBytes(nil, body))
+		case "deflate":
+			w.Header().Set("Content-Encoding", encoding)
+			w.Write(fasthttp.AppendDeflateBytes(nil, body))
+		case "br":
+			w.Header().Set("Content-Encoding", encoding)
+			w.Write(fasthttp.AppendBrotliBytes(nil, body))
+		default:
+			w.Header().Set("Content-Encoding", encoding)
+			w.Write(body)
+		}
+	}))
+	defer ts.Close()
+
+	Convey("测试不设置 Accept-Encoding", t, func() {
+		acceptEncodings = nil
+
+		c := NewCrawler()
+
+		var bodies [][]byte
+		c.AfterResponse(func(r *Response) {
+			bodies = append(bodies, append([]byte(nil), r.Body...))
+		})
+
+		So(c.Get(ts.URL), ShouldBeNil)
+
+		// 自行设置的 Accept-Encoding 不会解压响应体
+		c.BeforeRequest(func(r *Request) {
+			r.SetHeaders(map[string]string{"Accept-Encoding": "gzip"})
+		})
+		So(c.Get(ts.URL), ShouldBeNil)
+
+		So(acceptEncodings, ShouldResemble, []string{"", "gzip"})
+		So(bodies[0], ShouldResemble, body)
+		So(bodies[1], ShouldNotResemble, body)
+
+		r := &Response{Body: bodies[1]}
+		gunzipped, err := r.BodyGunzip()
+		So(err, ShouldBeNil)
+		So(gunzipped, ShouldResemble, body)
+	})
+
+	Convey("测试设置 Accept-Encoding", t, func() {
+		acceptEncodings = nil
+
+		c := NewCrawler(WithAcceptEncoding())
+
+		var (
+			bodies    [][]byte
+			encodings []string
+		)
+		c.AfterResponse(func(r *Response) {
+			bodies = append(bodies, append([]byte(nil), r.Body...))
+			encodings = append(encodings, string(r.Headers.Peek("Content-Encoding")))
+		})
+
+		for _, encoding := range []string{"", "gzip", "deflate", "br"} {
+			So(c.Get(ts.URL+"?encoding="+encoding), ShouldBeNil)
+		}
+		So(acceptEncodings[0], ShouldEqual, "gzip, deflate, br")
+
+		for i := range bodies {
+			So(bodies[i], ShouldResemble, body)
+			So(encodings[i], ShouldBeEmpty)
+		}
+
+		Convey("请求中覆盖 Accept-Encoding", func() {
+			acceptEncodings = nil
+			c.BeforeRequest(func(r *Request) {
+				r.SetHeaders(map[string]string{"Accept-Encoding": "br"})
+			})
+
+			So(c.Get(ts.URL), ShouldBeNil)
+			So(acceptEncodings, ShouldResemble, []string{"br"})
+			So(bodies[len(bodies)-1], ShouldResemble, body)
+		})
+
+		Convey("不支持的编码", func() {
+			err := c.Get(ts.URL + "?encoding=zstd")
+			So(errors.Is(err, ErrUnsupportedEncoding), ShouldBeTrue)
+		})
+	})
+
+	Convey("测试指定的编码", t, func() {
+		acceptEncodings = nil
+
+		c := NewCrawler(WithAcceptEncoding("deflate"))
+		So(c.Get(ts.URL), ShouldBeNil)
+		So(acceptEncodings, ShouldResemble, []string{"deflate"})
+
+		So(func() { WithAcceptEncoding("gzip", "zstd") }, ShouldPanic)
+	})
+}
+
+func TestCompressBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			body, _ = gzip.NewReader(r.Body)
+		case "deflate":
+			// http 中的 deflate 实际上是 zlib 格式
+			body, _ = zlib.NewReader(r.Body)
+		}
+		b, _ := io.ReadAll(body)
+		w.Write([]byte(r.Header.Get("Content-Encoding") + ":"))
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	Convey("测试压缩请求体", t, func() {
+		for _, algo := range []string{"gzip", "deflate"} {
+			c := NewCrawler()
+
+			c.BeforeRequest(func(r *Request) {
+				So(r.CompressBody(algo), ShouldBeNil)
+			})
+
+			var body string
+			c.AfterResponse(func(r *Response) {
+				body = r.String()
+			})
+
+			err := c.Post(ts.URL, map[string]string{"name": strings.Repeat("Tom", 100)}, nil)
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, algo+":name="+strings.Repeat("Tom", 100))
+			So(c.BytesSent(), ShouldBeLessThan, len("name=")+300)
+		}
+	})
+
+	Convey("测试压缩不影响缓存", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+		})
+
+		err := c.Post(ts.URL, map[string]string{"name": "Tom"}, nil)
+		So(err, ShouldBeNil)
+
+		c.BeforeRequest(func(r *Request) {
+			r.CompressBody("gzip")
+		})
+		err = c.Post(ts.URL, map[string]string{"name": "Tom"}, nil)
+		So(err, ShouldBeNil)
+
+		So(fromCache, ShouldResemble, []bool{false, true})
+	})
+
+	Convey("测试不支持的压缩算法", t, func() {
+		r := &Request{}
+		So(r.CompressBody("br"), ShouldEqual, ErrUnsupportedCompression)
+	})
+}
+
+func TestRequestSigner(t *testing.T) {
+	secret := []byte("secret")
+	sign := func(method, path, timestamp string, body []byte) string {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n"))
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		// 服务端使用收到的原始请求体验证签名
+		body, _ := io.ReadAll(r.Body)
+		signature := sign(r.Method, r.URL.RequestURI(), r.Header.Get("X-Timestamp"), body)
+		if !hmac.Equal([]byte(signature), []byte(r.Header.Get("X-Signature"))) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	signer := func(r *Request) error {
+		timestamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+		r.Headers.Set("X-Timestamp", timestamp)
+		r.Headers.Set("X-Signature", sign(string(r.Headers.Method()), string(r.Headers.RequestURI()), timestamp, r.Body))
+		return nil
+	}
+
+	Convey("测试签名请求", t, func() {
+		c := NewCrawler(WithRequestSigner(signer))
+
+		var statusCodes []int
+		c.AfterResponse(func(r *Response) {
+			statusCodes = append(statusCodes, r.StatusCode)
+		})
+
+		So(c.Get(ts.URL+"/api?id=1"), ShouldBeNil)
+		So(c.Post(ts.URL+"/api", map[string]string{"name": "Tom"}, nil), ShouldBeNil)
+
+		c.BeforeRequest(func(r *Request) {
+			r.CompressBody("gzip")
+		})
+		So(c.Post(ts.URL+"/api", map[string]string{"name": "Tom"}, nil), ShouldBeNil)
+
+		So(statusCodes, ShouldResemble, []int{200, 200, 200})
+	})
+
+	Convey("测试未签名的请求", t, func() {
+		c := NewCrawler()
+
+		var statusCode int
+		c.AfterResponse(func(r *Response) {
+			statusCode = r.StatusCode
+		})
+
+		So(c.Get(ts.URL+"/api"), ShouldBeNil)
+		So(statusCode, ShouldEqual, 401)
+	})
+
+	Convey("测试签名失败", t, func() {
+		atomic.StoreInt32(&requests, 0)
+
+		errSign := errors.New("no key")
+		c := NewCrawler(WithRequestSigner(func(r *Request) error {
+			return errSign
+		}))
+
+		So(c.Get(ts.URL+"/api"), ShouldEqual, errSign)
+		So(atomic.LoadInt32(&requests), ShouldEqual, 0)
+	})
+}
+
+func TestSetBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	Convey("测试在请求处理函数中修改请求体", t, func() {
+		c := NewCrawler()
+
+		c.BeforeRequest(func(r *Request) {
+			r.SetBody(append(r.Body, "&sign=abc"...))
+		})
+
+		var body string
+		c.AfterResponse(func(r *Response) {
+			body = r.String()
+		})
+
+		So(c.Post(ts.URL, map[string]string{"id": "1"}, nil), ShouldBeNil)
+		So(body, ShouldEqual, "id=1&sign=abc")
+	})
+
+	Convey("测试修改请求体后重新生成缓存键", t, func() {
+		for _, fields := range [][]CacheField{nil, {NewRequestBodyParamField("id")}} {
+			c := NewCrawler(WithCache(new(memoryCache), false, nil, fields...))
+
+			c.BeforeRequest(func(r *Request) {
+				if string(r.Body) == "id=1" {
+					r.SetBody([]byte("id=2"))
+				}
+			})
+
+			var (
+				bodies    []string
+				fromCache []bool
+			)
+			c.AfterResponse(func(r *Response) {
+				bodies = append(bodies, r.String())
+				fromCache = append(fromCache, r.FromCache)
+			})
+
+			So(c.Post(ts.URL, map[string]string{"id": "1"}, nil), ShouldBeNil)
+			So(c.Post(ts.URL, map[string]string{"id": "2"}, nil), ShouldBeNil)
+			So(c.Post(ts.URL, map[string]string{"id": "3"}, nil), ShouldBeNil)
+
+			So(bodies, ShouldResemble, []string{"id=2", "id=2", "id=3"})
+			So(fromCache, ShouldResemble, []bool{false, true, false})
+		}
+	})
+
+	Convey("测试修改 json 请求体后重新生成缓存键", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, nil, NewRequestBodyParamField("id")))
+
+		c.BeforeRequest(func(r *Request) {
+			r.SetBody(bytes.Replace(r.Body, []byte(`"id":1`), []byte(`"id":2`), 1))
+		})
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+		})
+
+		So(c.PostJSON(ts.URL, map[string]any{"id": 1}, nil), ShouldBeNil)
+		So(c.PostJSON(ts.URL, map[string]any{"id": 2}, nil), ShouldBeNil)
+		So(fromCache, ShouldResemble, []bool{false, true})
+	})
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Accept-Language") + "," + r.Header.Get("DNT")))
+	}))
+	defer ts.Close()
+
+	Convey("测试默认请求头", t, func() {
+		c := NewCrawler(WithHeaders(map[string]string{
+			"Accept-Language": "zh-CN",
+			"DNT":             "1",
+		}))
+
+		var body string
+		c.AfterResponse(func(r *Response) {
+			body = r.String()
+		})
+
+		err := c.Get(ts.URL)
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "zh-CN,1")
+
+		Convey("不覆盖单个请求的请求头", func() {
+			err := c.Do(MethodGet, ts.URL, nil, map[string]string{"DNT": "0"}, nil)
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "zh-CN,0")
+		})
+
+		Convey("可以在请求前修改", func() {
+			c.BeforeRequest(func(r *Request) {
+				r.SetHeaders(map[string]string{"Accept-Language": "en-US"})
+			})
+
+			err := c.Get(ts.URL)
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "en-US,1")
+		})
+	})
+}
+
+func TestCacheQueryNormalization(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试查询参数规范化后生成缓存键", t, func() {
+		c := NewCrawler(
+			WithCache(new(memoryCache), false, nil),
+			WithCacheIgnoreParams("_t", "utm_source"),
+		)
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+		})
+
+		for _, query := range []string{
+			"?a=1&b=2&c=3&c=4",
+			"?b=2&a=1&c=3&c=4",
+			"?c=3&a=1&b=2&c=4&_t=1670000000",
+			"?utm_source=x&a=1&b=2&c=3&c=4&c=3",
+			// 同名参数的顺序不同，不是同一个请求
+			"?a=1&b=2&c=4&c=3",
+		} {
+			err := c.Get(ts.URL + "/" + query)
+			So(err, ShouldBeNil)
+		}
+
+		So(fromCache, ShouldResemble, []bool{false, true, true, true, false})
+	})
+}
+
+func TestSaveJSON(t *testing.T) {
+	Convey("测试保存格式化的 json", t, func() {
+		dir := t.TempDir()
+
+		r := &Response{Body: []byte(`{"b":1,"a":[1,2]}`)}
+		fileName := filepath.Join(dir, "a.json")
+		So(r.SaveJSON(fileName), ShouldBeNil)
+
+		b, err := os.ReadFile(fileName)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "{\n  \"b\": 1,\n  \"a\": [\n    1,\n    2\n  ]\n}\n")
+
+		r = &Response{Body: []byte("<html></html>")}
+		fileName = filepath.Join(dir, "b.json")
+		So(errors.Is(r.SaveJSON(fileName), ErrNotJSONResponse), ShouldBeTrue)
+
+		_, err = os.Stat(fileName)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
+
+func TestStats(t *testing.T) {
+	ts := server()
+	defer ts.Close()
+
+	Convey("测试统计收发的字节数", t, func() {
+		c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+		err := c.Post(ts.URL+"/login", map[string]string{"name": "Tom"}, nil)
+		So(err, ShouldBeNil)
+		So(c.BytesSent(), ShouldEqual, len("name=Tom"))
+		So(c.BytesReceived(), ShouldEqual, len("Tom"))
+
+		err = c.Get(ts.URL + "/html")
+		So(err, ShouldBeNil)
+		received := c.BytesReceived()
+		So(received, ShouldBeGreaterThan, len("Tom"))
+
+		Convey("缓存的响应不计入", func() {
+			err = c.Get(ts.URL + "/html")
+			So(err, ShouldBeNil)
+
+			stats := c.Stats()
+			So(stats.Requests, ShouldEqual, 3)
+			So(stats.Responses, ShouldEqual, 2)
+			So(stats.BytesSent, ShouldEqual, len("name=Tom"))
+			So(stats.BytesReceived, ShouldEqual, received)
+		})
+	})
+}
+
 func TestRedirect(t *testing.T) {
 	ts := server()
 	defer ts.Close()
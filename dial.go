@@ -0,0 +1,174 @@
+package predator
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// headerTimeoutConn limits the time waiting for the first byte of the
+// response after the request is written, see `WithResponseHeaderTimeout`
+type headerTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+	// 握手完成后才开始限制，避免影响 tls 握手
+	armed bool
+	// 请求已写入，正在等待响应
+	waiting bool
+	// fasthttp 设置的读取截止时间
+	deadline time.Time
+}
+
+func (c *headerTimeoutConn) Write(b []byte) (int, error) {
+	if c.armed {
+		c.waiting = true
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *headerTimeoutConn) Read(b []byte) (int, error) {
+	if !c.waiting {
+		return c.Conn.Read(b)
+	}
+	c.waiting = false
+
+	deadline := time.Now().Add(c.timeout)
+	if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+		return c.Conn.Read(b)
+	}
+
+	if err := c.Conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(b)
+	// 收到响应后恢复原来的截止时间，读取响应体不受限制
+	if e := c.Conn.SetReadDeadline(c.deadline); err == nil {
+		err = e
+	}
+	return n, err
+}
+
+func (c *headerTimeoutConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *headerTimeoutConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// tlsHandshake performs the TLS handshake on `conn` within `timeout`, there
+// is no limit if `timeout` is 0
+func tlsHandshake(conn net.Conn, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	tlsConn := tls.Client(conn, config)
+
+	if timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		if x, ok := err.(interface{ Timeout() bool }); ok && x.Timeout() {
+			return nil, fasthttp.ErrTLSHandshakeTimeout
+		}
+		return nil, err
+	}
+
+	if timeout > 0 {
+		if err := conn.SetDeadline(time.Time{}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return tlsConn, nil
+}
+
+// newTLSConfig returns the TLS config used to connect to `addr`, the same as
+// the one used by fasthttp
+func newTLSConfig(config *tls.Config, addr string) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+
+	if config.ServerName == "" {
+		serverName := addr
+		if strings.Contains(addr, ":") {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				host = "*"
+			}
+			serverName = host
+		}
+
+		if serverName == "*" {
+			config.InsecureSkipVerify = true
+		} else {
+			config.ServerName = serverName
+		}
+	}
+
+	return config
+}
+
+// wrapDial wraps the dial function of `hc` to limit the TLS handshake and
+// the wait for the response headers.
+//
+// fasthttp performs the TLS handshake within the write timeout of the
+// client, so the handshake is performed here if it should be limited
+// separately.
+func (c *Crawler) wrapDial(hc *fasthttp.HostClient) fasthttp.DialFunc {
+	dial := hc.Dial
+	if dial == nil {
+		if hc.DialDualStack {
+			dial = fasthttp.DialDualStack
+		} else {
+			dial = fasthttp.Dial
+		}
+	}
+
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		// 自定义的拨号函数可能已经完成了 tls 握手，包装后 fasthttp 会
+		// 认为连接不是 tls 连接而再次握手，所以不再处理
+		if _, ok := conn.(interface{ Handshake() error }); ok {
+			return conn, nil
+		}
+
+		var hconn *headerTimeoutConn
+		if c.headerTimeout > 0 {
+			hconn = &headerTimeoutConn{Conn: conn, timeout: c.headerTimeout}
+			conn = hconn
+		}
+
+		if hc.IsTLS {
+			timeout := c.tlsTimeout
+			if timeout <= 0 {
+				timeout = hc.WriteTimeout
+			}
+			conn, err = tlsHandshake(conn, newTLSConfig(hc.TLSConfig, addr), timeout)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if hconn != nil {
+			hconn.armed = true
+		}
+
+		return conn, nil
+	}
+}
@@ -0,0 +1,75 @@
+package predator
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// slowAcceptListener 创建一个从不接受连接的监听器，并占满其连接队列，
+// 之后的连接请求会一直等待
+func slowAcceptListener(t *testing.T) (string, func()) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err != nil {
+			break
+		}
+		conns = append(conns, conn)
+	}
+
+	return addr, func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		syscall.Close(fd)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	addr, closeListener := slowAcceptListener(t)
+	defer closeListener()
+
+	Convey("测试连接超时", t, func() {
+		c := NewCrawler(WithDialTimeout(100 * time.Millisecond))
+
+		start := time.Now()
+		resp, err := c.Fetch("http://" + addr)
+		So(resp, ShouldBeNil)
+		So(err, ShouldEqual, ErrTimeout)
+		So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+	})
+
+	Convey("测试通过代理连接超时", t, func() {
+		c := NewCrawler(
+			WithDialTimeout(100*time.Millisecond),
+			WithProxy("http://"+addr),
+		)
+
+		start := time.Now()
+		_, err := c.dialProxy("example.com:80")
+		So(err, ShouldNotBeNil)
+		So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+	})
+}
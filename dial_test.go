@@ -0,0 +1,100 @@
+package predator
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	// 只接受连接，从不响应 tls 握手
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	Convey("测试 tls 握手超时", t, func() {
+		c := NewCrawler(WithTLSHandshakeTimeout(100 * time.Millisecond))
+
+		start := time.Now()
+		resp, err := c.Fetch("https://" + ln.Addr().String())
+		So(resp, ShouldBeNil)
+		So(err, ShouldEqual, ErrTimeout)
+		So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+	})
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow-header":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				return
+			}
+			w.Write([]byte("too late"))
+		case "/slow-body":
+			w.Header().Set("Content-Length", "8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("slow"))
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("body"))
+		}
+	}))
+	defer ts.Close()
+
+	Convey("测试等待响应头超时", t, func() {
+		c := NewCrawler(WithResponseHeaderTimeout(100 * time.Millisecond))
+
+		start := time.Now()
+		resp, err := c.Fetch(ts.URL + "/slow-header")
+		So(resp, ShouldBeNil)
+		So(err, ShouldEqual, ErrTimeout)
+		So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+
+		Convey("读取响应体不受限制", func() {
+			resp, err := c.Fetch(ts.URL + "/slow-body")
+			So(err, ShouldBeNil)
+			So(resp.String(), ShouldEqual, "slowbody")
+			ReleaseResponse(resp, true)
+		})
+	})
+
+	Convey("测试 https 的等待响应头超时", t, func() {
+		ts := httptest.NewTLSServer(ts.Config.Handler)
+		defer ts.Close()
+
+		c := NewCrawler(
+			SkipVerification(),
+			WithTLSHandshakeTimeout(time.Second),
+			WithResponseHeaderTimeout(100*time.Millisecond),
+		)
+
+		resp, err := c.Fetch(ts.URL + "/slow-header")
+		So(resp, ShouldBeNil)
+		So(err, ShouldEqual, ErrTimeout)
+
+		resp, err = c.Fetch(ts.URL + "/slow-body")
+		So(err, ShouldBeNil)
+		So(resp.String(), ShouldEqual, "slowbody")
+		ReleaseResponse(resp, true)
+	})
+}
@@ -0,0 +1,84 @@
+package predator
+
+import (
+	"context"
+	"net"
+
+	"github.com/valyala/fasthttp"
+)
+
+// limitedResolver limits the number of the concurrent lookups of the
+// underlying resolver, see `WithMaxDNSConcurrency`
+type limitedResolver struct {
+	resolver fasthttp.Resolver
+	sem      chan struct{}
+}
+
+func newLimitedResolver(resolver fasthttp.Resolver, n int) *limitedResolver {
+	return &limitedResolver{
+		resolver: resolver,
+		sem:      make(chan struct{}, n),
+	}
+}
+
+func (r *limitedResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	select {
+	case r.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-r.sem }()
+
+	return r.resolver.LookupIPAddr(ctx, host)
+}
+
+// setDialer replaces the default dial function of fasthttp with a dialer
+// using the custom resolver, the limit of the concurrent lookups and the
+// timeouts of the connections
+func (c *Crawler) setDialer() {
+	// 自定义的拨号函数优先
+	if c.client.Dial == nil && (c.resolver != nil || c.maxDNSConcurrency > 0 || c.dialTimeout > 0) {
+		c.client.Dial = c.tcpDial()
+	}
+
+	if c.tlsTimeout <= 0 && c.headerTimeout <= 0 {
+		return
+	}
+
+	// 每个主机的客户端都要包装其拨号函数，代理客户端也是如此
+	configure := c.client.ConfigureClient
+	c.client.ConfigureClient = func(hc *fasthttp.HostClient) error {
+		hc.Dial = c.wrapDial(hc)
+		if configure != nil {
+			return configure(hc)
+		}
+		return nil
+	}
+}
+
+func (c *Crawler) tcpDial() fasthttp.DialFunc {
+	var resolver fasthttp.Resolver = net.DefaultResolver
+	if c.resolver != nil {
+		resolver = c.resolver
+	}
+	if c.maxDNSConcurrency > 0 {
+		resolver = newLimitedResolver(resolver, c.maxDNSConcurrency)
+	}
+
+	dialer := &fasthttp.TCPDialer{Resolver: resolver}
+	if c.dialTimeout <= 0 {
+		if c.client.DialDualStack {
+			return dialer.DialDualStack
+		}
+		return dialer.Dial
+	}
+
+	if c.client.DialDualStack {
+		return func(addr string) (net.Conn, error) {
+			return dialer.DialDualStackTimeout(addr, c.dialTimeout)
+		}
+	}
+	return func(addr string) (net.Conn, error) {
+		return dialer.DialTimeout(addr, c.dialTimeout)
+	}
+}
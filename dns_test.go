@@ -0,0 +1,96 @@
+package predator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingResolver 将所有主机名解析为本机地址，并记录最大的并发解析数
+type countingResolver struct {
+	lookups, current, max int32
+}
+
+func (r *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	atomic.AddInt32(&r.lookups, 1)
+	n := atomic.AddInt32(&r.current, 1)
+	defer atomic.AddInt32(&r.current, -1)
+
+	for {
+		max := atomic.LoadInt32(&r.max)
+		if n <= max || atomic.CompareAndSwapInt32(&r.max, max, n) {
+			break
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	return []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil
+}
+
+func TestMaxDNSConcurrency(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	Convey("测试限制 DNS 的并发解析数", t, func() {
+		resolver := new(countingResolver)
+		c := NewCrawler(
+			WithConcurrency(20, false),
+			WithResolver(resolver),
+			WithMaxDNSConcurrency(3),
+		)
+
+		var handled int32
+		c.AfterResponse(func(r *Response) {
+			atomic.AddInt32(&handled, 1)
+		})
+
+		// 不同的主机名不会命中 fasthttp 的 DNS 缓存
+		for i := 0; i < 20; i++ {
+			So(c.Get(fmt.Sprintf("http://host%d.test:%s", i, u.Port())), ShouldBeNil)
+		}
+		c.Wait()
+
+		So(atomic.LoadInt32(&handled), ShouldEqual, 20)
+		So(atomic.LoadInt32(&resolver.lookups), ShouldEqual, 20)
+		So(atomic.LoadInt32(&resolver.max), ShouldBeBetweenOrEqual, 1, 3)
+	})
+
+	Convey("测试不限制 DNS 的并发解析数", t, func() {
+		resolver := new(countingResolver)
+		c := NewCrawler(
+			WithConcurrency(20, false),
+			WithResolver(resolver),
+		)
+
+		for i := 0; i < 20; i++ {
+			So(c.Get(fmt.Sprintf("http://host%d.test:%s", i, u.Port())), ShouldBeNil)
+		}
+		c.Wait()
+
+		So(atomic.LoadInt32(&resolver.max), ShouldBeGreaterThan, 3)
+	})
+
+	Convey("测试等待解析时取消", t, func() {
+		resolver := newLimitedResolver(new(countingResolver), 1)
+		resolver.sem <- struct{}{}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := resolver.LookupIPAddr(ctx, "example.com")
+		So(err, ShouldEqual, context.Canceled)
+	})
+}
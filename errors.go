@@ -8,7 +8,10 @@
 
 package predator
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrRequestFailed            = errors.New("request failed")
@@ -16,5 +19,60 @@ var (
 	ErrInvalidCacheTypeCode     = errors.New("invalid cache type code")
 	ErrNotAllowedCacheFieldType = errors.New("only query parameters are allowed as cached fields in `GET` requests")
 	ErrNoCache                  = errors.New("no cache configured")
+	ErrCacheRead                = errors.New("failed to read the cache")
+	ErrCacheNotIterable         = errors.New("the cache doesn't implement `Iterable`")
 	ErrInvalidResponseStatus    = errors.New("if the http status code is `302`, there must be a valid `Location` field in the response header")
+	ErrInvalidBoundary          = errors.New("the boundary must be 1 to 70 characters allowed by RFC 2046")
+	ErrBoundaryAfterWrite       = errors.New("the boundary must be set before any field is appended")
+	ErrUnsupportedCompression   = errors.New("only gzip and deflate are supported to compress the request body")
+	ErrBodyTooLarge             = errors.New("the body of the response exceeds the max body size")
+	ErrUnsupportedEncoding      = errors.New("only gzip, deflate and br are supported to decode the response body")
+	ErrCrawlerStopped           = errors.New("the crawler has been stopped")
+	ErrSchemaMismatch           = errors.New("the json response doesn't match the schema")
 )
+
+// SchemaMismatchError is passed to the `OnParseError` handlers when a json
+// response doesn't match the schema of a `ParseJSONWithSchema` handler.
+//
+// It matches `ErrSchemaMismatch` with `errors.Is`.
+type SchemaMismatchError struct {
+	// The validation error of the schema
+	Err error
+}
+
+func (e *SchemaMismatchError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrSchemaMismatch, e.Err)
+}
+
+func (e *SchemaMismatchError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SchemaMismatchError) Is(target error) bool {
+	return target == ErrSchemaMismatch
+}
+
+// BatchError is returned by `Crawler.GetAll` and `Crawler.PostAll` when some
+// of the requests fail.
+type BatchError struct {
+	// The errors of the failed requests, keyed by their urls
+	Errors map[string]error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d of the requests failed", len(e.Errors))
+}
+
+// HTTPError is returned by the requests whose responses have the status codes
+// meeting the condition set by `WithStatusErrors`.
+type HTTPError struct {
+	StatusCode int
+	// The url of the request
+	URL string
+	// A copy of the response body
+	Body []byte
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s responds with status code %d", e.URL, e.StatusCode)
+}
@@ -7,6 +7,7 @@ require (
 	github.com/go-predator/log v0.0.0-20220523074050-01ad78a75b3f
 	github.com/go-predator/tools v0.0.0-20220524022058-ce749e9bf77b
 	github.com/json-iterator/go v1.1.12
+	github.com/rs/zerolog v1.26.1
 	github.com/smartystreets/goconvey v1.7.2
 	github.com/tidwall/gjson v1.14.3
 	github.com/valyala/bytebufferpool v1.0.0
@@ -22,7 +23,6 @@ require (
 	github.com/klauspost/compress v1.15.4 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180228061459-e0a39a4cb421 // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
-	github.com/rs/zerolog v1.26.1 // indirect
 	github.com/smartystreets/assertions v1.2.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
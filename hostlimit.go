@@ -0,0 +1,169 @@
+package predator
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hostLimit limits the number of in-flight requests to a host, see
+// `WithPerHostConcurrency`
+type hostLimit struct {
+	lock sync.Mutex
+	// 通知阻塞等待名额的请求
+	cond    *sync.Cond
+	running int
+	// 等待名额的任务，它们不占用工作协程
+	waiting []parkedTask
+}
+
+type parkedTask struct {
+	task  *Task
+	since time.Time
+}
+
+// hostSlot is a slot of a host taken by a request, which is released only
+// once no matter how many times `release` is called
+type hostSlot struct {
+	crawler  *Crawler
+	limit    *hostLimit
+	wait     time.Duration
+	released int32
+}
+
+func (c *Crawler) hostLimitOf(host string) *hostLimit {
+	if v, ok := c.hostSlots.Load(host); ok {
+		return v.(*hostLimit)
+	}
+
+	hl := new(hostLimit)
+	hl.cond = sync.NewCond(&hl.lock)
+	v, _ := c.hostSlots.LoadOrStore(host, hl)
+	return v.(*hostLimit)
+}
+
+// reserveHostSlot takes a free slot of the host of the task without
+// blocking. If there is no free slot, the task is parked and nil is
+// returned, and the task will be resumed by the worker releasing a slot
+// of the host, see `runReadyTasks`.
+//
+// ErrCrawlerStopped is returned if the crawler has been stopped.
+func (c *Crawler) reserveHostSlot(task *Task) (*hostSlot, error) {
+	hl := c.hostLimitOf(string(task.req.uri.Host()))
+
+	hl.lock.Lock()
+	defer hl.lock.Unlock()
+
+	// 停止爬虫时会在锁内清除等待的任务，所以在锁内检查，避免停止后再等待
+	if c.Context.Err() != nil {
+		return nil, ErrCrawlerStopped
+	}
+
+	if hl.running < c.perHostConcurrency {
+		hl.running++
+		return &hostSlot{crawler: c, limit: hl}, nil
+	}
+
+	hl.waiting = append(hl.waiting, parkedTask{task: task, since: time.Now()})
+	return nil, nil
+}
+
+// acquireHostSlot waits for a free slot of the host, which is used by the
+// requests sent outside the workers of the goroutine pool
+func (c *Crawler) acquireHostSlot(host string) *hostSlot {
+	hl := c.hostLimitOf(host)
+
+	start := time.Now()
+	hl.lock.Lock()
+	for hl.running >= c.perHostConcurrency {
+		hl.cond.Wait()
+	}
+	hl.running++
+	hl.lock.Unlock()
+
+	return &hostSlot{crawler: c, limit: hl, wait: time.Since(start)}
+}
+
+// release releases the slot. If there is a parked task of the host, the
+// slot is handed over to it and the task becomes ready. The ready tasks are
+// run by the current worker after its task if `inWorker` is true, otherwise
+// by a new goroutine, so that they are not stuck when no worker is running.
+func (s *hostSlot) release(inWorker bool) {
+	if !atomic.CompareAndSwapInt32(&s.released, 0, 1) {
+		return
+	}
+
+	hl := s.limit
+	hl.lock.Lock()
+	if len(hl.waiting) == 0 {
+		hl.running--
+		hl.cond.Signal()
+		hl.lock.Unlock()
+		return
+	}
+
+	parked := hl.waiting[0]
+	hl.waiting[0] = parkedTask{}
+	hl.waiting = hl.waiting[1:]
+	hl.lock.Unlock()
+
+	c := s.crawler
+	parked.task.req.hostSlot = &hostSlot{crawler: c, limit: hl, wait: time.Since(parked.since)}
+
+	c.readyLock.Lock()
+	c.readyTasks = append(c.readyTasks, parked.task)
+	c.readyLock.Unlock()
+
+	if !inWorker {
+		go c.runReadyTasks()
+	}
+}
+
+// runReadyTasks runs the parked tasks which have got the slots of their
+// hosts
+func (c *Crawler) runReadyTasks() {
+	for {
+		c.readyLock.Lock()
+		if len(c.readyTasks) == 0 {
+			c.readyLock.Unlock()
+			return
+		}
+		task := c.readyTasks[0]
+		c.readyTasks[0] = nil
+		c.readyTasks = c.readyTasks[1:]
+		c.readyLock.Unlock()
+
+		task.crawler.prepare(task.req, task.isChained)
+	}
+}
+
+// discardParkedTasks removes the parked and the ready tasks when the
+// crawler is stopped
+func (c *Crawler) discardParkedTasks() []*Task {
+	var tasks []*Task
+
+	c.hostSlots.Range(func(_, v any) bool {
+		hl := v.(*hostLimit)
+		hl.lock.Lock()
+		for _, parked := range hl.waiting {
+			tasks = append(tasks, parked.task)
+		}
+		hl.waiting = nil
+		hl.lock.Unlock()
+		return true
+	})
+
+	c.readyLock.Lock()
+	ready := c.readyTasks
+	c.readyTasks = nil
+	c.readyLock.Unlock()
+
+	for _, task := range ready {
+		// 就绪的任务已经取得了名额
+		task.req.hostSlot.release(true)
+		task.req.hostSlot = nil
+		tasks = append(tasks, task)
+	}
+
+	return tasks
+}
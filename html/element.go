@@ -11,6 +11,8 @@ package html
 import (
 	"errors"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/go-predator/tools"
@@ -102,6 +104,51 @@ func (he *HTMLElement) Attr(key string) string {
 	return ""
 }
 
+// SetAttr sets the value of the attribute `key`, which is added if it
+// doesn't exist. The change is reflected by `OuterHTML` and the rendering of
+// the document.
+func (he *HTMLElement) SetAttr(key, val string) {
+	if he == nil {
+		panic(ErrNilElement)
+	}
+
+	for i := range he.Node.Attr {
+		if he.Node.Attr[i].Key == key {
+			he.Node.Attr[i].Val = val
+			return
+		}
+	}
+	he.Node.Attr = append(he.Node.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// RemoveAttr removes the attribute `key` if it exists, such as to strip the
+// tracking attributes before rendering the html.
+func (he *HTMLElement) RemoveAttr(key string) {
+	if he == nil {
+		panic(ErrNilElement)
+	}
+
+	attrs := he.Node.Attr[:0]
+	for _, attr := range he.Node.Attr {
+		if attr.Key != key {
+			attrs = append(attrs, attr)
+		}
+	}
+	he.Node.Attr = attrs
+}
+
+// RemoveNode removes the current element and its descendants from the
+// document, so the rendering of its ancestors doesn't contain it anymore.
+func (he *HTMLElement) RemoveNode() {
+	if he == nil {
+		panic(ErrNilElement)
+	}
+
+	if he.Node.Parent != nil {
+		he.Node.Parent.RemoveChild(he.Node)
+	}
+}
+
 // OuterHtml returns the outer HTML rendering of the first item in
 // the selection - that is, the HTML including the first element's
 // tag and attributes.
@@ -121,8 +168,46 @@ func (he *HTMLElement) Text() string {
 	return he.DOM.Text()
 }
 
+// CleanText is like `Text`, but the text of the invisible elements, such as
+// `<script>`, `<style>` and `<noscript>`, is skipped.
+func (he *HTMLElement) CleanText() string {
+	if he == nil {
+		return ""
+	}
+
+	var b strings.Builder
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		if n.Type == html.ElementNode && invisibleElements[n.Data] {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	for _, n := range he.DOM.Nodes {
+		f(n)
+	}
+
+	return b.String()
+}
+
 // Texts Gets all child text elements in the current element and returns a []string
 func (he *HTMLElement) Texts() []string {
+	return he.texts(false)
+}
+
+// CleanTexts is like `Texts`, but the text of the invisible elements, such
+// as `<script>`, `<style>` and `<noscript>`, is skipped.
+func (he *HTMLElement) CleanTexts() []string {
+	return he.texts(true)
+}
+
+func (he *HTMLElement) texts(skipInvisible bool) []string {
 	if he == nil {
 		return nil
 	}
@@ -150,6 +235,9 @@ func (he *HTMLElement) Texts() []string {
 				}
 			}
 		}
+		if skipInvisible && n.Type == html.ElementNode && invisibleElements[n.Data] {
+			return
+		}
 		if n.FirstChild != nil {
 			for c := n.FirstChild; c != nil; c = c.NextSibling {
 				f(c)
@@ -163,6 +251,99 @@ func (he *HTMLElement) Texts() []string {
 	return texts
 }
 
+// blockElements are the elements rendered on their own lines
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"dd": true, "details": true, "dialog": true, "div": true, "dl": true,
+	"dt": true, "fieldset": true, "figcaption": true, "figure": true,
+	"footer": true, "form": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "header": true, "hr": true,
+	"li": true, "main": true, "nav": true, "ol": true, "p": true,
+	"pre": true, "section": true, "summary": true, "table": true,
+	"tr": true, "ul": true,
+}
+
+// invisibleElements are the elements whose text is not rendered
+var invisibleElements = map[string]bool{
+	"head": true, "noscript": true, "script": true, "style": true, "template": true,
+}
+
+// RenderText returns the readable text of the element, similar to the
+// innerText of a browser: the block elements and `<br>` are separated by
+// newlines, the inline elements and table cells by spaces, and the
+// consecutive whitespaces are collapsed.
+//
+// Unlike `Text`, the text of the script and style elements is skipped.
+func (he *HTMLElement) RenderText() string {
+	if he == nil {
+		return ""
+	}
+
+	var (
+		b strings.Builder
+		// 下一段文本前需要插入的分隔符
+		newline, space bool
+	)
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			fields := strings.Fields(n.Data)
+			if len(fields) == 0 {
+				if n.Data != "" {
+					space = true
+				}
+				return
+			}
+
+			// 按 rune 判断首尾的空白，多字节字符的字节可能被误认为空白
+			first, _ := utf8.DecodeRuneInString(n.Data)
+			last, _ := utf8.DecodeLastRuneInString(n.Data)
+
+			if b.Len() > 0 {
+				if newline {
+					b.WriteByte('\n')
+				} else if space || unicode.IsSpace(first) {
+					b.WriteByte(' ')
+				}
+			}
+			b.WriteString(strings.Join(fields, " "))
+
+			newline = false
+			space = unicode.IsSpace(last)
+			return
+		case html.ElementNode:
+			if invisibleElements[n.Data] {
+				return
+			}
+
+			switch {
+			case n.Data == "br":
+				newline = true
+				return
+			case n.Data == "td" || n.Data == "th":
+				space = true
+			case blockElements[n.Data]:
+				newline = true
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+
+		if n.Type == html.ElementNode && blockElements[n.Data] {
+			newline = true
+		}
+	}
+	for _, n := range he.DOM.Nodes {
+		f(n)
+	}
+
+	return b.String()
+}
+
 // ChildText returns the concatenated and stripped text content of the matching
 // elements.
 func (he *HTMLElement) ChildText(selector string) string {
@@ -222,13 +403,14 @@ func (he *HTMLElement) Each(selector string, callback func(int, *HTMLElement) bo
 		return
 	}
 
-	found.Each(func(_ int, s *goquery.Selection) {
+	found.EachWithBreak(func(_ int, s *goquery.Selection) bool {
 		for _, n := range s.Nodes {
 			if callback(i, NewHTMLElementFromSelectionNode(s, n, i)) {
-				break
+				return false
 			}
 			i++
 		}
+		return true
 	})
 }
 
@@ -266,7 +448,9 @@ func (he *HTMLElement) LastChild(selector string) *HTMLElement {
 	return he.Child(selector, -1)
 }
 
-// Parent returns the direct parent element.
+// Parent returns the direct parent element, or nil if the element
+// has no parent element, such as the <html> tag or the root of a
+// parsed fragment.
 func (he *HTMLElement) Parent() *HTMLElement {
 	// If the current element is <html> tag, return nil
 	if he.Name == "html" {
@@ -274,10 +458,13 @@ func (he *HTMLElement) Parent() *HTMLElement {
 	}
 
 	s := he.DOM.Parent()
+	if len(s.Nodes) == 0 {
+		return nil
+	}
 	return NewHTMLElementFromSelectionNode(s, s.Nodes[0], 0)
 }
 
-// Parents returns all parent elements.
+// Parents returns all parent elements from the nearest one to the root.
 func (he *HTMLElement) Parents() []*HTMLElement {
 	parents := make([]*HTMLElement, 0)
 
@@ -293,6 +480,17 @@ func (he *HTMLElement) Parents() []*HTMLElement {
 	return parents
 }
 
+// Closest returns the first element that matches the selector by testing
+// the element itself and traversing up through its ancestors, or nil if
+// none matches.
+func (he *HTMLElement) Closest(selector string) *HTMLElement {
+	s := he.DOM.Closest(selector)
+	if len(s.Nodes) == 0 {
+		return nil
+	}
+	return NewHTMLElementFromSelectionNode(s, s.Nodes[0], 0)
+}
+
 // FindChildByText returns the first child element matching the target text.
 func (he *HTMLElement) FindChildByText(selector, text string) *HTMLElement {
 	var target *HTMLElement
@@ -319,6 +517,37 @@ func (he *HTMLElement) FindChildByStripedText(selector, text string) *HTMLElemen
 	return target
 }
 
+// Filter returns the elements in the matched set of the current element,
+// that is `DOM`, which match the selector, such as to keep the ".item"
+// elements with a ".hot" class.
+func (he *HTMLElement) Filter(selector string) []*HTMLElement {
+	if he == nil {
+		panic(ErrNilElement)
+	}
+
+	return newHTMLElements(he.DOM.Filter(selector))
+}
+
+// Not returns the elements in the matched set of the current element,
+// that is `DOM`, which don't match the selector, such as to exclude the
+// ".ad" elements from the ".item" elements.
+func (he *HTMLElement) Not(selector string) []*HTMLElement {
+	if he == nil {
+		panic(ErrNilElement)
+	}
+
+	return newHTMLElements(he.DOM.Not(selector))
+}
+
+// newHTMLElements creates a HTMLElement for each node of the selection
+func newHTMLElements(s *goquery.Selection) []*HTMLElement {
+	elements := make([]*HTMLElement, 0, s.Length())
+	s.Each(func(i int, s *goquery.Selection) {
+		elements = append(elements, NewHTMLElementFromSelectionNode(s, s.Nodes[0], i))
+	})
+	return elements
+}
+
 // Children returns all child elements matching the selector
 func (he *HTMLElement) Children(selector string) []*HTMLElement {
 	children := make([]*HTMLElement, 0, 3)
@@ -352,3 +581,41 @@ func (he *HTMLElement) FindChildrenByStripedText(selector, text string) []*HTMLE
 	})
 	return targets
 }
+
+// FindByAttr returns the first child element of `tag` whose attribute
+// `attr` equals `value`. The attribute is compared directly instead of
+// being built into a selector, so `value` doesn't need to be escaped.
+//
+// If `tag` is empty, the elements of all tags are searched.
+func (he *HTMLElement) FindByAttr(tag, attr, value string) *HTMLElement {
+	if tag == "" {
+		tag = "*"
+	}
+
+	var target *HTMLElement
+	he.Each(tag, func(i int, h *HTMLElement) bool {
+		if v, ok := h.DOM.Attr(attr); ok && v == value {
+			target = h
+			return true
+		}
+		return false
+	})
+	return target
+}
+
+// FindAllByAttr returns all the child elements of `tag` whose attribute
+// `attr` equals `value`, see `FindByAttr`.
+func (he *HTMLElement) FindAllByAttr(tag, attr, value string) []*HTMLElement {
+	if tag == "" {
+		tag = "*"
+	}
+
+	targets := make([]*HTMLElement, 0, 3)
+	he.Each(tag, func(i int, h *HTMLElement) bool {
+		if v, ok := h.DOM.Attr(attr); ok && v == value {
+			targets = append(targets, h)
+		}
+		return false
+	})
+	return targets
+}
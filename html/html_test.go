@@ -9,9 +9,13 @@
 package html
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/PuerkitoBio/goquery"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 var body = []byte(`<body><div id="barrierfree_container">
@@ -53,3 +57,267 @@ func TestGetParent(t *testing.T) {
 		})
 	})
 }
+
+func TestRenderText(t *testing.T) {
+	Convey("test to render the readable text", t, func() {
+		Convey("the fixture", func() {
+			doc, err := ParseHTML(body)
+			So(err, ShouldBeNil)
+
+			s := doc.Find("#barrierfree_container")
+			he := NewHTMLElementFromSelectionNode(s, s.Nodes[0], 0)
+			So(he.RenderText(), ShouldEqual, "login\nemail\ntg")
+		})
+
+		Convey("blocks, inlines and tables", func() {
+			doc, err := ParseHTML([]byte(`<div id="main">
+  <h1>  Title </h1>
+  <p>Hello <b>world</b>,<br>bye<span>!</span></p>
+  <script>var a = 1;</script>
+  <table><tr><td>a</td><td>b</td></tr><tr><td>c</td><td>d</td></tr></table>
+</div>`))
+			So(err, ShouldBeNil)
+
+			s := doc.Find("#main")
+			he := NewHTMLElementFromSelectionNode(s, s.Nodes[0], 0)
+			So(he.RenderText(), ShouldEqual, "Title\nHello world,\nbye!\na b\nc d")
+			So(he.Text(), ShouldContainSubstring, "var a = 1;")
+		})
+
+		Convey("multibyte characters", func() {
+			// the last byte of "你" is 0xA0, which is a whitespace as a rune
+			doc, err := ParseHTML([]byte(`<div id="main"><p><span>你</span><span>好</span>，<b>世界</b></p><p> 再见 </p></div>`))
+			So(err, ShouldBeNil)
+
+			s := doc.Find("#main")
+			he := NewHTMLElementFromSelectionNode(s, s.Nodes[0], 0)
+			So(he.RenderText(), ShouldEqual, "你好，世界\n再见")
+		})
+	})
+}
+
+func TestGetParentOfFragment(t *testing.T) {
+	Convey("test to find the parent element in a fragment without ancestors", t, func() {
+		nodes, err := html.ParseFragment(strings.NewReader(`<li><a href="#">login</a></li>`), &html.Node{
+			Type:     html.ElementNode,
+			Data:     "ul",
+			DataAtom: atom.Ul,
+		})
+		So(err, ShouldBeNil)
+		So(nodes, ShouldHaveLength, 1)
+
+		s := goquery.NewDocumentFromNode(nodes[0]).Find("a")
+		a := NewHTMLElementFromSelectionNode(s, s.Nodes[0], 0)
+
+		li := a.Parent()
+		So(li, ShouldNotBeNil)
+		So(li.Name, ShouldEqual, "li")
+		So(li.Parent(), ShouldBeNil)
+
+		parents := a.Parents()
+		So(parents, ShouldHaveLength, 1)
+		So(parents[0].Name, ShouldEqual, "li")
+	})
+}
+
+func TestClosest(t *testing.T) {
+	Convey("test to find the closest element", t, func() {
+		doc, err := ParseHTML(body)
+		So(err, ShouldBeNil)
+
+		imgSelection := doc.Find("#showImg02")
+		img := NewHTMLElementFromSelectionNode(imgSelection, imgSelection.Nodes[0], 0)
+
+		li := img.Closest("li")
+		So(li, ShouldNotBeNil)
+		So(li.Attr("id"), ShouldEqual, "showImg01")
+
+		ul := img.Closest("ul.top_l")
+		So(ul, ShouldNotBeNil)
+		So(ul.Name, ShouldEqual, "ul")
+
+		div := li.Closest("div")
+		So(div.Attr("class"), ShouldEqual, "cf top")
+
+		So(img.Closest("img").Attr("id"), ShouldEqual, "showImg02")
+		So(img.Closest("table"), ShouldBeNil)
+	})
+}
+
+func TestFindByAttr(t *testing.T) {
+	Convey("test to find the elements by attribute", t, func() {
+		doc, err := ParseHTML([]byte(`<div id="main">
+  <span data-id="1">a</span>
+  <span data-id='say "hi"'>b</span>
+  <a data-id="it's a b">c</a>
+  <span data-id="it's a b">d</span>
+  <span data-id="">e</span>
+  <span>f</span>
+</div>`))
+		So(err, ShouldBeNil)
+
+		s := doc.Find("#main")
+		main := NewHTMLElementFromSelectionNode(s, s.Nodes[0], 0)
+
+		So(main.FindByAttr("span", "data-id", `say "hi"`).Text(), ShouldEqual, "b")
+		So(main.FindByAttr("span", "data-id", "it's a b").Text(), ShouldEqual, "d")
+		So(main.FindByAttr("", "data-id", "it's a b").Text(), ShouldEqual, "c")
+		So(main.FindByAttr("span", "data-id", "").Text(), ShouldEqual, "e")
+		So(main.FindByAttr("span", "data-id", "2"), ShouldBeNil)
+
+		all := main.FindAllByAttr("", "data-id", "it's a b")
+		So(all, ShouldHaveLength, 2)
+		So(all[0].Name, ShouldEqual, "a")
+		So(all[1].Name, ShouldEqual, "span")
+
+		So(main.FindAllByAttr("p", "data-id", "1"), ShouldBeEmpty)
+	})
+}
+
+func TestRedirectURL(t *testing.T) {
+	Convey("Test RedirectURL", t, func() {
+		for page, target := range map[string]string{
+			`<meta http-equiv="refresh" content="0; url=/index">`:          "/index",
+			`<meta http-equiv="Refresh" content="5;URL='http://a.com/b'">`: "http://a.com/b",
+			`<meta http-equiv="refresh" content='3; url = "/c"'>`:          "/c",
+			`<meta http-equiv="refresh" content="30">`:                     "",
+			`<script>window.location.href = "/d";</script>`:                "/d",
+			`<script>location.replace('/e')</script>`:                      "/e",
+			`<script src="/f.js"></script><p>location.href = "/g"</p>`:     "",
+			`<p>no redirect</p>`: "",
+		} {
+			doc, err := ParseHTML([]byte(page))
+			So(err, ShouldBeNil)
+			So(RedirectURL(doc), ShouldEqual, target)
+		}
+	})
+}
+
+func TestModifyElement(t *testing.T) {
+	Convey("Test modifying the elements", t, func() {
+		doc, err := ParseHTML([]byte(`<div id="main"><a href="/a" data-track="1" onclick="track()">a</a><img src="/t.gif" class="pixel"><p>text</p></div>`))
+		So(err, ShouldBeNil)
+
+		mainSelection := doc.Find("#main")
+		main := NewHTMLElementFromSelectionNode(mainSelection, mainSelection.Nodes[0], 0)
+
+		Convey("set and remove the attributes", func() {
+			a := main.FirstChild("a")
+			a.SetAttr("href", "/b")
+			a.SetAttr("rel", "nofollow")
+			a.RemoveAttr("data-track")
+			a.RemoveAttr("onclick")
+			a.RemoveAttr("not-exist")
+
+			So(a.Attr("href"), ShouldEqual, "/b")
+
+			outer, err := a.OuterHTML()
+			So(err, ShouldBeNil)
+			So(outer, ShouldEqual, `<a href="/b" rel="nofollow">a</a>`)
+		})
+
+		Convey("remove the node", func() {
+			main.FirstChild("img").RemoveNode()
+
+			outer, err := main.OuterHTML()
+			So(err, ShouldBeNil)
+			So(outer, ShouldEqual, `<div id="main"><a href="/a" data-track="1" onclick="track()">a</a><p>text</p></div>`)
+			So(doc.Find("img").Length(), ShouldEqual, 0)
+
+			// removing a detached node does nothing
+			p := main.FirstChild("p")
+			p.RemoveNode()
+			So(func() { p.RemoveNode() }, ShouldNotPanic)
+			So(main.Children("p"), ShouldBeEmpty)
+		})
+
+		Convey("nil element", func() {
+			var he *HTMLElement
+			So(func() { he.SetAttr("a", "b") }, ShouldPanicWith, ErrNilElement)
+			So(func() { he.RemoveAttr("a") }, ShouldPanicWith, ErrNilElement)
+			So(func() { he.RemoveNode() }, ShouldPanicWith, ErrNilElement)
+		})
+	})
+}
+
+func TestCleanText(t *testing.T) {
+	Convey("Test skipping the scripts and styles", t, func() {
+		doc, err := ParseHTML([]byte(`<html><head><title>t</title><style>body { color: red; }</style></head>
+<body><article>
+  <h1>Title</h1>
+  <script>var a = "<b>x</b>";</script>
+  <p>Hello <style>.a{}</style><b>world</b></p>
+  <noscript>enable js</noscript>
+  <p>Bye</p>
+</article></body></html>`))
+		So(err, ShouldBeNil)
+
+		s := doc.Find("article")
+		article := NewHTMLElementFromSelectionNode(s, s.Nodes[0], 0)
+
+		So(article.Text(), ShouldContainSubstring, "var a")
+		So(article.Texts(), ShouldContain, ".a{}")
+
+		text := article.CleanText()
+		So(text, ShouldNotContainSubstring, "var a")
+		So(text, ShouldNotContainSubstring, ".a{}")
+		So(text, ShouldNotContainSubstring, "enable js")
+		So(strings.Join(strings.Fields(text), " "), ShouldEqual, "Title Hello world Bye")
+
+		So(article.CleanTexts(), ShouldResemble, []string{"Title", "Hello", "world", "Bye"})
+
+		s = doc.Find("html")
+		root := NewHTMLElementFromSelectionNode(s, s.Nodes[0], 0)
+		So(root.CleanText(), ShouldNotContainSubstring, "color")
+
+		var he *HTMLElement
+		So(he.CleanText(), ShouldEqual, "")
+		So(he.CleanTexts(), ShouldBeNil)
+	})
+}
+
+func TestFilter(t *testing.T) {
+	Convey("Test filtering the matched elements", t, func() {
+		doc, err := ParseHTML([]byte(`<ul>
+<li class="item">1</li>
+<li class="item ad">ad</li>
+<li class="item hot">2</li>
+<li class="item ad hot">hot ad</li>
+<li class="other">3</li>
+</ul>`))
+		So(err, ShouldBeNil)
+
+		texts := func(elements []*HTMLElement) []string {
+			var res []string
+			for _, e := range elements {
+				res = append(res, e.Text())
+			}
+			return res
+		}
+
+		s := doc.Find(".item")
+		items := NewHTMLElementFromSelectionNode(s, s.Nodes[0], 0)
+
+		So(texts(items.Not(".ad")), ShouldResemble, []string{"1", "2"})
+		So(texts(items.Filter(".hot")), ShouldResemble, []string{"2", "hot ad"})
+		So(texts(items.Filter(".hot:not(.ad)")), ShouldResemble, []string{"2"})
+		So(items.Filter(".other"), ShouldBeEmpty)
+
+		Convey("filter a single element", func() {
+			s := doc.Find("ul")
+			ul := NewHTMLElementFromSelectionNode(s, s.Nodes[0], 0)
+
+			var kept []string
+			for _, item := range ul.Children(".item") {
+				if len(item.Not(".ad")) > 0 {
+					kept = append(kept, item.Text())
+				}
+			}
+			So(kept, ShouldResemble, []string{"1", "2"})
+
+			filtered := ul.Filter("ul")
+			So(filtered, ShouldHaveLength, 1)
+			So(filtered[0].Name, ShouldEqual, "ul")
+		})
+	})
+}
@@ -10,6 +10,8 @@ package html
 
 import (
 	"bytes"
+	"regexp"
+	"strings"
 
 	"github.com/PuerkitoBio/goquery"
 )
@@ -23,3 +25,52 @@ func ParseHTML(body []byte) (*goquery.Document, error) {
 	}
 	return doc, nil
 }
+
+// 常见的 js 跳转，如 `window.location.href = "..."` 和 `location.replace("...")`
+var jsRedirectRegexp = regexp.MustCompile(`(?:window\.|document\.|top\.|self\.)?location(?:\.href)?\s*=\s*["']([^"']+)["']|location\.(?:replace|assign)\(\s*["']([^"']+)["']\s*\)`)
+
+// RedirectURL returns the target url of the meta refresh tag or the common
+// js redirect of the document, such as `location.href = "/index"`, or
+// returns an empty string if there is no redirect.
+//
+// The returned url may be relative.
+func RedirectURL(doc *goquery.Document) string {
+	var target string
+
+	doc.Find("meta[http-equiv]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if !strings.EqualFold(strings.TrimSpace(s.AttrOr("http-equiv", "")), "refresh") {
+			return true
+		}
+		target = refreshURL(s.AttrOr("content", ""))
+		return target == ""
+	})
+	if target != "" {
+		return target
+	}
+
+	doc.Find("script:not([src])").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if m := jsRedirectRegexp.FindStringSubmatch(s.Text()); m != nil {
+			target = m[1] + m[2]
+		}
+		return target == ""
+	})
+
+	return target
+}
+
+// refreshURL returns the url in the content of a meta refresh tag,
+// such as `5; url='/index'`
+func refreshURL(content string) string {
+	i := strings.IndexAny(content, ";,")
+	if i < 0 {
+		return ""
+	}
+
+	u := strings.TrimSpace(content[i+1:])
+	if len(u) > 3 && strings.EqualFold(u[:3], "url") {
+		if rest := strings.TrimSpace(u[3:]); strings.HasPrefix(rest, "=") {
+			u = strings.TrimSpace(rest[1:])
+		}
+	}
+	return strings.Trim(u, `"'`)
+}
@@ -8,7 +8,12 @@
 
 package json
 
-import jsoniter "github.com/json-iterator/go"
+import (
+	"bytes"
+	stdjson "encoding/json"
+
+	jsoniter "github.com/json-iterator/go"
+)
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
@@ -28,3 +33,13 @@ func Unmarshal(src []byte, v any) error {
 func UnmarshalFromString(src string, v any) error {
 	return json.UnmarshalFromString(src, v)
 }
+
+// Indent formats the json `src` with indentation, the order
+// of the keys is kept.
+func Indent(src []byte, prefix, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := stdjson.Indent(&buf, src, prefix, indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -21,3 +21,14 @@ func ParseBytesToJSON(body []byte) JSONResult {
 func ParseJSON(body string) JSONResult {
 	return gjson.Parse(body)
 }
+
+// ValidBytes returns true if `body` is a valid json
+func ValidBytes(body []byte) bool {
+	return gjson.ValidBytes(body)
+}
+
+// GetBytes searches `body` for the specified path, without parsing
+// the other parts of `body`
+func GetBytes(body []byte, path string) JSONResult {
+	return gjson.GetBytes(body, path)
+}
@@ -0,0 +1,165 @@
+package json
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Schema is a subset of JSON Schema, which is enough to check the
+// structure of most json responses.
+//
+// Only the keywords `type`, `required`, `properties`, `items` and
+// `enum` are supported, and the other keywords are ignored.
+type Schema struct {
+	Type       schemaType         `json:"type"`
+	Required   []string           `json:"required"`
+	Properties map[string]*Schema `json:"properties"`
+	Items      *Schema            `json:"items"`
+	Enum       []any              `json:"enum"`
+}
+
+// schemaType is the `type` keyword, which may be a string or an array of strings
+type schemaType []string
+
+func (st *schemaType) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := Unmarshal(b, &single); err == nil {
+		*st = schemaType{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := Unmarshal(b, &multiple); err != nil {
+		return fmt.Errorf("the type of the schema must be a string or an array of strings: %s", b)
+	}
+	*st = multiple
+	return nil
+}
+
+// CompileSchema parses a JSON Schema
+func CompileSchema(schema []byte) (*Schema, error) {
+	var s Schema
+	if err := Unmarshal(schema, &s); err != nil {
+		return nil, err
+	}
+
+	for _, t := range s.allTypes() {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return nil, fmt.Errorf("unknown type of the schema: %s", t)
+		}
+	}
+
+	return &s, nil
+}
+
+// allTypes returns the types of the schema and its sub-schemas
+func (s *Schema) allTypes() []string {
+	types := append([]string{}, s.Type...)
+	for _, p := range s.Properties {
+		types = append(types, p.allTypes()...)
+	}
+	if s.Items != nil {
+		types = append(types, s.Items.allTypes()...)
+	}
+	return types
+}
+
+// Validate checks whether `v` matches the schema, and returns an error
+// describing the first mismatch
+func (s *Schema) Validate(v JSONResult) error {
+	return s.validate("$", v)
+}
+
+func (s *Schema) validate(path string, v JSONResult) error {
+	if len(s.Type) > 0 && !s.matchType(v) {
+		return fmt.Errorf("%s: expected type %s, got %s", path, strings.Join(s.Type, " or "), typeOf(v))
+	}
+
+	if len(s.Enum) > 0 {
+		matched := false
+		value := v.Value()
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(value, e) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: %s is not one of %v", path, v.Raw, s.Enum)
+		}
+	}
+
+	if v.IsObject() && (len(s.Required) > 0 || len(s.Properties) > 0) {
+		fields := make(map[string]JSONResult)
+		v.ForEach(func(key, value JSONResult) bool {
+			fields[key.String()] = value
+			return true
+		})
+
+		for _, key := range s.Required {
+			if _, ok := fields[key]; !ok {
+				return fmt.Errorf("%s: missing required field %q", path, key)
+			}
+		}
+
+		for key, sub := range s.Properties {
+			if value, ok := fields[key]; ok {
+				if err := sub.validate(path+"."+key, value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if v.IsArray() && s.Items != nil {
+		var err error
+		i := 0
+		v.ForEach(func(_, value JSONResult) bool {
+			err = s.Items.validate(fmt.Sprintf("%s[%d]", path, i), value)
+			i++
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) matchType(v JSONResult) bool {
+	actual := typeOf(v)
+	for _, t := range s.Type {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(v JSONResult) string {
+	switch v.Type {
+	case gjson.String:
+		return "string"
+	case gjson.Number:
+		if v.Num == math.Trunc(v.Num) {
+			return "integer"
+		}
+		return "number"
+	case gjson.True, gjson.False:
+		return "boolean"
+	case gjson.JSON:
+		if v.IsArray() {
+			return "array"
+		}
+		return "object"
+	default:
+		return "null"
+	}
+}
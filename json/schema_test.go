@@ -0,0 +1,65 @@
+package json
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSchema(t *testing.T) {
+	schema := []byte(`{
+		"type": "object",
+		"required": ["code", "data"],
+		"properties": {
+			"code": {"type": "integer", "enum": [0, 1]},
+			"msg": {"type": ["string", "null"]},
+			"data": {
+				"type": "array",
+				"items": {
+					"type": "object",
+					"required": ["id"],
+					"properties": {"id": {"type": "integer"}, "score": {"type": "number"}}
+				}
+			}
+		}
+	}`)
+
+	Convey("测试 json schema", t, func() {
+		s, err := CompileSchema(schema)
+		So(err, ShouldBeNil)
+
+		Convey("匹配", func() {
+			for _, body := range []string{
+				`{"code": 0, "data": []}`,
+				`{"code": 1, "msg": null, "data": [{"id": 1, "score": 2}, {"id": 2, "score": 2.5}]}`,
+				`{"code": 0, "msg": "ok", "data": [], "extra": true}`,
+			} {
+				So(s.Validate(ParseJSON(body)), ShouldBeNil)
+			}
+		})
+
+		Convey("不匹配", func() {
+			for body, msg := range map[string]string{
+				`[]`:                                  "$: expected type object, got array",
+				`{"code": 0}`:                         `$: missing required field "data"`,
+				`{"code": 2, "data": []}`:             "$.code: 2 is not one of [0 1]",
+				`{"code": 0.5, "data": []}`:           "$.code: expected type integer, got number",
+				`{"code": 0, "msg": 1, "data": []}`:   "$.msg: expected type string or null, got integer",
+				`{"code": 0, "data": [{"id": 1}, 2]}`: "$.data[1]: expected type object, got integer",
+				`{"code": 0, "data": [{"name": ""}]}`: `$.data[0]: missing required field "id"`,
+			} {
+				err := s.Validate(ParseJSON(body))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, msg)
+			}
+		})
+
+		Convey("无效的 schema", func() {
+			_, err := CompileSchema([]byte(`{"type": "int"}`))
+			So(err, ShouldNotBeNil)
+
+			_, err = CompileSchema([]byte(`{"type": 1}`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,104 @@
+package predator
+
+import (
+	"sync"
+
+	"github.com/go-predator/log"
+)
+
+// LinkStatus is the result of checking a link by `CheckLinks`
+type LinkStatus struct {
+	// The status code of the response, 0 if the request failed
+	StatusCode int
+	// The error of the request
+	Err error
+}
+
+// Broken reports whether the link is broken, that is, the request failed
+// or the status code is not 2xx or 3xx.
+func (s LinkStatus) Broken() bool {
+	return s.Err != nil || s.StatusCode < 200 || s.StatusCode >= 400
+}
+
+// CheckLinks checks whether the `urls` are broken and returns the status of
+// each url. A HEAD request is sent for each url, and a GET request is sent
+// instead if the server doesn't allow the HEAD method.
+//
+// The links are checked concurrently with the concurrency of the crawler,
+// see `WithConcurrency`, and the user agent, the default headers, the
+// cookies, the proxies, the cache and the per-host concurrency of the
+// crawler are used, but none of the handlers is called.
+func (c *Crawler) CheckLinks(urls []string) map[string]LinkStatus {
+	concurrency := 1
+	if c.goPool != nil {
+		concurrency = int(c.goPool.GetCap())
+	}
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		result = make(map[string]LinkStatus, len(urls))
+		sem    = make(chan struct{}, concurrency)
+	)
+
+	for _, URL := range urls {
+		lock.Lock()
+		_, ok := result[URL]
+		if !ok {
+			// 先占位，重复的 url 只检查一次
+			result[URL] = LinkStatus{}
+		}
+		lock.Unlock()
+		if ok {
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(URL string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			status := c.checkLink(URL)
+
+			lock.Lock()
+			result[URL] = status
+			lock.Unlock()
+		}(URL)
+	}
+
+	wg.Wait()
+
+	return result
+}
+
+// checkLink sends a HEAD request to `URL`, and a GET request if the HEAD
+// method is not allowed
+func (c *Crawler) checkLink(URL string) LinkStatus {
+	resp, err := c.Head(URL)
+	if err != nil {
+		return LinkStatus{Err: err}
+	}
+	status := resp.StatusCode
+	ReleaseResponse(resp, false)
+
+	if status == StatusMethodNotAllowed || status == StatusNotImplemented {
+		c.Debug("the HEAD method is not allowed, try the GET method", log.Arg{Key: "url", Value: URL})
+
+		if c.Context.Err() != nil {
+			return LinkStatus{Err: ErrCrawlerStopped}
+		}
+
+		resp, err = c.fetch(MethodGet, URL)
+		if err != nil {
+			c.Error(err, log.Arg{Key: "url", Value: URL})
+			return LinkStatus{Err: err}
+		}
+		status = resp.StatusCode
+		ReleaseResponse(resp, false)
+	}
+
+	return LinkStatus{StatusCode: status}
+}
@@ -0,0 +1,86 @@
+package predator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckLinks(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		requests []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		lock.Unlock()
+
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte("ok"))
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Write([]byte("ok"))
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	Convey("测试检查链接", t, func() {
+		c := NewCrawler(WithConcurrency(3, false))
+
+		var handled bool
+		c.AfterResponse(func(r *Response) {
+			handled = true
+		})
+
+		result := c.CheckLinks([]string{
+			ts.URL + "/ok",
+			ts.URL + "/redirect",
+			ts.URL + "/no-head",
+			ts.URL + "/missing",
+			ts.URL + "/error",
+			ts.URL + "/ok",
+			"http://[::1/",
+			"http://127.0.0.1:1/",
+		})
+		So(result, ShouldHaveLength, 7)
+
+		for path, code := range map[string]int{
+			"/ok":       200,
+			"/redirect": 302,
+			"/no-head":  200,
+			"/missing":  404,
+			"/error":    500,
+		} {
+			status := result[ts.URL+path]
+			So(status.Err, ShouldBeNil)
+			So(status.StatusCode, ShouldEqual, code)
+			So(status.Broken(), ShouldEqual, code >= 400)
+		}
+
+		So(result["http://[::1/"].Err, ShouldNotBeNil)
+		So(result["http://[::1/"].Broken(), ShouldBeTrue)
+
+		// 无法连接的链接
+		So(result["http://127.0.0.1:1/"].Err, ShouldNotBeNil)
+		So(result["http://127.0.0.1:1/"].Broken(), ShouldBeTrue)
+
+		// 只有不允许 HEAD 方法的链接才会发出 GET 请求，重复的链接只检查一次
+		So(requests, ShouldHaveLength, 6)
+		So(requests, ShouldContain, "GET /no-head")
+		So(handled, ShouldBeFalse)
+	})
+}
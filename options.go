@@ -10,22 +10,70 @@ package predator
 
 import (
 	"crypto/tls"
+	"fmt"
+	"io"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-predator/log"
+	pctx "github.com/go-predator/predator/context"
+	"github.com/valyala/fasthttp"
 )
 
 type CrawlerOption func(*Crawler)
 
 // SkipVerification will skip verifying the certificate when
-// you access the `https` protocol
+// you access the `https` protocol.
+//
+// The proxy pool only replaces the dialer of the client, so the
+// setting also applies to the requests sent through proxies.
 func SkipVerification() CrawlerOption {
 	return func(c *Crawler) {
-		c.client.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+		tlsConfig(c).InsecureSkipVerify = true
+	}
+}
+
+// WithTLSConfig uses a custom tls config for the `https` protocol, such as
+// to set the minimum TLS version or the root CAs.
+//
+// The config is cloned. `SkipVerification` and the certificates added by
+// `WithClientCert` are kept no matter which option comes first.
+func WithTLSConfig(cfg *tls.Config) CrawlerOption {
+	return func(c *Crawler) {
+		old := c.client.TLSConfig
+		c.client.TLSConfig = cfg.Clone()
+		if old != nil {
+			if old.InsecureSkipVerify {
+				c.client.TLSConfig.InsecureSkipVerify = true
+			}
+			c.client.TLSConfig.Certificates = append(c.client.TLSConfig.Certificates, old.Certificates...)
+		}
 	}
 }
 
+// WithClientCert loads a client certificate from a pair of PEM encoded files
+// and presents it to the servers which require client authentication.
+func WithClientCert(certFile, keyFile string) CrawlerOption {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		panic(err)
+	}
+
+	return func(c *Crawler) {
+		cfg := tlsConfig(c)
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+}
+
+// tlsConfig returns the tls config of the client, creating one if it is nil
+func tlsConfig(c *Crawler) *tls.Config {
+	if c.client.TLSConfig == nil {
+		c.client.TLSConfig = &tls.Config{}
+	}
+	return c.client.TLSConfig
+}
+
 func WithLogger(logger *log.Logger) CrawlerOption {
 	if logger == nil {
 		logger = log.NewLogger(log.WARNING, log.ToConsole(), 2)
@@ -54,16 +102,67 @@ func WithConsoleAndFileLogger(level log.Level, filename string) CrawlerOption {
 	}
 }
 
+// WithJSONLogger writes the log as raw JSON lines to `w` without the console
+// formatter, which is convenient for log collectors to parse.
+func WithJSONLogger(level log.Level, w io.Writer) CrawlerOption {
+	return func(c *Crawler) {
+		c.log = log.NewLogger(level, w, 2)
+	}
+}
+
+// WithLogSampling emits only 1 in `every` logs below the `WARNING` level,
+// such as the request and response logs, to reduce the log volume of large
+// crawls. Warnings and errors are always emitted.
+//
+// It takes effect on the logger of the crawler regardless of the order of
+// the options.
+func WithLogSampling(every uint32) CrawlerOption {
+	return func(c *Crawler) {
+		c.logSampling = every
+	}
+}
+
+// WithLogContext attaches the fields to every log of the crawler, such as
+// the id of the crawl job and the name of the worker, for correlation.
+//
+// Like `WithLogSampling`, it can be placed before or after the logger
+// option.
+func WithLogContext(fields map[string]any) CrawlerOption {
+	return func(c *Crawler) {
+		c.logContext = fields
+	}
+}
+
 func WithDefaultLogger() CrawlerOption {
 	return WithLogger(nil)
 }
 
+// WithPanicInsteadOfExit makes the crawler panic with the error rather than
+// exit the program when a fatal error occurs. It is useful when the crawler
+// is embedded in a long-running service, where the panic can be recovered.
+func WithPanicInsteadOfExit() CrawlerOption {
+	return func(c *Crawler) {
+		c.panicInsteadOfExit = true
+	}
+}
+
 func WithUserAgent(ua string) CrawlerOption {
 	return func(c *Crawler) {
 		c.UserAgent = ua
 	}
 }
 
+// WithHeaders sets the default headers of every request, such as "Accept"
+// and "Accept-Language".
+//
+// The headers set for a single request are not overridden by the default
+// headers, and the default headers can still be changed in `BeforeRequest`.
+func WithHeaders(headers map[string]string) CrawlerOption {
+	return func(c *Crawler) {
+		c.headers = headers
+	}
+}
+
 func WithRawCookie(cookie string) CrawlerOption {
 	cookies := make(map[string]string)
 	cookieSlice := strings.Split(cookie, "; ")
@@ -104,6 +203,156 @@ func WithRetry(count uint32, cond RetryCondition) CrawlerOption {
 	}
 }
 
+// WithAcceptEncoding sets the "Accept-Encoding" header of the requests to
+// `encodings`, and decodes the response bodies compressed with them, the
+// supported encodings are "gzip", "deflate" and "br", and all of them are
+// used if none is passed in.
+//
+// Unlike the net/http package, fasthttp never decodes the response bodies,
+// so the bodies stay compressed if the "Accept-Encoding" header is set by
+// `WithHeaders` or `Request.SetHeaders` without this option, and
+// `Response.BodyGunzip` is needed to read them. With this option, the
+// crawler decodes whatever the server responds with, even if the header
+// is overridden by a request, and removes the "Content-Encoding" header
+// of the responses. A response that can't be decoded fails the request.
+func WithAcceptEncoding(encodings ...string) CrawlerOption {
+	if len(encodings) == 0 {
+		encodings = []string{"gzip", "deflate", "br"}
+	}
+	for _, encoding := range encodings {
+		switch encoding {
+		case "gzip", "deflate", "br":
+		default:
+			panic(fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding))
+		}
+	}
+
+	return func(c *Crawler) {
+		c.acceptEncoding = strings.Join(encodings, ", ")
+	}
+}
+
+// StatusErrorCondition decides whether a status code is an error, see
+// `WithStatusErrors`.
+type StatusErrorCondition func(statusCode int) bool
+
+// WithStatusErrors makes the requests return an `*HTTPError` if the status
+// codes of their responses meet `cond`, such as the 5xx responses of an API,
+// which is convenient if the crawler is used as an API client. The status
+// codes greater than or equal to 400 are errors if `cond` is nil.
+//
+// The handlers are still called with the responses, and the error is
+// returned after them. The error can't be returned by the requests sent in
+// the goroutine pool, but is still passed to `GetAll` and `PostAll`. There
+// is no error for any status code by default.
+func WithStatusErrors(cond StatusErrorCondition) CrawlerOption {
+	if cond == nil {
+		cond = func(statusCode int) bool {
+			return statusCode >= 400
+		}
+	}
+
+	return func(c *Crawler) {
+		c.statusErrors = cond
+	}
+}
+
+// ResponseValidator validates the response before the response handlers, and
+// returns an error if the response is unusable, such as a block page of the
+// WAF returned with 200.
+type ResponseValidator func(r *Response) error
+
+// WithResponseValidator sets the validator of the responses, which is called
+// right after the responses are received, before the `AfterResponse`
+// handlers.
+//
+// If the validator returns an error, the request is retried if the retry is
+// enabled by `WithRetry`, regardless of the retry condition. The response
+// failed to be validated after the last retry is not cached, and is marked
+// as invalid after the `AfterResponse` handlers, see `Response.Invalidate`,
+// so the html and json handlers are skipped. The error can be got by
+// `Response.ValidationError` in the `AfterResponse` handlers.
+func WithResponseValidator(validator ResponseValidator) CrawlerOption {
+	return func(c *Crawler) {
+		c.responseValidator = validator
+	}
+}
+
+// RequestSigner signs the request right before it is sent, such as to add an
+// HMAC signature computed over the method, the path, the body and a
+// timestamp.
+type RequestSigner func(r *Request) error
+
+// WithRequestSigner sets the signer of the requests, which is called in
+// every attempt right before the request is sent, after the body and the
+// default headers are set, so the request can be signed with a fresh
+// timestamp in each retry.
+//
+// `Request.Headers` of the request passed to the signer are the final
+// headers, and `Request.Body` is the final body, which is compressed if the
+// compression of the body is enabled. The changes of the headers are sent,
+// but the changes of the body are ignored. If the signer returns an error,
+// the request fails with the error without being sent.
+func WithRequestSigner(signer RequestSigner) CrawlerOption {
+	return func(c *Crawler) {
+		c.requestSigner = signer
+	}
+}
+
+// WithCacheFailOpen decides what to do when the cache can't be read, such as
+// a corrupted cache entry or a panic of the cache backend. If `open` is true,
+// which is the default, the error is logged as a warning and treated as a
+// cache miss, so the request is still sent. Otherwise the request fails with
+// the error wrapping ErrCacheRead.
+func WithCacheFailOpen(open bool) CrawlerOption {
+	return func(c *Crawler) {
+		c.cacheFailClosed = !open
+	}
+}
+
+// WithAsyncCache writes the caches in a background goroutine with a buffer
+// of `bufferSize` writes, so the workers don't wait for the slow cache
+// backends, such as the remote databases. The workers still wait when the
+// buffer is full.
+//
+// The errors of the async writes are logged instead of being returned by
+// the requests. The queued writes are flushed by `Wait`, `Stop` or
+// `FlushCache`.
+//
+// If the cache implements `BatchCache`, the writes queued at the same time
+// are written together, at most `bufferSize` at once.
+func WithAsyncCache(bufferSize int) CrawlerOption {
+	return func(c *Crawler) {
+		c.asyncCacheSize = bufferSize
+	}
+}
+
+// WithMaxRetryDuration limits the total time to retry a request since its
+// first attempt, so that an endpoint always failing can't block a worker for
+// a long time. Once it is exceeded, the request is not retried any more even
+// if the number of retries is not exhausted, and the last response or error
+// is returned.
+//
+// It also applies to the retries of the timeouts and the `Response.Retry`.
+func WithMaxRetryDuration(d time.Duration) CrawlerOption {
+	return func(c *Crawler) {
+		c.maxRetryDuration = d
+	}
+}
+
+// WithRetryBackoff waits for a random delay between 0 and the exponential
+// backoff before each retry, which starts from `base` and doubles on each
+// retry up to `max`. There is no limit if `max` is 0.
+//
+// The delay never exceeds the rest of the max retry duration, see
+// `WithMaxRetryDuration`.
+func WithRetryBackoff(base, max time.Duration) CrawlerOption {
+	return func(c *Crawler) {
+		c.retryBackoffBase = base
+		c.retryBackoffMax = max
+	}
+}
+
 // WithProxy 使用一个代理
 func WithProxy(proxyURL string) CrawlerOption {
 	return func(c *Crawler) {
@@ -129,6 +378,11 @@ func WithComplementProxyPool(f ComplementProxyPool) CrawlerOption {
 // 使用缓存时，如果发出的是 POST 请求，最好传入能
 // 代表请求体的唯一性的缓存字段，可以是零个、一个或多个。
 //
+// cacheCondition 为 nil 时只缓存状态码为 20X 的响应，也可以根据响应体决定
+// 是否缓存，见 CacheCondition。
+//
+// 实现了 Pinger 的缓存会在初始化后检查能否连接，不能连接时会 panic。
+//
 // 注意：当不传入缓存字段时，将会默认采用整个请求体作为
 // 缓存标识，但由于 map 无序，同一个请求体生成的 key 很
 // 难保证相同，所以可能会有同一个请求缓存多次，或者无法
@@ -140,10 +394,13 @@ func WithCache(cc Cache, compressed bool, cacheCondition CacheCondition, cacheFi
 		if err != nil {
 			panic(err)
 		}
+		if err = pingCache(cc); err != nil {
+			panic(err)
+		}
 		c.cache = cc
 		if cacheCondition == nil {
 			cacheCondition = func(r *Response) bool {
-				return r.StatusCode/100 == 2
+				return r.IsSuccess()
 			}
 		}
 		c.cacheCondition = cacheCondition
@@ -153,6 +410,219 @@ func WithCache(cc Cache, compressed bool, cacheCondition CacheCondition, cacheFi
 	}
 }
 
+// WithDisableKeepAlive closes the connection after each request instead of
+// keeping it alive for reuse. The connection is always closed when a proxy
+// pool is used, so that each request can use a new proxy.
+func WithDisableKeepAlive() CrawlerOption {
+	return func(c *Crawler) {
+		c.disableKeepAlive = true
+	}
+}
+
+// WithTracing records the timing of each request, which can be got by
+// `Response.Timing`, such as to find out the slow proxies.
+func WithTracing() CrawlerOption {
+	return func(c *Crawler) {
+		c.tracing = true
+	}
+}
+
+// WithLenientHTMLDetection sniffs the body of the responses whose
+// "Content-Type" is "text/plain" or missing, so that the html handlers also
+// run for the html pages served by the misconfigured servers. A body is
+// treated as html if it starts with "<!doctype html" or contains "<html" in
+// the first 512 bytes.
+//
+// Only the responses with an html "Content-Type" are handled by default.
+func WithLenientHTMLDetection() CrawlerOption {
+	return func(c *Crawler) {
+		c.lenientHTML = true
+	}
+}
+
+// WithMaxConnsPerHost limits the number of connections to each host, including
+// the idle ones kept alive for reuse. The default is 512.
+func WithMaxConnsPerHost(n int) CrawlerOption {
+	return func(c *Crawler) {
+		c.client.MaxConnsPerHost = n
+	}
+}
+
+// WithMaxIdleConnDuration closes the idle keep-alive connections after `d`.
+// The default is 10 seconds.
+func WithMaxIdleConnDuration(d time.Duration) CrawlerOption {
+	return func(c *Crawler) {
+		c.client.MaxIdleConnDuration = d
+	}
+}
+
+// WithDialFunc uses `dial` to establish the connections to the hosts, such
+// as to pin a hostname to a specific CDN node.
+//
+// It is not used when a proxy is used, and `EnableIPv6` doesn't take effect
+// with a custom dial function.
+func WithDialFunc(dial fasthttp.DialFunc) CrawlerOption {
+	return func(c *Crawler) {
+		c.client.Dial = dial
+	}
+}
+
+// WithHTTPClient sends the requests with `client` instead of a new client
+// created by the crawler, such as to share a tuned client between crawlers.
+//
+// It should be the first option, because the options configuring the client,
+// such as `SkipVerification` and `WithMaxBodySize`, modify the given client.
+// The proxies are dialed with a separate client copied from the settings of
+// the given client, so the given client is never modified by the requests.
+func WithHTTPClient(client *fasthttp.Client) CrawlerOption {
+	return func(c *Crawler) {
+		c.client = client
+	}
+}
+
+// WithTransport sends the requests and receives the responses with
+// `transport` instead of the connections made by the crawler, such as to
+// mock the responses in the tests or to add a middleware.
+//
+// The proxies and the dial function don't take effect with a custom
+// transport, which should handle them itself if needed.
+func WithTransport(transport fasthttp.TransportFunc) CrawlerOption {
+	return func(c *Crawler) {
+		c.client.ConfigureClient = func(hc *fasthttp.HostClient) error {
+			hc.Transport = transport
+			return nil
+		}
+	}
+}
+
+// WithResolver resolves the hostnames with `resolver`, such as a
+// `*net.Resolver` using a specific DNS server. It doesn't take effect with a
+// custom dial function, see `WithDialFunc`.
+func WithResolver(resolver fasthttp.Resolver) CrawlerOption {
+	return func(c *Crawler) {
+		c.resolver = resolver
+	}
+}
+
+// WithMaxDNSConcurrency limits the number of the concurrent DNS lookups to
+// `n`, so that the wide crawls across many hosts don't exhaust the file
+// descriptors with the lookups. There is no limit by default.
+//
+// The resolved addresses are cached for a minute by fasthttp, so only the
+// lookups of the new hosts are limited. It works with `WithResolver`, but
+// doesn't take effect with a custom dial function, see `WithDialFunc`, or
+// when a proxy is used.
+func WithMaxDNSConcurrency(n int) CrawlerOption {
+	return func(c *Crawler) {
+		c.maxDNSConcurrency = n
+	}
+}
+
+// WithDialTimeout limits the time of establishing a connection to the host,
+// or to the proxy when a proxy is used, to `timeout`, so that the
+// unreachable hosts and proxies fail fast while the slow downloads are
+// still limited only by the timeout of the request. The default dial
+// timeout of fasthttp is 3 seconds.
+//
+// It doesn't take effect with a custom dial function, see `WithDialFunc`.
+func WithDialTimeout(timeout time.Duration) CrawlerOption {
+	return func(c *Crawler) {
+		c.dialTimeout = timeout
+	}
+}
+
+// WithTLSHandshakeTimeout limits the time of the TLS handshake with the
+// https hosts to `timeout`. Without it, fasthttp limits the handshake with
+// the write timeout of the client, if any.
+func WithTLSHandshakeTimeout(timeout time.Duration) CrawlerOption {
+	return func(c *Crawler) {
+		c.tlsTimeout = timeout
+	}
+}
+
+// WithResponseHeaderTimeout limits the time waiting for the response after
+// the request is written to `timeout`, while reading the rest of the
+// response is not limited by it.
+//
+// fasthttp reads the headers and the body of the response in one go, so
+// the time is counted until the first byte of the response rather than
+// the end of the headers. Like the other read errors, the timed out GET and
+// HEAD requests are retried by fasthttp on new connections before the
+// retries of the crawler, see `WithRetry`.
+func WithResponseHeaderTimeout(timeout time.Duration) CrawlerOption {
+	return func(c *Crawler) {
+		c.headerTimeout = timeout
+	}
+}
+
+// WithCacheIgnoreParams excludes the volatile query parameters, such as
+// timestamps and tracking parameters, from the cache key, so that the
+// requests differing only in these parameters share the same cache.
+func WithCacheIgnoreParams(params ...string) CrawlerOption {
+	return func(c *Crawler) {
+		c.cacheIgnoreParams = params
+	}
+}
+
+// WithMaxBodySize limits the size of the response bodies to `n` bytes,
+// which prevents the misbehaving servers from exhausting the memory with
+// enormous bodies. ErrBodyTooLarge is returned by the request if the limit
+// is exceeded. There is no limit by default.
+func WithMaxBodySize(n int) CrawlerOption {
+	return func(c *Crawler) {
+		c.client.MaxResponseBodySize = n
+	}
+}
+
+// WithPerHostConcurrency limits the number of in-flight requests to each
+// host to `n`, so that a slow host can't occupy all the workers of the
+// goroutine pool. It bounds the parallelism instead of the rate of requests.
+//
+// The slot of a host is held only while the request is in flight, the
+// handlers of the response are not counted. A worker of the goroutine pool
+// doesn't wait for a slot: the request to a busy host is put aside and the
+// worker moves on to the next request. The request put aside is resumed by
+// the worker releasing a slot of the host after its current request.
+func WithPerHostConcurrency(n int) CrawlerOption {
+	return func(c *Crawler) {
+		c.perHostConcurrency = n
+	}
+}
+
+// WithFollowMetaRefresh follows the `<meta http-equiv="refresh">` tags and
+// the common js redirects of the html responses, such as the landing stubs,
+// by issuing chained GET requests to the targets with the headers and the
+// contexts of the original requests.
+//
+// The html handlers are still called with the stub pages, and at most 5
+// redirects are followed in a chain to avoid the redirect loops.
+func WithFollowMetaRefresh(follow bool) CrawlerOption {
+	return func(c *Crawler) {
+		c.followMetaRefresh = follow
+	}
+}
+
+// WithURLNormalizer normalizes the urls of the requests with `normalizer`,
+// such as `NormalizeURL`, before hashing and sending them, so that the
+// equivalent urls share the same cache. There is no normalizer by default.
+func WithURLNormalizer(normalizer URLNormalizer) CrawlerOption {
+	return func(c *Crawler) {
+		c.urlNormalizer = normalizer
+	}
+}
+
+// WithContextOp sets the type of the contexts acquired by the crawler for
+// the requests without a context, the default is `pctx.WriteOp`.
+//
+// `pctx.ReadOp` is based on `sync.Map`, which is faster when the handlers
+// of a highly concurrent crawler mostly read the contexts, but is slower
+// than `pctx.WriteOp` when the contexts are written as often as they are read.
+func WithContextOp(op pctx.CtxOp) CrawlerOption {
+	return func(c *Crawler) {
+		c.ctxOp = op
+	}
+}
+
 func EnableIPv6() CrawlerOption {
 	return func(c *Crawler) {
 		c.client.DialDualStack = true
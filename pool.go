@@ -9,8 +9,10 @@
 package predator
 
 import (
+	"container/heap"
 	"errors"
 	"fmt"
+	"runtime"
 	"runtime/debug"
 	"sync"
 	"sync/atomic"
@@ -40,6 +42,36 @@ type Task struct {
 	crawler   *Crawler
 	req       *Request
 	isChained bool
+	// 入队顺序，优先级相同时先入队的任务先执行
+	seq uint64
+}
+
+// before reports whether the task should be executed before `other`
+func (t *Task) before(other *Task) bool {
+	if t.req.priority != other.req.priority {
+		return t.req.priority > other.req.priority
+	}
+	return t.seq < other.seq
+}
+
+// taskQueue is a priority queue of tasks, see `Task.before`
+type taskQueue []*Task
+
+func (q taskQueue) Len() int           { return len(q) }
+func (q taskQueue) Less(i, j int) bool { return q[i].before(q[j]) }
+func (q taskQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *taskQueue) Push(x any) {
+	*q = append(*q, x.(*Task))
+}
+
+func (q *taskQueue) Pop() any {
+	old := *q
+	n := len(old)
+	task := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return task
 }
 
 // Pool task pool
@@ -47,9 +79,16 @@ type Pool struct {
 	capacity       uint64
 	runningWorkers uint64
 	status         int64
-	chTask         chan *Task
-	log            *log.Logger
-	blockPanic     bool
+	// 每个信号对应队列中的一个任务，工作协程收到信号后从队列中取出优先级最高的任务
+	chTask chan struct{}
+	queue  taskQueue
+	seq    uint64
+	// 队列的锁，Put 发送信号时会持有 Pool 的锁，所以队列要单独加锁
+	queueLock  sync.Mutex
+	log        *log.Logger
+	blockPanic bool
+	// 正在停止时拒绝新任务，否则不断加入的任务会让 Stop 一直无法获得锁
+	stopping int32
 	sync.Mutex
 }
 
@@ -61,7 +100,7 @@ func NewPool(capacity uint64) (*Pool, error) {
 	p := &Pool{
 		capacity: capacity,
 		status:   RUNNING,
-		chTask:   make(chan *Task, capacity),
+		chTask:   make(chan struct{}, capacity),
 	}
 
 	return p, nil
@@ -71,7 +110,7 @@ func (p *Pool) checkWorker() {
 	p.Lock()
 	defer p.Unlock()
 
-	if p.runningWorkers == 0 && len(p.chTask) > 0 {
+	if p.GetRunningWorkers() == 0 && len(p.chTask) > 0 {
 		p.run()
 	}
 }
@@ -96,6 +135,10 @@ func (p *Pool) decRunning() {
 
 // Put put a task to pool
 func (p *Pool) Put(task *Task) error {
+	if atomic.LoadInt32(&p.stopping) == 1 {
+		return ErrPoolAlreadyClosed
+	}
+
 	p.Lock()
 	defer p.Unlock()
 
@@ -110,7 +153,8 @@ func (p *Pool) Put(task *Task) error {
 
 	// send task
 	if p.status == RUNNING {
-		p.chTask <- task
+		p.push(task)
+		p.chTask <- struct{}{}
 	}
 
 	return nil
@@ -128,7 +172,9 @@ func (p *Pool) run() {
 					// 打印panic的堆栈信息
 					debug.PrintStack()
 
-					p.log.Error(fmt.Errorf("worker panic: %s", r))
+					if p.log != nil {
+						p.log.Error(fmt.Errorf("worker panic: %s", r))
+					}
 				} else {
 					// panic 只允许 error 类型
 					if e, ok := r.(error); ok {
@@ -142,13 +188,53 @@ func (p *Pool) run() {
 			p.checkWorker() // check worker avoid no worker running
 		}()
 
-		for task := range p.chTask {
+		for range p.chTask {
+			task := p.pop()
 			task.crawler.prepare(task.req, task.isChained)
+			// 执行本任务归还名额后可以继续的任务
+			task.crawler.runReadyTasks()
 		}
 	}()
 
 }
 
+func (p *Pool) push(task *Task) {
+	p.queueLock.Lock()
+	defer p.queueLock.Unlock()
+
+	p.seq++
+	task.seq = p.seq
+	heap.Push(&p.queue, task)
+}
+
+func (p *Pool) pop() *Task {
+	p.queueLock.Lock()
+	defer p.queueLock.Unlock()
+
+	return heap.Pop(&p.queue).(*Task)
+}
+
+// requeue puts the task back and returns the queued task with a higher
+// priority, or returns nil without requeuing the task if there is no such
+// task.
+//
+// The number of the tasks in the queue is not changed, so there is no
+// need to send a signal.
+func (p *Pool) requeue(task *Task) *Task {
+	p.queueLock.Lock()
+	defer p.queueLock.Unlock()
+
+	if len(p.queue) == 0 || p.queue[0].req.priority <= task.req.priority {
+		return nil
+	}
+
+	// 保持原来的入队顺序
+	next := p.queue[0]
+	p.queue[0] = task
+	heap.Fix(&p.queue, 0)
+	return next
+}
+
 func (p *Pool) setStatus(status int64) bool {
 	p.Lock()
 	defer p.Unlock()
@@ -175,3 +261,37 @@ func (p *Pool) Close() {
 
 	close(p.chTask)
 }
+
+// Stop stops the pool immediately without waiting for the queued tasks,
+// and returns the tasks that are discarded
+func (p *Pool) Stop() []*Task {
+	var discarded []*Task
+
+	atomic.StoreInt32(&p.stopping, 1)
+
+	// Put 在通道已满时会持有锁阻塞，需要先取出任务才能获得锁
+	for !p.TryLock() {
+		select {
+		case <-p.chTask:
+			discarded = append(discarded, p.pop())
+		default:
+			runtime.Gosched()
+		}
+	}
+	defer p.Unlock()
+
+	if p.status == STOPED {
+		return discarded
+	}
+	p.status = STOPED
+
+	for {
+		select {
+		case <-p.chTask:
+			discarded = append(discarded, p.pop())
+		default:
+			close(p.chTask)
+			return discarded
+		}
+	}
+}
@@ -29,13 +29,7 @@ func (c *Crawler) ProxyDialerWithTimeout(proxyAddr string, timeout time.Duration
 	} else if proxyAddr[:9] == "socks5://" {
 		return proxy.Socks5ProxyDialer(proxyAddr)
 	} else {
-		err := proxy.ProxyErr{
-			Code: proxy.ErrUnknownProtocolCode,
-			Args: map[string]string{
-				"proxy_addr": proxyAddr,
-			},
-			Msg: "only support http and socks5 protocol, but the incoming proxy address uses an unknown protocol",
-		}
+		err := proxy.UnexpectedProtocol(proxyAddr)
 		if c.log != nil {
 			c.Fatal(err, log.Arg{Key: "proxy", Value: proxyAddr})
 		} else {
@@ -9,9 +9,8 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
-	"regexp"
-	"sort"
 	"strings"
 )
 
@@ -33,69 +32,68 @@ func (ec ErrCode) String() string {
 	return fmt.Sprintf("proxy error [ %d ]", ec)
 }
 
+// ProxyErr records an error that occurred while using a proxy, the
+// address of the proxy and the code of the error.
 type ProxyErr struct {
 	Code ErrCode
-	Args map[string]string
-	Msg  string
+	// Proxy is the address of the proxy, without the scheme
+	Proxy string
+	Err   error
+}
+
+// NewProxyError returns a ProxyErr with the code, proxy address and the
+// wrapped error.
+func NewProxyError(code ErrCode, proxyAddr string, err error) ProxyErr {
+	return ProxyErr{
+		Code:  code,
+		Proxy: proxyAddr,
+		Err:   err,
+	}
+}
+
+// UnexpectedProtocol returns an error indicating that the proxy address
+// uses a protocol other than http(s) and socks5.
+func UnexpectedProtocol(proxyAddr string) ProxyErr {
+	return NewProxyError(
+		ErrUnknownProtocolCode,
+		proxyAddr,
+		errors.New("only support http and socks5 protocol, but the incoming proxy address uses an unknown protocol"),
+	)
 }
 
 func (pe ProxyErr) Error() string {
 	var s strings.Builder
 	s.WriteString(pe.Code.String())
 
-	if len(pe.Msg) > 0 || len(pe.Args) > 0 {
-		s.WriteByte(' ')
-	}
-
-	if pe.Msg != "" {
-		s.WriteString("err=")
-		s.WriteString(pe.Msg)
-		s.WriteByte(',')
-		s.WriteByte(' ')
-	}
-
-	keys := make([]string, 0, len(pe.Args))
-	for k := range pe.Args {
-		keys = append(keys, k)
+	if pe.Proxy != "" {
+		s.WriteString(" proxy=")
+		s.WriteString(pe.Proxy)
 	}
-	sort.Strings(keys)
 
-	for i, k := range keys {
-		if i > 0 {
+	if pe.Err != nil {
+		if pe.Proxy != "" {
 			s.WriteByte(',')
-			s.WriteByte(' ')
 		}
-		s.WriteString(k)
-		s.WriteByte('=')
-		s.WriteString(pe.Args[k])
+		s.WriteString(" err=")
+		s.WriteString(pe.Err.Error())
 	}
 
 	return s.String()
 }
 
+func (pe ProxyErr) Unwrap() error {
+	return pe.Err
+}
+
+// IsProxyError determines whether the error is caused by an invalid proxy,
+// and returns the address of the invalid proxy if so.
 func IsProxyError(err error) (string, bool) {
-	if e, ok := err.(ProxyErr); ok {
+	var e ProxyErr
+	if errors.As(err, &e) {
 		switch e.Code {
 		case ErrProxyExpiredCode, ErrUnableToConnectCode, ErrInvalidProxyCode:
-			return e.Args["proxy"], true
+			return e.Proxy, true
 		}
-		return "", false
-	}
-
-	if len(err.Error()) < 26 {
-		return "", false
-	}
-
-	// http proxy expired or invalid
-	if err.Error()[:26] == "cannot connect to proxy ip" {
-		re := regexp.MustCompile(`cannot connect to proxy ip \[ (.+?) \] -> .+?`)
-		return re.FindAllStringSubmatch(err.Error(), 1)[0][1], true
-	}
-
-	// socks5 proxy expired or invalid
-	if err.Error()[:17] == "socks connect tcp" {
-		re := regexp.MustCompile("socks connect tcp (.+?)->.+?")
-		return re.FindAllStringSubmatch(err.Error(), 1)[0][1], true
 	}
 
 	return "", false
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// closedAddr returns an address on which nothing is listening
+func closedAddr() string {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// rejectingProxy returns the address of an http proxy that rejects
+// all CONNECT requests with the status code 407
+func rejectingProxy() (string, func()) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 1024)
+			conn.Read(buf)
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestProxyErr(t *testing.T) {
+	Convey("测试代理错误", t, func() {
+		Convey("错误信息", func() {
+			err := NewProxyError(ErrInvalidProxyCode, "127.0.0.1:8080", errors.New("invalid"))
+			So(err.Error(), ShouldEqual, "proxy error [ 8 ] proxy=127.0.0.1:8080, err=invalid")
+
+			err = NewProxyError(ErrEmptyProxyPoolCode, "", errors.New("empty"))
+			So(err.Error(), ShouldEqual, "proxy error [ 6 ] err=empty")
+		})
+
+		Convey("未知协议", func() {
+			err := UnexpectedProtocol("ftp://127.0.0.1:21")
+			So(err.Code, ShouldEqual, ErrUnknownProtocolCode)
+			So(err.Proxy, ShouldEqual, "ftp://127.0.0.1:21")
+
+			_, ok := IsProxyError(err)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("被包装的代理错误", func() {
+			inner := errors.New("connection refused")
+			err := fmt.Errorf("request failed: %w", NewProxyError(ErrUnableToConnectCode, "127.0.0.1:8080", inner))
+
+			p, ok := IsProxyError(err)
+			So(ok, ShouldBeTrue)
+			So(p, ShouldEqual, "127.0.0.1:8080")
+			So(errors.Is(err, inner), ShouldBeTrue)
+		})
+
+		Convey("非代理错误", func() {
+			_, ok := IsProxyError(errors.New("cannot connect to proxy ip"))
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestDialerErrors(t *testing.T) {
+	Convey("测试代理拨号错误", t, func() {
+		Convey("无法连接 http 代理", func() {
+			addr := closedAddr()
+			_, err := HttpProxyDialerWithTimeout("http://"+addr, 0)("example.com:80")
+
+			p, ok := IsProxyError(err)
+			So(ok, ShouldBeTrue)
+			So(p, ShouldEqual, addr)
+		})
+
+		Convey("http 代理拒绝 CONNECT", func() {
+			addr, closeProxy := rejectingProxy()
+			defer closeProxy()
+
+			_, err := HttpProxyDialerWithTimeout("http://"+addr, 0)("example.com:80")
+
+			var pe ProxyErr
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Code, ShouldEqual, ErrUnableToConnectCode)
+			So(pe.Proxy, ShouldEqual, addr)
+			So(pe.Err.Error(), ShouldContainSubstring, "407")
+		})
+
+		Convey("socks5 代理地址为空", func() {
+			_, err := Socks5ProxyDialer("")("example.com:80")
+
+			var pe ProxyErr
+			So(errors.As(err, &pe), ShouldBeTrue)
+			So(pe.Code, ShouldEqual, ErrIPOrPortIsNullCode)
+
+			_, ok := IsProxyError(err)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("无法连接 socks5 代理", func() {
+			addr := closedAddr()
+			_, err := Socks5ProxyDialer("socks5://" + addr)("example.com:80")
+
+			p, ok := IsProxyError(err)
+			So(ok, ShouldBeTrue)
+			So(p, ShouldEqual, addr)
+		})
+	})
+}
@@ -11,8 +11,8 @@ package proxy
 import (
 	"bufio"
 	"encoding/base64"
+	"fmt"
 	"net"
-	"strconv"
 	"strings"
 	"time"
 
@@ -37,14 +37,7 @@ func HttpProxyDialerWithTimeout(proxyAddr string, timeout time.Duration) fasthtt
 			conn, err = fasthttp.DialTimeout(pAddr, timeout)
 		}
 		if err != nil {
-			return nil, ProxyErr{
-				Code: ErrUnableToConnectCode,
-				Args: map[string]string{
-					"proxy": pAddr,
-					"error": err.Error(),
-				},
-				Msg: "cannot connect to proxy",
-			}
+			return nil, NewProxyError(ErrUnableToConnectCode, pAddr, err)
 		}
 
 		req := "CONNECT " + addr + " HTTP/1.1\r\n"
@@ -54,7 +47,8 @@ func HttpProxyDialerWithTimeout(proxyAddr string, timeout time.Duration) fasthtt
 		req += "\r\n"
 
 		if _, err := conn.Write([]byte(req)); err != nil {
-			return nil, err
+			conn.Close()
+			return nil, NewProxyError(ErrUnableToConnectCode, pAddr, err)
 		}
 
 		res := fasthttp.AcquireResponse()
@@ -64,18 +58,15 @@ func HttpProxyDialerWithTimeout(proxyAddr string, timeout time.Duration) fasthtt
 
 		if err := res.Read(bufio.NewReader(conn)); err != nil {
 			conn.Close()
-			return nil, err
+			return nil, NewProxyError(ErrUnableToConnectCode, pAddr, err)
 		}
 		if res.Header.StatusCode() != 200 {
 			conn.Close()
-			return nil, ProxyErr{
-				Code: ErrUnableToConnectCode,
-				Args: map[string]string{
-					"proxy":       pAddr,
-					"status_code": strconv.Itoa(res.Header.StatusCode()),
-				},
-				Msg: "cannot connect to proxy",
-			}
+			return nil, NewProxyError(
+				ErrUnableToConnectCode,
+				pAddr,
+				fmt.Errorf("unexpected status code of CONNECT: %d", res.Header.StatusCode()),
+			)
 		}
 		return conn, nil
 	}
@@ -9,6 +9,7 @@
 package proxy
 
 import (
+	"errors"
 	"net"
 	"net/url"
 
@@ -24,15 +25,14 @@ func Socks5ProxyDialer(proxyAddr string) fasthttp.DialFunc {
 	)
 
 	if proxyAddr == "" {
-		err = ProxyErr{
-			Code: ErrInvalidProxyCode,
-			Args: map[string]string{"addr": proxyAddr},
-			Msg:  "ip and port cannot be empty",
-		}
+		err = NewProxyError(ErrIPOrPortIsNullCode, proxyAddr, errors.New("ip and port cannot be empty"))
 	} else {
 		if u, err = url.Parse(proxyAddr); err == nil {
 			dialer, err = netProxy.FromURL(u, netProxy.Direct)
 		}
+		if err != nil {
+			err = NewProxyError(ErrWrongFormatCode, proxyAddr, err)
+		}
 	}
 
 	// It would be nice if we could return the error here. But we can't
@@ -44,6 +44,10 @@ func Socks5ProxyDialer(proxyAddr string) fasthttp.DialFunc {
 		if err != nil {
 			return nil, err
 		}
-		return dialer.Dial("tcp", addr)
+		conn, e := dialer.Dial("tcp", addr)
+		if e != nil {
+			return nil, NewProxyError(ErrUnableToConnectCode, u.Host, e)
+		}
+		return conn, nil
 	}
 }
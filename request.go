@@ -11,7 +11,10 @@ package predator
 import (
 	"bytes"
 	"crypto/sha1"
+	"encoding/base64"
 	"fmt"
+	"io"
+	"mime"
 	"time"
 
 	"net/http"
@@ -21,6 +24,8 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 
 	pctx "github.com/go-predator/predator/context"
 	"github.com/go-predator/predator/json"
@@ -33,6 +38,8 @@ type Request struct {
 	Headers *fasthttp.RequestHeader
 	// 请求和响应之间共享的上下文
 	Ctx pctx.Context
+	// 上下文是否由爬虫自己申请，只有这样的上下文才会在请求结束后被释放
+	ownCtx bool
 	// 请求体
 	Body []byte
 	// 待缓存的键值对
@@ -50,6 +57,61 @@ type Request struct {
 	// 重定向次数会影响爬虫效率。
 	maxRedirectsCount uint
 	timeout           time.Duration
+	// 请求体的压缩算法，只在发送时压缩，缓存键仍由原始请求体生成
+	compression string
+	// 在协程池中的优先级，数值越大越先执行
+	priority int
+	// 是否已经执行过请求处理函数
+	handled bool
+	// 链式请求中已经跟随的 meta refresh 次数
+	metaRefreshCount int
+	// 请求完成时的回调，见 Crawler.GetAll
+	done func(error)
+	// 处理 html 和 json 前对最终响应的回调，见 Crawler.GetSync
+	capture func(*Response)
+	// 第一次发出请求的时间，用于限制重试的总时长
+	firstAttempt time.Time
+	// 不设置默认的 Content-Type，见 Crawler.PostRaw
+	noContentType bool
+	// 工作协程为请求预留的主机名额，见 WithPerHostConcurrency
+	hostSlot *hostSlot
+	// 网络错误时返回错误而不是退出程序，见 Crawler.fetch
+	returnNetErr bool
+}
+
+// clone returns a deep copy of the request with the context `ctx`, which
+// doesn't own the context and the callback of the request.
+func (r *Request) clone(ctx pctx.Context) *Request {
+	nr := AcquireRequest()
+
+	nr.uri = fasthttp.AcquireURI()
+	r.uri.CopyTo(nr.uri)
+	nr.Headers = AcquireRequestHeader()
+	r.Headers.CopyTo(nr.Headers)
+	nr.Ctx = ctx
+	nr.Body = append(nr.Body[:0], r.Body...)
+	if r.cachedMap != nil {
+		if nr.cachedMap == nil {
+			nr.cachedMap = make(map[string]string, len(r.cachedMap))
+		}
+		for k, v := range r.cachedMap {
+			nr.cachedMap[k] = v
+		}
+	}
+	nr.ID = r.ID
+	nr.abort = r.abort
+	nr.crawler = r.crawler
+	nr.retryCounter = atomic.LoadUint32(&r.retryCounter)
+	nr.maxRedirectsCount = r.maxRedirectsCount
+	nr.timeout = r.timeout
+	nr.compression = r.compression
+	nr.priority = r.priority
+	nr.handled = r.handled
+	nr.metaRefreshCount = r.metaRefreshCount
+	nr.firstAttempt = r.firstAttempt
+	nr.noContentType = r.noContentType
+
+	return nr
 }
 
 func (r Request) IsCached() (bool, error) {
@@ -74,6 +136,35 @@ func (r *Request) SetContentType(contentType string) {
 	r.Headers.Set("Content-Type", contentType)
 }
 
+// CompressBody compresses the body with `algo` when the request is sent
+// and sets the "Content-Encoding" header, which saves bandwidth for large
+// bodies. `algo` can be "gzip" or "deflate".
+//
+// The cache key is still generated from the uncompressed body.
+func (r *Request) CompressBody(algo string) error {
+	switch algo {
+	case "gzip", "deflate":
+		r.compression = algo
+		return nil
+	default:
+		return ErrUnsupportedCompression
+	}
+}
+
+// SetPriority sets the priority of the request in the goroutine pool,
+// which is 0 by default, and it only takes effect in `BeforeRequest`
+// handlers with the goroutine pool.
+//
+// The priority of a request is unknown until its `BeforeRequest` handlers
+// are executed, so the queued requests whose handlers haven't been executed
+// are treated as 0. After the handlers, the request yields to the queued
+// request with the highest priority if it's higher, and will be executed
+// later. In other words, lower the priorities of the requests that can
+// wait, such as the listing pages, so that the others go first.
+func (r *Request) SetPriority(priority int) {
+	r.priority = priority
+}
+
 // AllowRedirect allows up to `maxRedirectsCount` times to be redirected.
 func (r *Request) AllowRedirect(maxRedirectsCount uint) {
 	r.maxRedirectsCount = maxRedirectsCount
@@ -82,6 +173,9 @@ func (r *Request) AllowRedirect(maxRedirectsCount uint) {
 // SetTimeout sets the waiting time for each request before
 // the remote end returns a response.
 //
+// The timeout only applies to this request, the client shared by the
+// concurrent requests is not modified.
+//
 // The function doesn't follow redirects.
 func (r *Request) SetTimeout(t time.Duration) {
 	r.timeout = t
@@ -93,6 +187,72 @@ func (r *Request) SetHeaders(headers map[string]string) {
 	}
 }
 
+// SetQueryParam sets the query parameter `key` of the request URL to
+// `value`, replacing the existing values.
+//
+// If `key` is one of the query parameter cache fields, the value used
+// to generate the cache key will be updated as well.
+func (r *Request) SetQueryParam(key, value string) {
+	r.uri.QueryArgs().Set(key, value)
+
+	cacheKey := NewQueryParamField(key).String()
+	if _, ok := r.cachedMap[cacheKey]; ok {
+		r.cachedMap[cacheKey] = value
+	}
+}
+
+// SetQueryParams sets multiple query parameters of the request URL,
+// see `SetQueryParam`.
+func (r *Request) SetQueryParams(params map[string]string) {
+	for k, v := range params {
+		r.SetQueryParam(k, v)
+	}
+}
+
+// SetBody replaces the body of the request with `body`, such as to modify
+// the body in the `BeforeRequest` handlers.
+//
+// The body is set when the request is created, such as by `Crawler.Post`,
+// before the `BeforeRequest` handlers, and the handlers run before the
+// cache key is generated, the body is compressed, see `CompressBody`, and
+// the request is signed, see `WithRequestSigner`. So the cache key is
+// generated from the new body. If the body parameters are the cache fields,
+// their values are looked up again in the new body when it is an url-encoded
+// form or a JSON, and set to empty strings if they are missing.
+func (r *Request) SetBody(body []byte) {
+	r.Body = body
+
+	prefix := NewRequestBodyParamField("").String()
+	contentType := string(r.Headers.ContentType())
+
+	var (
+		form     url.Values
+		bodyJSON *json.JSONResult
+	)
+	for key := range r.cachedMap {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		field := key[len(prefix):]
+
+		switch {
+		case strings.Contains(contentType, "json"):
+			if bodyJSON == nil {
+				result := json.ParseBytesToJSON(body)
+				bodyJSON = &result
+			}
+			r.cachedMap[key] = bodyJSON.Get(field).String()
+		case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+			if form == nil {
+				// 解析出错时忽略无法解析的部分
+				form, _ = url.ParseQuery(string(body))
+			}
+			// 重复的字段的所有值都作为缓存标志
+			r.cachedMap[key] = strings.Join(form[field], ",")
+		}
+	}
+}
+
 func (r *Request) SetNewHeaders(headers map[string]string, disableNormalizing bool) {
 	r.Headers.Reset()
 
@@ -121,24 +281,31 @@ func (r Request) Method() string {
 	return string(r.Headers.Method())
 }
 
+// NumberOfRetries returns how many times the request has been retried, see
+// `Response.RetriesExhausted` to check whether the retries are exhausted.
 func (r Request) NumberOfRetries() uint32 {
 	return r.retryCounter
 }
 
+// Get sends a chained GET request, and the following chained requests are
+// the same. The context of the chained request is a copy of the context of
+// this request, so the sibling requests running concurrently don't affect
+// each other, and the changes made by the chained request aren't visible
+// to this request.
 func (r Request) Get(u string) error {
 	return r.Request(MethodGet, u, nil, nil)
 }
 
 func (r Request) GetWithCache(URL string, cacheFields ...CacheField) error {
-	return r.crawler.get(URL, r.headers(), r.Ctx, true, cacheFields...)
+	return r.crawler.get(URL, r.headers(), r.Ctx, true, nil, cacheFields...)
 }
 
 func (r Request) Post(URL string, requestData map[string]string) error {
-	return r.crawler.post(URL, requestData, r.headers(), r.Ctx, true)
+	return r.crawler.post(URL, requestData, r.headers(), r.Ctx, true, nil)
 }
 
 func (r Request) PostWithCache(URL string, requestData map[string]string, cacheFields ...CacheField) error {
-	return r.crawler.post(URL, requestData, r.headers(), r.Ctx, true, cacheFields...)
+	return r.crawler.post(URL, requestData, r.headers(), r.Ctx, true, nil, cacheFields...)
 }
 func (r Request) PostJSON(URL string, requestData map[string]any) error {
 	return r.crawler.postJSON(URL, requestData, r.headers(), r.Ctx, true)
@@ -147,6 +314,14 @@ func (r Request) PostJSON(URL string, requestData map[string]any) error {
 func (r Request) PostJSONWithCache(URL string, requestData map[string]any, cacheFields ...CacheField) error {
 	return r.crawler.postJSON(URL, requestData, r.headers(), r.Ctx, true, cacheFields...)
 }
+func (r Request) PostJSONBody(URL string, v any) error {
+	return r.crawler.postJSON(URL, v, r.headers(), r.Ctx, true)
+}
+
+func (r Request) PostJSONBodyWithCache(URL string, v any, cacheFields ...CacheField) error {
+	return r.crawler.postJSON(URL, v, r.headers(), r.Ctx, true, cacheFields...)
+}
+
 func (r Request) PostMultipart(URL string, form *MultipartForm) error {
 	return r.crawler.postMultipart(URL, form, r.headers(), r.Ctx, true)
 }
@@ -156,7 +331,37 @@ func (r Request) PostMultipartWithCache(URL string, form *MultipartForm, cacheFi
 }
 
 func (r Request) Request(method, URL string, cachedMap map[string]string, body []byte) error {
-	return r.crawler.request(method, URL, body, cachedMap, r.Headers, r.Ctx, true)
+	// 请求头会随请求一起被释放，子请求不能与本请求共用
+	headers := AcquireRequestHeader()
+	r.Headers.CopyTo(headers)
+	return r.crawler.request(method, URL, body, cachedMap, headers, r.Ctx, true)
+}
+
+// Spawn returns a new request for sending chained requests, whose headers,
+// including the cookies, are copied from this request, but whose context is
+// a new empty one, so the children spawned from the same request can be used
+// concurrently without sharing any state.
+//
+// The spawned request is not sent, it is only used as the parent of the
+// chained requests, and should be released with `ReleaseRequest` after the
+// chained requests are sent, which also releases its context.
+func (r *Request) Spawn() *Request {
+	// 只传入一个 op 时不会返回错误
+	ctx, _ := pctx.AcquireCtx(r.crawler.ctxOp)
+
+	nr := r.clone(ctx)
+	nr.Body = nr.Body[:0]
+	for k := range nr.cachedMap {
+		delete(nr.cachedMap, k)
+	}
+	nr.abort = false
+	nr.retryCounter = 0
+	nr.handled = false
+	nr.metaRefreshCount = 0
+	nr.firstAttempt = time.Time{}
+	nr.ownCtx = true
+
+	return nr
 }
 
 // AbsoluteURL returns with the resolved absolute URL of an URL chunk.
@@ -188,7 +393,8 @@ type cacheRequest struct {
 	URL string
 	// 请求方法
 	Method string
-	// 待缓存的 map
+	// 待缓存的 map，没有缓存字段时是原始请求体。
+	// []byte 会被编码为 base64，二进制的请求体也不会因为无效的 utf-8 而冲突
 	CacheKey []byte
 }
 
@@ -218,9 +424,44 @@ func marshalCachedMap(cachedMap map[string]string) []byte {
 	return b.Bytes()
 }
 
+// cacheURL returns the URL used to generate the cache key, whose query
+// parameters are sorted and deduplicated, and the parameters ignored by
+// `WithCacheIgnoreParams` are removed, so that the same request with
+// reordered or volatile parameters hits the same cache.
+func (r Request) cacheURL() string {
+	u, err := url.Parse(r.URL())
+	if err != nil || u.RawQuery == "" {
+		return r.URL()
+	}
+
+	params := u.Query()
+	if r.crawler != nil {
+		for _, p := range r.crawler.cacheIgnoreParams {
+			params.Del(p)
+		}
+	}
+
+	for k, values := range params {
+		// 同名参数的顺序可能有意义，只去除完全重复的值
+		deduplicated := values[:0]
+		seen := make(map[string]struct{}, len(values))
+		for _, v := range values {
+			if _, ok := seen[v]; !ok {
+				seen[v] = struct{}{}
+				deduplicated = append(deduplicated, v)
+			}
+		}
+		params[k] = deduplicated
+	}
+
+	// Encode 会按参数名排序
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
 func (r Request) marshal() ([]byte, error) {
 	cr := &cacheRequest{
-		URL:    r.URL(),
+		URL:    r.cacheURL(),
 		Method: r.Method(),
 	}
 
@@ -236,7 +477,7 @@ func (r Request) marshal() ([]byte, error) {
 		if cr.CacheKey != nil {
 			return cr.CacheKey, nil
 		} else {
-			return []byte(r.URL()), nil
+			return []byte(cr.URL), nil
 		}
 	}
 
@@ -248,7 +489,39 @@ func (r Request) Hash() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%x", sha1.Sum(cacheBody)), nil
+	hash := fmt.Sprintf("%x", sha1.Sum(cacheBody))
+	r.logPreimage(cacheBody, hash)
+	return hash, nil
+}
+
+// logPreimage logs the marshaled request hashed into the cache key at the
+// debug level, to find out why two similar requests have different keys
+func (r Request) logPreimage(cacheBody []byte, hash string) {
+	if r.crawler == nil || r.crawler.log == nil {
+		return
+	}
+
+	e := r.crawler.log.L.Debug()
+	// 非 debug 级别时不序列化
+	if !e.Enabled() {
+		return
+	}
+
+	e = e.Uint32("request_id", atomic.LoadUint32(&r.ID)).
+		Str("method", r.Method()).
+		Str("url", r.URL())
+	if r.cachedMap != nil {
+		e = e.Str("cached_map", string(marshalCachedMap(r.cachedMap)))
+	}
+
+	// 二进制的请求体编码为 base64 后再输出
+	if utf8.Valid(cacheBody) {
+		e = e.Str("preimage", string(cacheBody))
+	} else {
+		e = e.Str("preimage_base64", base64.StdEncoding.EncodeToString(cacheBody))
+	}
+
+	e.Str("cache_key", hash).Msg("cache key preimage")
 }
 
 func (r *Request) Reset() {
@@ -268,6 +541,19 @@ func (r *Request) Reset() {
 	r.crawler = nil
 	r.retryCounter = 0
 	r.maxRedirectsCount = 0
+	// 复用的请求不能沿用上一个请求的超时时间
+	r.timeout = 0
+	r.compression = ""
+	r.ownCtx = false
+	r.priority = 0
+	r.handled = false
+	r.metaRefreshCount = 0
+	r.done = nil
+	r.capture = nil
+	r.firstAttempt = time.Time{}
+	r.noContentType = false
+	r.hostSlot = nil
+	r.returnNetErr = false
 }
 
 var (
@@ -304,8 +590,13 @@ func AcquireRequestHeader() *fasthttp.RequestHeader {
 // ReleaseRequest returns req acquired via AcquireRequest to request pool.
 //
 // It is forbidden accessing req and/or its' members after returning
-// it to request pool.
+// it to request pool. The context is released as well if it is owned by
+// the request, such as the context of a request returned by `Spawn`.
 func ReleaseRequest(req *Request) {
+	// 上下文由请求自己申请时与请求一起释放，如派生的请求
+	if req.ownCtx {
+		pctx.ReleaseCtx(req.Ctx)
+	}
 	req.Reset()
 	requestPool.Put(req)
 }
@@ -336,6 +627,42 @@ func NewMultipartForm(dash string, f CustomRandomBoundary) *MultipartForm {
 	}
 }
 
+// SetBoundary overrides the boundary of the form with `dash` followed by
+// the value generated by `f`, such as for endpoints which require a
+// specific boundary format.
+//
+// SetBoundary must be called before any field is appended, and the
+// boundary must consist of 1 to 70 characters allowed by RFC 2046.
+func (mf *MultipartForm) SetBoundary(dash string, f CustomRandomBoundary) error {
+	if mf.buf.Len() > 0 {
+		return ErrBoundaryAfterWrite
+	}
+
+	boundary := dash + f()
+	if len(boundary) < 1 || len(boundary) > 70 {
+		return ErrInvalidBoundary
+	}
+	end := len(boundary) - 1
+	for i, b := range boundary {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' {
+			continue
+		}
+		switch b {
+		case '\'', '(', ')', '+', '_', ',', '-', '.', '/', ':', '=', '?':
+			continue
+		case ' ':
+			// 空格不能作为 boundary 的最后一个字符
+			if i != end {
+				continue
+			}
+		}
+		return ErrInvalidBoundary
+	}
+
+	mf.boundary = boundary
+	return nil
+}
+
 // Boundary returns the Writer's boundary.
 func (mf *MultipartForm) Boundary() string {
 	return mf.boundary
@@ -378,9 +705,7 @@ func getMimeType(buf []byte) string {
 	return http.DetectContentType(buf)
 }
 
-func (mf *MultipartForm) AppendFile(name, filePath string) error {
-	_, filename := filepath.Split(filePath)
-
+func (mf *MultipartForm) appendFile(name, filename, contentType string, data []byte) {
 	mf.appendHead()
 	mf.buf.WriteString(`Content-Disposition: form-data; name="`)
 	mf.buf.WriteString(name)
@@ -389,26 +714,104 @@ func (mf *MultipartForm) AppendFile(name, filePath string) error {
 	mf.buf.WriteByte('"')
 	mf.buf.WriteString("\r\nContent-Type: ")
 
+	if contentType == "" {
+		// 只会使用前 512 个字节检测文件的类型
+		contentType = getMimeType(data)
+	}
+	mf.buf.WriteString(contentType)
+	mf.buf.WriteString("\r\n\r\n")
+
+	mf.buf.Write(data)
+
+	mf.appendTail()
+}
+
+func (mf *MultipartForm) AppendFile(name, filePath string) error {
+	_, filename := filepath.Split(filePath)
+
 	fileBytes, err := os.ReadFile(filePath)
 	if err != nil {
 		return err
 	}
 
-	// 只需要使用前 512 个字节即可检测出一个文件的类型
-	contentType := getMimeType(fileBytes[:512])
+	mf.appendFile(name, filename, "", fileBytes)
 
-	mf.buf.WriteString(contentType)
-	mf.buf.WriteString("\r\n\r\n")
+	mf.bodyMap[filename] = filePath
 
-	mf.buf.Write(fileBytes)
+	return nil
+}
 
-	mf.appendTail()
+// AppendFileWithContentType is like `AppendFile`, but declares the
+// Content-Type of the file part as `contentType` instead of detecting it
+// from the content, which is required by some APIs.
+//
+// If `contentType` is empty, it is guessed from the extension of the file
+// first. The content type doesn't affect the value of the file used as a
+// cache field, which is still the file path.
+func (mf *MultipartForm) AppendFileWithContentType(name, filePath, contentType string) error {
+	_, filename := filepath.Split(filePath)
+
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	mf.appendFile(name, filename, typeByExtension(filename, contentType), fileBytes)
 
 	mf.bodyMap[filename] = filePath
 
 	return nil
 }
 
+// AppendBytes appends a file part whose content is `data` in memory,
+// so that a generated file doesn't have to be written to disk first.
+//
+// As there is no file path, the sha1 of `data` is used as the value
+// of `filename` when `filename` is used as a cache field.
+func (mf *MultipartForm) AppendBytes(name, filename string, data []byte) {
+	mf.appendFile(name, filename, "", data)
+
+	mf.bodyMap[filename] = fmt.Sprintf("%x", sha1.Sum(data))
+}
+
+// AppendReader appends a file part whose content is read from `r`,
+// see `AppendBytes`.
+func (mf *MultipartForm) AppendReader(name, filename string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	mf.AppendBytes(name, filename, data)
+
+	return nil
+}
+
+// AppendReaderWithContentType is like `AppendReader`, but declares the
+// Content-Type of the file part, see `AppendFileWithContentType`.
+func (mf *MultipartForm) AppendReaderWithContentType(name, filename string, r io.Reader, contentType string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	mf.appendFile(name, filename, typeByExtension(filename, contentType), data)
+
+	mf.bodyMap[filename] = fmt.Sprintf("%x", sha1.Sum(data))
+
+	return nil
+}
+
+// typeByExtension returns `contentType` if it is not empty, otherwise
+// the type guessed from the extension of `filename`, which is empty
+// when the extension is unknown.
+func typeByExtension(filename, contentType string) string {
+	if contentType != "" {
+		return contentType
+	}
+	return mime.TypeByExtension(filepath.Ext(filename))
+}
+
 func (mf *MultipartForm) Bytes() []byte {
 	bodyBoundary := "--" + mf.boundary + "--"
 	mf.buf.WriteString(bodyBoundary)
@@ -9,12 +9,19 @@
 package predator
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"net"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	ctx "github.com/go-predator/predator/context"
+	"github.com/go-predator/predator/html"
 	"github.com/go-predator/predator/json"
 	"github.com/valyala/bytebufferpool"
 	"github.com/valyala/fasthttp"
@@ -22,6 +29,9 @@ import (
 
 var (
 	ErrIncorrectResponse = errors.New("the response status code is not 20X")
+	ErrNotJSONArray      = errors.New("the value of the path is not a json array")
+	ErrNotHTMLResponse   = errors.New(`the "Content-Type" of the response header is not of the "html" type`)
+	ErrNotJSONResponse   = errors.New("the body of the response is not a valid json")
 )
 
 type Response struct {
@@ -37,14 +47,47 @@ type Response struct {
 	Headers fasthttp.ResponseHeader
 	// 是否从缓存中取得的响应
 	FromCache bool
-	// 客户端公网 ip
+	// 连接的远程地址，直连时是服务器的地址，使用代理时是代理的地址
 	clientIP net.Addr
-	// 本地局域网 ip
+	// 连接的本地地址
 	localIP net.Addr
 	timeout bool
 	// Whether the response is valid,
 	// html for invalid responses will not be parsed
 	invalid bool
+	// Whether the response handler asks for a retry
+	retry bool
+	// Whether the response still meets the retry condition after the last retry
+	retriesExhausted bool
+	// The error returned by the response validator, see `WithResponseValidator`
+	validationErr error
+	// Override the cache condition, see `DoNotCache` and `ForceCache`
+	doNotCache, forceCache bool
+	// The ttl of the cache, see `SetCacheTTL`
+	cacheTTL time.Duration
+	// The parsed html document, which is shared by all the handlers
+	doc *goquery.Document
+	// The url where the response came from after following redirects
+	finalURL string
+	// The parsed json body, see `JSON`
+	json *json.JSONResult
+	// Timing of the request, see `WithTracing`
+	timing Timing
+}
+
+// Timing is the timing of a request, which is recorded only if the crawler
+// is created with `WithTracing`.
+//
+// fasthttp has no hooks like `httptrace`, so the time of resolving, dialing,
+// tls handshake and waiting for the first byte are all included in `Total`.
+type Timing struct {
+	// When the request is sent
+	Start time.Time
+	// Time spent waiting for a free slot of the host, see `WithPerHostConcurrency`
+	Wait time.Duration
+	// Time from sending the request to receiving the whole response,
+	// including the redirects
+	Total time.Duration
 }
 
 // Save writes response body to disk
@@ -52,11 +95,136 @@ func (r *Response) Save(fileName string) error {
 	return os.WriteFile(fileName, r.Body, 0644)
 }
 
+// SaveJSON saves the json body to `fileName` with indentation, which is
+// easier to read than `Save` when debugging json APIs.
+//
+// ErrNotJSONResponse is returned if the body is not a valid json.
+func (r *Response) SaveJSON(fileName string) error {
+	b, err := json.Indent(r.Body, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotJSONResponse, err)
+	}
+	return os.WriteFile(fileName, append(b, '\n'), 0644)
+}
+
 // Invalidate marks the current response as invalid and skips the html parsing process
 func (r *Response) Invalidate() {
 	r.invalid = true
 }
 
+// Retry marks the current response as unusable, such as a logical error
+// in the body of a response with the status code 200, and asks the crawler
+// to re-issue the request after the `AfterResponse` handlers.
+//
+// The remaining response handlers will be skipped and the response will
+// not be cached. The request is retried only if the number of retries is
+// less than the count set by `WithRetry`, otherwise the response will be
+// treated as an invalid one, see `Invalidate`.
+func (r *Response) Retry() {
+	r.retry = true
+	r.invalid = true
+}
+
+// DoNotCache asks the crawler not to cache the response even if it meets the
+// cache condition, which should be called by the `AfterResponse` handlers,
+// since the response is cached before the html and json handlers.
+func (r *Response) DoNotCache() {
+	r.doNotCache = true
+	r.forceCache = false
+}
+
+// ForceCache asks the crawler to cache the response even if it doesn't meet
+// the cache condition, which should be called by the `AfterResponse`
+// handlers. The last call of `DoNotCache` and `ForceCache` takes effect.
+//
+// The response asking for a retry is never cached, see `Retry`. The forced
+// response is marshaled after the handlers, so the changes made by the
+// handlers are cached too.
+func (r *Response) ForceCache() {
+	r.forceCache = true
+	r.doNotCache = false
+}
+
+// SetCacheTTL sets the ttl of the cache of the response, such as a short one
+// for the price of a product and a long one for an article, which should be
+// called by the `AfterResponse` handlers.
+//
+// It takes effect only if the cache implements `TTLCache`, otherwise the
+// response is cached as usual.
+func (r *Response) SetCacheTTL(d time.Duration) {
+	r.cacheTTL = d
+}
+
+// Clone returns a deep copy of the response, which can be handled by another
+// goroutine while the crawler continues, such as by a worker pool of parsers.
+// The body, the headers and the request are copied, and the parsed html and
+// json are parsed again from the copied body when needed.
+//
+// If `cloneCtx` is true, the context is copied, so the changes made by the
+// copy are not visible to the original response and vice versa, and the copy
+// should be released with `ReleaseResponse(copy, true)`. Otherwise the
+// context is shared, whose changes are visible to both, and the copy should
+// be released with `ReleaseResponse(copy, false)`, note that the shared
+// context is released with the original response, so it must not be used
+// after that. The contexts are safe for concurrent use in both cases.
+func (r *Response) Clone(cloneCtx bool) (*Response, error) {
+	c := r.Ctx
+	if cloneCtx && c != nil {
+		var err error
+		c, err = ctx.Clone(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nr := AcquireResponse()
+	nr.StatusCode = r.StatusCode
+	nr.Body = append(nr.Body[:0], r.Body...)
+	nr.Ctx = c
+	if r.Request != nil {
+		nr.Request = r.Request.clone(c)
+	}
+	r.Headers.CopyTo(&nr.Headers)
+	nr.FromCache = r.FromCache
+	nr.clientIP = r.clientIP
+	nr.localIP = r.localIP
+	nr.timeout = r.timeout
+	nr.invalid = r.invalid
+	nr.retry = r.retry
+	nr.retriesExhausted = r.retriesExhausted
+	nr.validationErr = r.validationErr
+	nr.doNotCache = r.doNotCache
+	nr.forceCache = r.forceCache
+	nr.cacheTTL = r.cacheTTL
+	nr.finalURL = r.finalURL
+	nr.timing = r.timing
+
+	return nr, nil
+}
+
+// RetriesExhausted reports whether the response still meets the retry
+// condition set by `WithRetry`, but the request can't be retried any more
+// because the number of retries is exhausted or the max retry duration is
+// exceeded, so the last failed response is returned.
+//
+// A response of a request succeeded after some retries is not exhausted,
+// and the number of retries can be got by `Request.NumberOfRetries`.
+//
+// It is always false for the cached responses and the responses asking
+// for a retry by `Retry`, which are checked after the response handlers.
+func (r *Response) RetriesExhausted() bool {
+	return r.retriesExhausted
+}
+
+// ValidationError returns the error returned by the response validator set
+// by `WithResponseValidator`, nil if the response is valid.
+//
+// The response failed to be validated is marked as invalid after the
+// `AfterResponse` handlers, so it can be checked in these handlers.
+func (r *Response) ValidationError() error {
+	return r.validationErr
+}
+
 func (r *Response) GetSetCookie() string {
 	return string(r.Headers.Peek("Set-Cookie"))
 }
@@ -65,11 +233,57 @@ func (r *Response) ContentType() string {
 	return string(r.Headers.Peek("Content-Type"))
 }
 
+// HTML returns the parsed html document of the response, which is parsed
+// only once and shared with the `ParseHTML` and `OnHTMLDocument` handlers.
+//
+// ErrNotHTMLResponse is returned if the "Content-Type" of the response is
+// not html.
+func (r *Response) HTML() (*goquery.Document, error) {
+	if r.doc != nil {
+		return r.doc, nil
+	}
+
+	if !strings.Contains(strings.ToLower(r.ContentType()), "html") {
+		return nil, ErrNotHTMLResponse
+	}
+
+	doc, err := html.ParseHTML(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.doc = doc
+
+	return doc, nil
+}
+
+// JSON returns the parsed json body of the response, which is parsed only
+// once. A zero JSONResult is returned if the body is not a valid json, so it
+// is safe to be called on any response, including the cached ones.
+func (r *Response) JSON() json.JSONResult {
+	if r.json == nil {
+		var result json.JSONResult
+		if json.ValidBytes(r.Body) {
+			result = json.ParseBytesToJSON(r.Body)
+		}
+		r.json = &result
+	}
+	return *r.json
+}
+
+// GetJSON searches the json body of the response for the specified path,
+// see `JSON`.
+func (r *Response) GetJSON(path string) json.JSONResult {
+	return r.JSON().Get(path)
+}
+
 // BodyGunzip returns un-gzipped body data.
 //
 // This method may be used if the response header contains
 // 'Content-Encoding: gzip' for reading un-gzipped body.
 // Use Body for reading gzipped response body.
+//
+// The body has been decoded if the crawler is created with
+// `WithAcceptEncoding`.
 func (r *Response) BodyGunzip() ([]byte, error) {
 	var bb bytebufferpool.ByteBuffer
 	_, err := fasthttp.WriteGunzip(&bb, r.Body)
@@ -83,6 +297,43 @@ func (r *Response) String() string {
 	return string(r.Body)
 }
 
+// Reader returns an io.Reader over the body, which reads from the already
+// buffered body instead of the connection.
+func (r *Response) Reader() io.Reader {
+	return bytes.NewReader(r.Body)
+}
+
+// ReadCloser returns an io.ReadCloser over the body for the APIs requiring
+// a closer, see `Reader`. Closing it does nothing.
+func (r *Response) ReadCloser() io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(r.Body))
+}
+
+// IsSuccess reports whether the status code of the response is 2xx.
+func (r *Response) IsSuccess() bool {
+	return StatusCode(r.StatusCode).IsSuccess()
+}
+
+// IsRedirect reports whether the status code of the response is 3xx.
+func (r *Response) IsRedirect() bool {
+	return StatusCode(r.StatusCode).IsRedirect()
+}
+
+// IsClientError reports whether the status code of the response is 4xx.
+func (r *Response) IsClientError() bool {
+	return StatusCode(r.StatusCode).IsClientError()
+}
+
+// IsServerError reports whether the status code of the response is 5xx.
+func (r *Response) IsServerError() bool {
+	return StatusCode(r.StatusCode).IsServerError()
+}
+
+// IsError reports whether the status code of the response is 4xx or 5xx.
+func (r *Response) IsError() bool {
+	return StatusCode(r.StatusCode).IsError()
+}
+
 func (r *Response) Reset(releaseCtx bool) {
 	r.StatusCode = 0
 	if r.Body != nil {
@@ -96,10 +347,24 @@ func (r *Response) Reset(releaseCtx bool) {
 		ctx.ReleaseCtx(r.Ctx)
 	}
 
-	ReleaseRequest(r.Request)
+	if r.Request != nil {
+		ReleaseRequest(r.Request)
+		// 复用的响应不能引用已释放的请求
+		r.Request = nil
+	}
 	r.Headers.Reset()
 	r.FromCache = false
 	r.invalid = false
+	r.retry = false
+	r.retriesExhausted = false
+	r.validationErr = nil
+	r.doNotCache = false
+	r.forceCache = false
+	r.cacheTTL = 0
+	r.doc = nil
+	r.finalURL = ""
+	r.json = nil
+	r.timing = Timing{}
 	r.localIP = nil
 	r.clientIP = nil
 }
@@ -155,6 +420,9 @@ func (r *Response) Marshal() ([]byte, error) {
 	return json.Marshal(cr)
 }
 
+// Unmarshal restores the response from the cache. The "Content-Type" of
+// the response is restored too, so the json and html handlers work the same
+// on the cached responses as on the new ones.
 func (r *Response) Unmarshal(cachedBody []byte) error {
 	var (
 		cr  cachedResponse
@@ -175,6 +443,8 @@ func (r *Response) Unmarshal(cachedBody []byte) error {
 	return nil
 }
 
+// LocalIP returns the local address of the connection, which is empty if
+// the response is from the cache.
 func (r *Response) LocalIP() string {
 	if r.localIP != nil {
 		return r.localIP.String()
@@ -182,6 +452,12 @@ func (r *Response) LocalIP() string {
 	return ""
 }
 
+// ClientIP returns the remote address of the connection that the response
+// is received from, which is
+//
+//   - the address of the server if no proxy is used
+//   - the address of the proxy if a proxy is used
+//   - empty if the response is from the cache, because no connection is made
 func (r *Response) ClientIP() string {
 	if r.clientIP != nil {
 		return r.clientIP.String()
@@ -189,6 +465,79 @@ func (r *Response) ClientIP() string {
 	return ""
 }
 
+// Timing returns the timing of the request, which is zero if the tracing is
+// not enabled or the response is from the cache, see `WithTracing`.
+func (r *Response) Timing() Timing {
+	return r.timing
+}
+
+// Time is a shortcut of `Timing().Total`.
+func (r *Response) Time() time.Duration {
+	return r.timing.Total
+}
+
+// JSONStream calls `fn` with the elements of the json array at `path` of the
+// body one by one, until `fn` returns false. If `path` is empty, the body
+// itself should be the array.
+//
+// The elements are scanned lazily by gjson instead of unmarshaling the whole
+// array, which saves a lot of memory for large arrays.
+func (r *Response) JSONStream(path string, fn func(json.JSONResult) bool) error {
+	var arr json.JSONResult
+	if path == "" {
+		arr = json.ParseBytesToJSON(r.Body)
+	} else {
+		arr = json.GetBytes(r.Body, path)
+	}
+
+	if !arr.IsArray() {
+		return ErrNotJSONArray
+	}
+
+	arr.ForEach(func(_, value json.JSONResult) bool {
+		return fn(value)
+	})
+
+	return nil
+}
+
+// Proto returns the protocol of the response, such as "HTTP/1.1".
+//
+// The crawler is based on fasthttp, which doesn't support HTTP/2, so the
+// protocol is always HTTP/1.x. It is empty for the cached responses.
+func (r *Response) Proto() string {
+	if r.FromCache {
+		return ""
+	}
+	return string(r.Headers.Protocol())
+}
+
+// RequestURL returns the url of the original request.
+func (r *Response) RequestURL() string {
+	if r.Request == nil {
+		return ""
+	}
+	return r.Request.URL()
+}
+
+// FinalURL returns the url where the response came from, which differs
+// from `RequestURL` if the request is redirected, see `Request.AllowRedirect`.
+//
+// The redirects are not cached, so it is always the same as `RequestURL`
+// for the cached responses.
+func (r *Response) FinalURL() string {
+	if r.finalURL == "" {
+		return r.RequestURL()
+	}
+	return r.finalURL
+}
+
+// Redirected reports whether the response came from a url other than the
+// requested one. It is always false for the cached responses.
+func (r *Response) Redirected() bool {
+	return r.FinalURL() != r.RequestURL()
+}
+
 func (r *Response) IsTimeout() bool {
 	return r.timeout
 }
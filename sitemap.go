@@ -0,0 +1,122 @@
+package predator
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-predator/log"
+	"github.com/valyala/fasthttp"
+)
+
+var ErrNoSitemap = errors.New("no sitemap is found")
+
+// maxSitemapDepth is the max depth of the nested sitemap index files
+const maxSitemapDepth = 5
+
+// sitemap is either a `urlset` or a `sitemapindex`
+type sitemap struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// Sitemap fetches the sitemap of the site and returns the urls in it.
+//
+// If the path of `rootURL` ends with ".xml" or ".xml.gz", it is used as the
+// sitemap, otherwise "/sitemap.xml" and "/sitemap.xml.gz" of the site are
+// tried in turn. The sitemap index files are fetched recursively, and the
+// gzipped sitemaps are decompressed.
+//
+// The sitemaps are fetched with the user agent, the cookies, the proxies
+// and the cache of the crawler, but the handlers are not called.
+func (c *Crawler) Sitemap(rootURL string) ([]string, error) {
+	u, err := url.Parse(rootURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	if strings.HasSuffix(u.Path, ".xml") || strings.HasSuffix(u.Path, ".xml.gz") {
+		candidates = []string{u.String()}
+	} else {
+		for _, p := range []string{"/sitemap.xml", "/sitemap.xml.gz"} {
+			candidates = append(candidates, u.ResolveReference(&url.URL{Path: p}).String())
+		}
+	}
+
+	var (
+		urls    []string
+		visited = make(map[string]bool)
+	)
+	for _, sitemapURL := range candidates {
+		err = c.parseSitemap(sitemapURL, 0, visited, &urls)
+		if err == nil {
+			return urls, nil
+		}
+		if !errors.Is(err, ErrNoSitemap) {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+func (c *Crawler) parseSitemap(sitemapURL string, depth int, visited map[string]bool, urls *[]string) error {
+	if visited[sitemapURL] || depth > maxSitemapDepth {
+		return nil
+	}
+	visited[sitemapURL] = true
+
+	resp, err := c.fetch(MethodGet, sitemapURL)
+	if err != nil {
+		return err
+	}
+	defer ReleaseResponse(resp, false)
+
+	if !resp.IsSuccess() {
+		return fmt.Errorf("%w: %s responds with status code %d", ErrNoSitemap, sitemapURL, resp.StatusCode)
+	}
+
+	body := resp.Body
+	// gzip 格式的文件以 0x1f 0x8b 开头
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		body, err = fasthttp.AppendGunzipBytes(nil, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	var sm sitemap
+	if err = xml.Unmarshal(body, &sm); err != nil {
+		return fmt.Errorf("invalid sitemap %s: %w", sitemapURL, err)
+	}
+
+	for _, u := range sm.URLs {
+		if loc := strings.TrimSpace(u.Loc); loc != "" {
+			*urls = append(*urls, loc)
+		}
+	}
+
+	for _, s := range sm.Sitemaps {
+		loc := strings.TrimSpace(s.Loc)
+		if loc == "" {
+			continue
+		}
+		// 一个子站点地图出错不影响其他的子站点地图
+		if err = c.parseSitemap(loc, depth+1, visited, urls); err != nil {
+			c.Warning("failed to fetch the sitemap",
+				log.Arg{Key: "sitemap", Value: loc},
+				log.Arg{Key: "error", Value: err.Error()},
+			)
+		}
+	}
+
+	return nil
+}
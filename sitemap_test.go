@@ -0,0 +1,148 @@
+package predator
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/valyala/fasthttp"
+)
+
+func sitemapServer(files map[string]string, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/xml")
+		if strings.HasSuffix(r.URL.Path, ".gz") {
+			w.Write(fasthttp.AppendGzipBytes(nil, []byte(content)))
+			return
+		}
+		w.Write([]byte(content))
+	}))
+}
+
+func urlset(locs ...string) string {
+	s := `<?xml version="1.0" encoding="UTF-8"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`
+	for _, loc := range locs {
+		s += fmt.Sprintf("<url><loc>%s</loc></url>", loc)
+	}
+	return s + "</urlset>"
+}
+
+func TestSitemap(t *testing.T) {
+	Convey("测试站点地图", t, func() {
+		Convey("站点地图索引", func() {
+			var hits int32
+			files := map[string]string{
+				"/a.xml":    urlset("http://example.com/1", "http://example.com/2"),
+				"/b.xml.gz": urlset("http://example.com/3"),
+			}
+			ts := sitemapServer(files, &hits)
+			defer ts.Close()
+
+			files["/sitemap.xml"] = fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>%[1]s/a.xml</loc></sitemap>
+	<sitemap><loc>%[1]s/b.xml.gz</loc></sitemap>
+	<sitemap><loc>%[1]s/missing.xml</loc></sitemap>
+	<sitemap><loc>%[1]s/sitemap.xml</loc></sitemap>
+</sitemapindex>`, ts.URL)
+
+			c := NewCrawler(WithCache(new(memoryCache), false, nil))
+
+			var handled bool
+			c.AfterResponse(func(r *Response) {
+				handled = true
+			})
+
+			urls, err := c.Sitemap(ts.URL)
+			So(err, ShouldBeNil)
+			So(urls, ShouldResemble, []string{"http://example.com/1", "http://example.com/2", "http://example.com/3"})
+			So(handled, ShouldBeFalse)
+			So(atomic.LoadInt32(&hits), ShouldEqual, 4)
+
+			// 缓存的站点地图
+			urls, err = c.Sitemap(ts.URL + "/a.xml")
+			So(err, ShouldBeNil)
+			So(urls, ShouldHaveLength, 2)
+			So(atomic.LoadInt32(&hits), ShouldEqual, 4)
+		})
+
+		Convey("无法连接的子站点地图", func() {
+			var hits int32
+			files := map[string]string{
+				"/a.xml": urlset("http://example.com/1"),
+			}
+			ts := sitemapServer(files, &hits)
+			defer ts.Close()
+
+			files["/sitemap.xml"] = fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>http://127.0.0.1:1/sitemap.xml</loc></sitemap>
+	<sitemap><loc>%s/a.xml</loc></sitemap>
+</sitemapindex>`, ts.URL)
+
+			c := NewCrawler(WithLogger(nil))
+
+			urls, err := c.Sitemap(ts.URL + "/sitemap.xml")
+			So(err, ShouldBeNil)
+			So(urls, ShouldResemble, []string{"http://example.com/1"})
+		})
+
+		Convey("gzip 格式的站点地图", func() {
+			var hits int32
+			ts := sitemapServer(map[string]string{
+				"/sitemap.xml.gz": urlset("http://example.com/gz"),
+			}, &hits)
+			defer ts.Close()
+
+			urls, err := NewCrawler().Sitemap(ts.URL + "/")
+			So(err, ShouldBeNil)
+			So(urls, ShouldResemble, []string{"http://example.com/gz"})
+		})
+
+		Convey("使用爬虫的请求头", func() {
+			var ua, header, cookie string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ua, header = r.UserAgent(), r.Header.Get("X-Test")
+				if ck, err := r.Cookie("token"); err == nil {
+					cookie = ck.Value
+				}
+				w.Write([]byte(urlset("http://example.com/1")))
+			}))
+			defer ts.Close()
+
+			c := NewCrawler(
+				WithUserAgent("predator-test"),
+				WithHeaders(map[string]string{"X-Test": "sitemap"}),
+				WithCookies(map[string]string{"token": "abc"}),
+			)
+
+			urls, err := c.Sitemap(ts.URL + "/sitemap.xml")
+			So(err, ShouldBeNil)
+			So(urls, ShouldHaveLength, 1)
+			So(ua, ShouldEqual, "predator-test")
+			So(header, ShouldEqual, "sitemap")
+			So(cookie, ShouldEqual, "abc")
+		})
+
+		Convey("没有站点地图", func() {
+			var hits int32
+			ts := sitemapServer(map[string]string{}, &hits)
+			defer ts.Close()
+
+			_, err := NewCrawler().Sitemap(ts.URL)
+			So(err, ShouldWrap, ErrNoSitemap)
+		})
+	})
+}
@@ -84,3 +84,41 @@ const (
 func StatusMessage(statusCode int) string {
 	return fasthttp.StatusMessage(statusCode)
 }
+
+// StatusCode is the HTTP status code of a response.
+type StatusCode int
+
+// String returns HTTP status message of the status code.
+func (s StatusCode) String() string {
+	return StatusMessage(int(s))
+}
+
+// IsInformational reports whether the status code is 1xx.
+func (s StatusCode) IsInformational() bool {
+	return s >= 100 && s < 200
+}
+
+// IsSuccess reports whether the status code is 2xx.
+func (s StatusCode) IsSuccess() bool {
+	return s >= 200 && s < 300
+}
+
+// IsRedirect reports whether the status code is 3xx.
+func (s StatusCode) IsRedirect() bool {
+	return s >= 300 && s < 400
+}
+
+// IsClientError reports whether the status code is 4xx.
+func (s StatusCode) IsClientError() bool {
+	return s >= 400 && s < 500
+}
+
+// IsServerError reports whether the status code is 5xx.
+func (s StatusCode) IsServerError() bool {
+	return s >= 500 && s < 600
+}
+
+// IsError reports whether the status code is 4xx or 5xx.
+func (s StatusCode) IsError() bool {
+	return s.IsClientError() || s.IsServerError()
+}
@@ -0,0 +1,54 @@
+package predator
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		code                                                 int
+		info, success, redirect, clientErr, serverErr, isErr bool
+	}{
+		{0, false, false, false, false, false, false},
+		{99, false, false, false, false, false, false},
+		{100, true, false, false, false, false, false},
+		{199, true, false, false, false, false, false},
+		{200, false, true, false, false, false, false},
+		{299, false, true, false, false, false, false},
+		{300, false, false, true, false, false, false},
+		{399, false, false, true, false, false, false},
+		{400, false, false, false, true, false, true},
+		{499, false, false, false, true, false, true},
+		{500, false, false, false, false, true, true},
+		{599, false, false, false, false, true, true},
+		{600, false, false, false, false, false, false},
+	}
+
+	Convey("测试状态码分类", t, func() {
+		for _, tt := range tests {
+			Convey(fmt.Sprint(tt.code), func() {
+				s := StatusCode(tt.code)
+				So(s.IsInformational(), ShouldEqual, tt.info)
+				So(s.IsSuccess(), ShouldEqual, tt.success)
+				So(s.IsRedirect(), ShouldEqual, tt.redirect)
+				So(s.IsClientError(), ShouldEqual, tt.clientErr)
+				So(s.IsServerError(), ShouldEqual, tt.serverErr)
+				So(s.IsError(), ShouldEqual, tt.isErr)
+
+				r := &Response{StatusCode: tt.code}
+				So(r.IsSuccess(), ShouldEqual, tt.success)
+				So(r.IsRedirect(), ShouldEqual, tt.redirect)
+				So(r.IsClientError(), ShouldEqual, tt.clientErr)
+				So(r.IsServerError(), ShouldEqual, tt.serverErr)
+				So(r.IsError(), ShouldEqual, tt.isErr)
+			})
+		}
+	})
+
+	Convey("测试状态码信息", t, func() {
+		So(StatusCode(StatusNotFound).String(), ShouldEqual, "Not Found")
+	})
+}
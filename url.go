@@ -0,0 +1,107 @@
+package predator
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// URLNormalizer converts the equivalent urls to the same one
+type URLNormalizer func(URL string) string
+
+// NormalizeURL is the default URLNormalizer, which
+//
+//   - lowercases the scheme and the host, and converts the IDN host to punycode
+//   - removes the default port
+//   - uses "/" as the empty path
+//   - uppercases the percent-encodings and decodes the unreserved characters
+//   - sorts the query parameters
+//   - strips the fragment
+//
+// The url is returned as is if it can't be parsed.
+func NormalizeURL(URL string) string {
+	u, err := url.Parse(URL)
+	if err != nil || u.Host == "" {
+		return URL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	host, port := u.Hostname(), u.Port()
+	if h, err := idna.Lookup.ToASCII(host); err == nil {
+		host = h
+	} else {
+		host = strings.ToLower(host)
+	}
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// ipv6
+		host = "[" + host + "]"
+	}
+	u.Host = host
+
+	path := normalizeEscapes(u.EscapedPath())
+	if path == "" {
+		path = "/"
+	}
+	if p, err := url.PathUnescape(path); err == nil {
+		u.Path, u.RawPath = p, path
+	}
+
+	u.RawQuery = u.Query().Encode()
+	u.Fragment, u.RawFragment = "", ""
+
+	return u.String()
+}
+
+// normalizeEscapes uppercases the percent-encodings and decodes the
+// unreserved characters, see RFC 3986 section 6.2.2
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
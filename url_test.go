@@ -0,0 +1,56 @@
+package predator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	Convey("测试规范化 url", t, func() {
+		for raw, normalized := range map[string]string{
+			"HTTP://Example.COM":                    "http://example.com/",
+			"http://example.com:80/a":               "http://example.com/a",
+			"https://example.com:443/a":             "https://example.com/a",
+			"https://example.com:8443/a":            "https://example.com:8443/a",
+			"http://example.com/a?b=2&a=1&a=0#frag": "http://example.com/a?a=1&a=0&b=2",
+			"http://example.com/%7euser/%e4%b8%ad":  "http://example.com/~user/%E4%B8%AD",
+			"http://example.com/a%2fb":              "http://example.com/a%2Fb",
+			"http://example.com/中文?q=中":             "http://example.com/%E4%B8%AD%E6%96%87?q=%E4%B8%AD",
+			"http://例子.测试/":                         "http://xn--fsqu00a.xn--0zwm56d/",
+			"http://Bücher.example/":                "http://xn--bcher-kva.example/",
+			"http://[::1]:80/":                      "http://[::1]/",
+			"http://[::1]:8080/":                    "http://[::1]:8080/",
+			"/relative/path":                        "/relative/path",
+			"http://example.com/a%zz":               "http://example.com/a%zz",
+		} {
+			So(NormalizeURL(raw), ShouldEqual, normalized)
+		}
+	})
+
+	Convey("测试请求中规范化 url", t, func() {
+		var paths []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.RequestURI())
+			w.Write([]byte("ok"))
+		}))
+		defer ts.Close()
+
+		c := NewCrawler(WithCache(new(memoryCache), false, nil), WithURLNormalizer(NormalizeURL))
+
+		var fromCache []bool
+		c.AfterResponse(func(r *Response) {
+			fromCache = append(fromCache, r.FromCache)
+		})
+
+		for _, u := range []string{ts.URL + "/%7ea?b=1&a=2#x", ts.URL + "/~a?a=2&b=1"} {
+			err := c.Get(u)
+			So(err, ShouldBeNil)
+		}
+
+		So(paths, ShouldResemble, []string{"/~a?a=2&b=1"})
+		So(fromCache, ShouldResemble, []bool{false, true})
+	})
+}